@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"receipt_qr_scanner/manual"
+	"receipt_qr_scanner/receipts"
+	"slices"
+	"strconv"
+
+	httputils "github.com/3bl3gamer/go-http-utils"
+	"github.com/ansel1/merry"
+	"github.com/julienschmidt/httprouter"
+)
+
+const apiV1Prefix = "/api/v1"
+
+// ReceiptsPage — страница списка чеков
+type ReceiptsPage struct {
+	Items []*receipts.Receipt `json:"items"`
+	// курсор следующей страницы (параметр cursor), пустой — это последняя страница
+	NextCursor string `json:"nextCursor,omitempty"`
+	// сколько всего чеков подходит под поиск, только при count=1
+	TotalCount *int64 `json:"totalCount,omitempty"`
+	// суммы этих чеков по валютам (символ валюты -> сумма, возвраты вычтены), только при count=1
+	Totals map[string]float64 `json:"totals,omitempty"`
+}
+
+type ReceiptCreateRequest struct {
+	RefText string `json:"refText"` //текст из QR-кода
+}
+
+type ReceiptDeleteResult struct {
+	ID int64 `json:"id"`
+}
+
+type ReceiptRefetchRequest struct {
+	ReceiptID int64 `json:"receiptId"`
+}
+
+type ReceiptRefetchResult struct {
+	ID int64 `json:"id"`
+}
+
+// apiV1Routes — методы /api/v1. Все отвечают {"ok": true, "result": ...}
+// или httputils.JsonError ({"ok": false, "code": ..., "error": ..., "description": ...}).
+func apiV1Routes(receiptsBroadcaster *ReceiptsBroadcaster) []apiRoute {
+	idParam := apiParam{Name: "id", In: "path", Type: "integer", Description: "ID чека"}
+	return []apiRoute{
+		{
+			Method:  "GET",
+			Path:    "/receipts",
+			Summary: "Список чеков (постранично)",
+			Params: []apiParam{
+				{Name: "sort_mode", In: "query", Type: "string", Enum: slices.Sorted(maps.Keys(receiptsSortModes)), Description: "по умолчанию id"},
+				{Name: "order", In: "query", Type: "string", Enum: []string{"desc", "asc"}, Description: "по умолчанию desc"},
+				{Name: "search", In: "query", Type: "string", Description: "подстрока в товарах, магазине, адресе и т.д."},
+				{Name: "limit", In: "query", Type: "integer", Description: "размер страницы, по умолчанию " + strconv.Itoa(receiptsPageDefaultSize) + ", не больше " + strconv.Itoa(receiptsPageMaxSize)},
+				{Name: "cursor", In: "query", Type: "string", Description: "nextCursor прошлой страницы (с теми же sort_mode и order)"},
+				{Name: "count", In: "query", Type: "boolean", Description: "посчитать totalCount и totals"},
+			},
+			Result: ReceiptsPage{},
+			Errors: map[int]string{400: "WRONG_SORT_MODE, WRONG_ORDER, WRONG_LIMIT, WRONG_CURSOR"},
+			Chain:  []interface{}{withGzip, HandleAPIV1ReceiptsList},
+		},
+		{
+			Method:  "POST",
+			Path:    "/receipts",
+			Summary: "Добавление чека по тексту из QR-кода",
+			Body:    ReceiptCreateRequest{},
+			Result:  ReceiptSubmitResult{},
+			Errors:  map[int]string{400: "WRONG_BODY, WRONG_REF, WRONG_VALUE_*, MISSING_VALUE_* (в description — неверное значение)"},
+			Chain:   []interface{}{HandleAPIV1ReceiptCreate},
+		},
+		{
+			Method:  "POST",
+			Path:    "/receipts/manual",
+			Summary: "Добавление чека без QR-кода (данные вводятся вручную, сохраняются сразу)",
+			Body:    manual.ReceiptData{},
+			Result:  ReceiptSubmitResult{},
+			Errors:  map[int]string{400: "WRONG_BODY, WRONG_VALUE_*, MISSING_VALUE_* (в description — неверное значение)"},
+			Chain:   []interface{}{HandleAPIV1ManualReceiptCreate},
+		},
+		{
+			Method:  "GET",
+			Path:    "/receipts/:id",
+			Summary: "Чек",
+			Params:  []apiParam{idParam},
+			Result:  receipts.Receipt{},
+			Errors:  map[int]string{400: "WRONG_ID", 404: "RECEIPT_NOT_FOUND"},
+			Chain:   []interface{}{HandleAPIV1Receipt},
+		},
+		{
+			Method:  "DELETE",
+			Path:    "/receipts/:id",
+			Summary: "Удаление чека",
+			Params:  []apiParam{idParam},
+			Result:  ReceiptDeleteResult{},
+			Errors:  map[int]string{400: "WRONG_ID", 404: "RECEIPT_NOT_FOUND"},
+			Chain:   []interface{}{receiptsBroadcaster.HandleAPIV1ReceiptDelete},
+		},
+		{
+			Method:  "GET",
+			Path:    "/receipts/:id/data_versions",
+			Summary: "Прошлые данные чека (заменённые при повторном скачивании), от новых к старым",
+			Params:  []apiParam{idParam},
+			Result:  []ReceiptDataVersion{},
+			Errors:  map[int]string{400: "WRONG_ID", 404: "RECEIPT_NOT_FOUND"},
+			Chain:   []interface{}{HandleAPIV1ReceiptDataVersions},
+		},
+		{
+			Method:  "POST",
+			Path:    "/receipt_refetches",
+			Summary: "Повторное скачивание уже скачанного чека (прошлые данные сохранятся в data_versions)",
+			Body:    ReceiptRefetchRequest{},
+			Result:  ReceiptRefetchResult{},
+			Errors:  map[int]string{400: "WRONG_BODY, RECEIPT_NOT_FETCHED, RECEIPT_NOT_REFETCHABLE", 404: "RECEIPT_NOT_FOUND"},
+			Chain:   []interface{}{HandleAPIV1ReceiptRefetch},
+		},
+		{
+			Method:  "GET",
+			Path:    "/unrecognized_refs",
+			Summary: "Тексты QR-кодов, которые не разобрал ни один домен (при запуске разбираются заново)",
+			Result:  []UnrecognizedRef{},
+			Chain:   []interface{}{HandleAPIV1UnrecognizedRefs},
+		},
+		{
+			Method:  "GET",
+			Path:    "/domains",
+			Summary: "Поддерживаемые домены (страна + провайдер) и состояние их провайдеров",
+			Result:  []DomainMetadata{},
+			Chain:   []interface{}{HandleAPIDomainsMetadata},
+		},
+		{
+			Method:  "GET",
+			Path:    "/health",
+			Summary: "Состояние фоновых компонентов",
+			Result:  HealthReport{},
+			Chain:   []interface{}{HandleAPIHealth},
+		},
+	}
+}
+
+func HandleAPIV1ReceiptsList(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+	query := r.URL.Query()
+
+	listQuery, jsonErr := receiptsListQueryFromURL(query)
+	if jsonErr != nil {
+		return *jsonErr, nil
+	}
+	recs, nextCursor, err := loadReceiptsPage(db, listQuery)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	page := ReceiptsPage{Items: ensureRecsNotNil(recs)}
+	if nextCursor != nil {
+		page.NextCursor = nextCursor.Encode()
+	}
+	if countStr := query.Get("count"); countStr != "" && countStr != "0" && countStr != "false" {
+		count, err := countReceipts(db, listQuery.Search)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		page.TotalCount = &count
+		page.Totals, err = sumReceiptsByCurrency(db, listQuery.Search)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	return page, nil
+}
+
+func HandleAPIV1ReceiptCreate(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	var req ReceiptCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefText == "" {
+		return httputils.JsonError{Code: 400, Error: "WRONG_BODY", Description: `expected {"refText": "..."}`}, nil
+	}
+	return submitReceiptRefText(r.Context(), req.RefText)
+}
+
+func HandleAPIV1ManualReceiptCreate(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	var data manual.ReceiptData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return httputils.JsonError{Code: 400, Error: "WRONG_BODY", Description: err.Error()}, nil
+	}
+	ref, err := manual.NewReceiptRefFromData(data)
+	if jsonErr := receiptRefFieldJsonError(err); jsonErr != nil {
+		return *jsonErr, nil
+	} else if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	// дальше как с обычным текстом ссылки: поиск дубликатов, сохранение, события
+	return submitReceiptRefText(r.Context(), ref.RefText())
+}
+
+func HandleAPIV1Receipt(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		return httputils.JsonError{Code: 400, Error: "WRONG_ID"}, nil
+	}
+	rec, err := loadReceipt(db, id)
+	if merry.Is(err, sql.ErrNoRows) {
+		return httputils.JsonError{Code: 404, Error: "RECEIPT_NOT_FOUND"}, nil
+	} else if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return rec, nil
+}
+
+func HandleAPIV1ReceiptDataVersions(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		return httputils.JsonError{Code: 400, Error: "WRONG_ID"}, nil
+	}
+	if _, err := loadReceipt(db, id); merry.Is(err, sql.ErrNoRows) {
+		return httputils.JsonError{Code: 404, Error: "RECEIPT_NOT_FOUND"}, nil
+	} else if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return loadReceiptDataVersions(db, id)
+}
+
+func HandleAPIV1ReceiptRefetch(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+	cfg := r.Context().Value(CtxKeyConfig).(*Config)
+
+	var req ReceiptRefetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReceiptID == 0 {
+		return httputils.JsonError{Code: 400, Error: "WRONG_BODY", Description: `expected {"receiptId": 123}`}, nil
+	}
+	rec, err := loadReceipt(db, req.ReceiptID)
+	if merry.Is(err, sql.ErrNoRows) {
+		return httputils.JsonError{Code: 404, Error: "RECEIPT_NOT_FOUND"}, nil
+	} else if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	// скачивает только апдейтер, а у него есть клиенты только включённых доменов (и не у всех доменов они вообще есть)
+	domain2client := r.Context().Value(CtxKeyClients).(map[string]receipts.Client)
+	if _, ok := domain2client[rec.Domain]; !ok {
+		return httputils.JsonError{Code: 400, Error: "RECEIPT_NOT_REFETCHABLE", Description: rec.Domain}, nil
+	}
+
+	ok, err := requestReceiptRefetch(db, rec.ID, cfg.ReceiptRetries(rec.Domain))
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if !ok {
+		// ещё не скачан — и так скачивается (или попытки кончились)
+		return httputils.JsonError{Code: 400, Error: "RECEIPT_NOT_FETCHED"}, nil
+	}
+	updaterTriggerChan := r.Context().Value(CtxKeyTrigger).(chan struct{})
+	select {
+	case updaterTriggerChan <- struct{}{}:
+	default: //апдейтер и так уже будет запущен (или остановлен)
+	}
+	return ReceiptRefetchResult{ID: rec.ID}, nil
+}
+
+func HandleAPIV1UnrecognizedRefs(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+	return loadUnrecognizedRefs(db)
+}
+
+func (b *ReceiptsBroadcaster) HandleAPIV1ReceiptDelete(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		return httputils.JsonError{Code: 400, Error: "WRONG_ID"}, nil
+	}
+	found, err := deleteReceipt(db, id)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if !found {
+		return httputils.JsonError{Code: 404, Error: "RECEIPT_NOT_FOUND"}, nil
+	}
+	b.BroadcastDeleted(id)
+	return ReceiptDeleteResult{ID: id}, nil
+}
+
+// handleOpenAPISpec отдаёт OpenAPI-документ как есть (без обёртки {"ok": true, "result": ...})
+func handleOpenAPISpec(spec map[string]interface{}) func(http.ResponseWriter, *http.Request, httprouter.Params) error {
+	buf, err := json.MarshalIndent(spec, "", "  ")
+	return func(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		wr.Header().Set("Content-Type", "application/json")
+		_, err := wr.Write(buf)
+		return merry.Wrap(err)
+	}
+}
+
+// writeAPIError пишет JSON-ошибку там, где нет httputils.Wrapper (несуществующие методы АПИ)
+func writeAPIError(wr http.ResponseWriter, code int64, errCode string) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(int(code))
+	json.NewEncoder(wr).Encode(httputils.JsonError{Code: code, Error: errCode})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"receipt_qr_scanner/kz_wip"
+	"receipt_qr_scanner/manual"
+	"receipt_qr_scanner/receipts"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	httputils "github.com/3bl3gamer/go-http-utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+func startTestAPIV1Server(t *testing.T) (*httptest.Server, *ReceiptsBroadcaster) {
+	t.Helper()
+	db := openTestDB(t)
+	b := NewReceiptsBroadcaster()
+	updatedReceiptIDsChan := make(chan int64, 100)
+	wrapper := &httputils.Wrapper{
+		ExtraChainItem: func(handle httputils.HandlerExt) httputils.HandlerExt {
+			return func(wr http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+				ctx := r.Context()
+				ctx = context.WithValue(ctx, CtxKeyDB, db)
+				ctx = context.WithValue(ctx, CtxKeyConfig, &Config{})
+				ctx = context.WithValue(ctx, CtxKeyHealth, NewHealth(updaterComponent))
+				ctx = context.WithValue(ctx, CtxKeyBreaker, NewDomainBreaker(&Config{}))
+				ctx = context.WithValue(ctx, CtxKeyEvents, ReceiptEvents(nil))
+				ctx = context.WithValue(ctx, CtxKeyTrigger, make(chan struct{}, 100))
+				ctx = context.WithValue(ctx, CtxKeyUpdateRec, updatedReceiptIDsChan)
+				ctx = context.WithValue(ctx, CtxKeyClients, map[string]receipts.Client{"kz-wip": kz_wip.Domain.NewClient(receipts.ClientConfig{})})
+				return handle(wr, r.WithContext(ctx), params)
+			}
+		},
+		LogError: func(err error, r *http.Request) { t.Errorf("%s %s: %v", r.Method, r.URL, err) },
+	}
+	router := httprouter.New()
+	routes := apiV1Routes(b)
+	for _, r := range routes {
+		router.Handle(r.Method, apiV1Prefix+r.Path, wrapper.WrapChain(r.Chain...))
+	}
+	router.Handle("GET", apiV1Prefix+"/openapi.json", wrapper.WrapChain(handleOpenAPISpec(buildOpenAPISpec("test", "1", apiV1Prefix, routes))))
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, b
+}
+
+// newTestAPIV1Request — функция запроса к тестовому серверу: тело кодирует в JSON, ответ декодирует в res, возвращает HTTP-статус
+func newTestAPIV1Request(t *testing.T, srv *httptest.Server) func(method, path string, body interface{}, res interface{}) int {
+	return func(method, path string, body interface{}, res interface{}) int {
+		t.Helper()
+		var bodyBuf []byte
+		if body != nil {
+			bodyBuf, _ = json.Marshal(body)
+		}
+		req, err := http.NewRequest(method, srv.URL+apiV1Prefix+path, bytes.NewReader(bodyBuf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+		return resp.StatusCode
+	}
+}
+
+func TestAPIV1Receipts(t *testing.T) {
+	srv, b := startTestAPIV1Server(t)
+	request := newTestAPIV1Request(t, srv)
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		refText := "https://app.kassa.wipon.kz/consumer?i=561234001" + strconv.Itoa(i) + "&f=600407890704&s=2750.00&t=20260310T093045"
+		var res struct {
+			Ok     bool
+			Result ReceiptSubmitResult
+		}
+		if code := request("POST", "/receipts", ReceiptCreateRequest{RefText: refText}, &res); code != 200 || !res.Ok || res.Result.ID == 0 {
+			t.Fatalf("POST /receipts = %d %+v", code, res)
+		}
+		ids = append(ids, res.Result.ID)
+	}
+	var submitRes struct{ Result ReceiptSubmitResult }
+	refText := "https://app.kassa.wipon.kz/consumer?i=5612340010&f=600407890704&s=2750.00&t=20260310T093045"
+	if request("POST", "/receipts", ReceiptCreateRequest{RefText: refText}, &submitRes); !submitRes.Result.Exists || submitRes.Result.ID != ids[0] {
+		t.Errorf("POST existing = %+v, want exists #%d", submitRes.Result, ids[0])
+	}
+
+	var errRes httputils.JsonError
+	for _, c := range []struct {
+		Method, Path string
+		Body         interface{}
+		Code         int64
+		Error        string
+	}{
+		{"POST", "/receipts", map[string]int{"refText": 1}, 400, "WRONG_BODY"},
+		{"POST", "/receipts", ReceiptCreateRequest{RefText: "hello"}, 400, ""},
+		{"POST", "/receipts", ReceiptCreateRequest{RefText: "https://app.kassa.wipon.kz/consumer?i=5612340019&f=600407890704&s=abc&t=20260310T093045"}, 400, "WRONG_VALUE_S"},
+		{"GET", "/receipts/abc", nil, 400, "WRONG_ID"},
+		{"GET", "/receipts/12345", nil, 404, "RECEIPT_NOT_FOUND"},
+		{"GET", "/receipts?sort_mode=nope", nil, 400, "WRONG_SORT_MODE"},
+		{"GET", "/receipts?cursor=nope", nil, 400, "WRONG_CURSOR"},
+		{"DELETE", "/receipts/12345", nil, 404, "RECEIPT_NOT_FOUND"},
+		{"GET", "/receipts/12345/data_versions", nil, 404, "RECEIPT_NOT_FOUND"},
+		{"POST", "/receipt_refetches", map[string]string{"receiptId": "1"}, 400, "WRONG_BODY"},
+		{"POST", "/receipt_refetches", ReceiptRefetchRequest{ReceiptID: 12345}, 404, "RECEIPT_NOT_FOUND"},
+		{"POST", "/receipt_refetches", ReceiptRefetchRequest{ReceiptID: ids[0]}, 400, "RECEIPT_NOT_FETCHED"},
+	} {
+		errRes = httputils.JsonError{}
+		code := request(c.Method, c.Path, c.Body, &errRes)
+		if int64(code) != c.Code || errRes.Ok || errRes.Code != c.Code || (c.Error != "" && errRes.Error != c.Error) {
+			t.Errorf("%s %s = %d %+v, want %d %s", c.Method, c.Path, code, errRes, c.Code, c.Error)
+		}
+	}
+
+	var unrecRes struct{ Result []UnrecognizedRef }
+	if code := request("GET", "/unrecognized_refs", nil, &unrecRes); code != 200 || len(unrecRes.Result) != 1 || unrecRes.Result[0].RefText != "hello" {
+		t.Errorf("GET /unrecognized_refs = %d %+v, want 'hello'", code, unrecRes.Result)
+	}
+
+	// постранично
+	var got []int64
+	path := "/receipts?limit=2&count=1"
+	for path != "" {
+		var res struct{ Result ReceiptsPage }
+		if code := request("GET", path, nil, &res); code != 200 {
+			t.Fatalf("GET %s = %d", path, code)
+		}
+		if res.Result.TotalCount == nil || *res.Result.TotalCount != 3 {
+			t.Errorf("GET %s total count = %v, want 3", path, res.Result.TotalCount)
+		}
+		for _, rec := range res.Result.Items {
+			got = append(got, rec.ID)
+		}
+		path = ""
+		if res.Result.NextCursor != "" {
+			path = "/receipts?limit=2&count=1&cursor=" + res.Result.NextCursor
+		}
+	}
+	if len(got) != 3 || got[0] != ids[2] || got[2] != ids[0] {
+		t.Errorf("listed %v, want %v reversed", got, ids)
+	}
+
+	sub, _ := b.Subscribe(SubscriptionFilter{Receipts: true}, "")
+	var delRes struct{ Result ReceiptDeleteResult }
+	if code := request("DELETE", "/receipts/"+strconv.FormatInt(ids[1], 10), nil, &delRes); code != 200 || delRes.Result.ID != ids[1] {
+		t.Errorf("DELETE = %d %+v", code, delRes)
+	}
+	<-sub.Notify()
+	if events, _ := sub.Take(); len(events) != 1 || events[0].Kind != BroadcastReceiptDeleted || events[0].ReceiptID != ids[1] {
+		t.Errorf("broadcasted %+v, want deletion of #%d", events, ids[1])
+	}
+	var getRes struct{ Result struct{ ID int64 } }
+	if code := request("GET", "/receipts/"+strconv.FormatInt(ids[1], 10), nil, &getRes); code != 404 {
+		t.Errorf("GET deleted = %d, want 404", code)
+	}
+	if code := request("GET", "/receipts/"+strconv.FormatInt(ids[2], 10), nil, &getRes); code != 200 || getRes.Result.ID != ids[2] {
+		t.Errorf("GET = %d %+v, want #%d", code, getRes, ids[2])
+	}
+
+	// домен выключен (клиента нет) — апдейтер такой чек не скачает
+	refText = "t=20260303T1200&s=250.00&fn=9999078900012345&i=11&fp=1000000002&n=1"
+	if code := request("POST", "/receipts", ReceiptCreateRequest{RefText: refText}, &submitRes); code != 200 {
+		t.Fatalf("POST /receipts (ru-fns) = %d", code)
+	}
+	errRes = httputils.JsonError{}
+	if code := request("POST", "/receipt_refetches", ReceiptRefetchRequest{ReceiptID: submitRes.Result.ID}, &errRes); code != 400 || errRes.Error != "RECEIPT_NOT_REFETCHABLE" {
+		t.Errorf("POST refetch of disabled domain = %d %+v", code, errRes)
+	}
+}
+
+func TestAPIV1ManualReceipt(t *testing.T) {
+	srv, _ := startTestAPIV1Server(t)
+	request := newTestAPIV1Request(t, srv)
+
+	manualData := manual.ReceiptData{
+		CreatedAt: time.Date(2026, 3, 10, 12, 30, 0, 0, time.FixedZone("", 5*3600)),
+		Sum:       1000,
+		Currency:  "₽",
+		Seller:    " Рынок ",
+		Items:     []manual.Item{{Name: "Молоко", Quantity: 2, Price: 500, Sum: 1000}},
+	}
+	var res struct{ Result ReceiptSubmitResult }
+	if code := request("POST", "/receipts/manual", manualData, &res); code != 200 || res.Result.ID == 0 || res.Result.DomainCode != "manual" {
+		t.Fatalf("POST /receipts/manual = %d %+v", code, res)
+	}
+	manualID := res.Result.ID
+	if request("POST", "/receipts/manual", manualData, &res); !res.Result.Exists || res.Result.ID != manualID {
+		t.Errorf("POST existing manual = %+v, want exists #%d", res.Result, manualID)
+	}
+	refText := "https://app.kassa.wipon.kz/consumer?i=5612340010&f=600407890704&s=2750.00&t=20260310T093045"
+	if code := request("POST", "/receipts", ReceiptCreateRequest{RefText: refText}, &res); code != 200 {
+		t.Fatalf("POST /receipts = %d %+v", code, res)
+	}
+	fetchedID := res.Result.ID
+
+	var errRes httputils.JsonError
+	noSeller := manualData
+	noSeller.Seller = " "
+	if code := request("POST", "/receipts/manual", noSeller, &errRes); code != 400 || errRes.Error != "MISSING_VALUE_SELLER" {
+		t.Errorf("POST without seller = %d %+v", code, errRes)
+	}
+
+	// данные сохранены сразу, скачивать нечего (а обычный чек ждёт скачивания)
+	var getRes struct{ Result receipts.Receipt }
+	request("GET", "/receipts/"+strconv.FormatInt(manualID, 10), nil, &getRes)
+	if rec := getRes.Result; !rec.IsCorrect || rec.Currency != "₽" || rec.Sum != 1000 || !strings.Contains(rec.Data, `"seller":"Рынок"`) ||
+		!rec.CreatedAt.Equal(time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)) {
+		t.Errorf("GET manual = %+v", rec)
+	}
+	getRes.Result = receipts.Receipt{}
+	request("GET", "/receipts/"+strconv.FormatInt(fetchedID, 10), nil, &getRes)
+	if rec := getRes.Result; rec.IsCorrect || rec.Data != "" || rec.Currency != "" {
+		t.Errorf("GET fetched = %+v, want pending", rec)
+	}
+
+	if code := request("POST", "/receipt_refetches", ReceiptRefetchRequest{ReceiptID: manualID}, &errRes); code != 400 || errRes.Error != "RECEIPT_NOT_REFETCHABLE" {
+		t.Errorf("POST refetch of manual = %d %+v", code, errRes)
+	}
+
+	var pageRes struct{ Result ReceiptsPage }
+	request("GET", "/receipts?count=1", nil, &pageRes)
+	if totals := pageRes.Result.Totals; len(totals) != 2 || totals["₽"] != 1000 || totals["₸"] != 2750 {
+		t.Errorf("totals = %v, want ₽ and ₸ separately", totals)
+	}
+	request("GET", "/receipts?count=1&search=молоко", nil, &pageRes)
+	if items := pageRes.Result.Items; len(items) != 1 || items[0].ID != manualID {
+		t.Errorf("search by item found %+v, want #%d", items, manualID)
+	}
+}
+
+func TestAPIV1OpenAPISpec(t *testing.T) {
+	srv, _ := startTestAPIV1Server(t)
+	resp, err := http.Get(srv.URL + apiV1Prefix + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var spec struct {
+		OpenAPI    string
+		Paths      map[string]map[string]json.RawMessage
+		Components struct{ Schemas map[string]json.RawMessage }
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q", spec.OpenAPI)
+	}
+	for _, r := range apiV1Routes(nil) {
+		path := strings.ReplaceAll(r.Path, ":id", "{id}")
+		if _, ok := spec.Paths[path][strings.ToLower(r.Method)]; !ok {
+			t.Errorf("no %s %s in spec", r.Method, path)
+		}
+	}
+	for _, name := range []string{"Receipt", "ReceiptsPage", "JsonError", "DomainMetadata", "BreakerState"} {
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("no %s schema in spec", name)
+		}
+	}
+	var receipt struct {
+		Properties map[string]map[string]string
+		Required   []string
+	}
+	json.Unmarshal(spec.Components.Schemas["Receipt"], &receipt)
+	if receipt.Properties["createdAt"]["format"] != "date-time" || receipt.Properties["id"]["type"] != "integer" {
+		t.Errorf("Receipt schema properties = %v", receipt.Properties)
+	}
+}
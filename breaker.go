@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultBreakerThreshold = 5
+const defaultBreakerProbeInterval = 30 * time.Minute
+
+const (
+	BreakerClosed   = "closed"    //домен работает
+	BreakerOpen     = "open"      //домен на паузе до NextProbeAt
+	BreakerHalfOpen = "half_open" //идёт пробный запрос
+)
+
+// BreakerState — состояние автомата домена (отдаётся в /api/domains_metadata)
+type BreakerState struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+	NextProbeAt         *time.Time `json:"nextProbeAt,omitempty"`
+}
+
+// DomainBreaker ставит домен на паузу, если провайдер подряд отвечает чем-то неожиданным
+// (скорее всего, поменялось АПИ): пока домен на паузе, его чеки не скачиваются и попытки не тратятся.
+// Раз в probe interval один чек скачивается пробно: при успехе домен снова работает.
+type DomainBreaker struct {
+	cfg    *Config
+	states map[string]*BreakerState
+	now    func() time.Time
+	mutex  sync.Mutex
+}
+
+func NewDomainBreaker(cfg *Config) *DomainBreaker {
+	return &DomainBreaker{cfg: cfg, states: map[string]*BreakerState{}, now: time.Now}
+}
+
+// isBreakingFetchResult — ответ провайдера похож на сломанное/изменённое АПИ
+func isBreakingFetchResult(resultLabel string) bool {
+	return resultLabel == "malformed" || resultLabel == "unexpected_status"
+}
+
+// isNeutralFetchResult — ответа от провайдера нет, про его АПИ ничего не понятно
+func isNeutralFetchResult(resultLabel string) bool {
+	switch resultLabel {
+	case "timeout", "network", "panic", "too_large", "canceled":
+		return true
+	}
+	return false
+}
+
+func (b *DomainBreaker) state(domainCode string) *BreakerState {
+	st, ok := b.states[domainCode]
+	if !ok {
+		st = &BreakerState{State: BreakerClosed}
+		b.states[domainCode] = st
+	}
+	return st
+}
+
+// IsPaused — домен на паузе и время пробного запроса ещё не пришло
+func (b *DomainBreaker) IsPaused(domainCode string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	st := b.state(domainCode)
+	return st.State == BreakerHalfOpen || (st.State == BreakerOpen && b.now().Before(*st.NextProbeAt))
+}
+
+// Allow сообщает, можно ли сейчас скачивать чек домена. Для домена на паузе
+// после NextProbeAt разрешает один пробный запрос (результат нужно передать в RecordResult).
+func (b *DomainBreaker) Allow(domainCode string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	st := b.state(domainCode)
+	switch st.State {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if b.now().Before(*st.NextProbeAt) {
+			return false
+		}
+		st.State = BreakerHalfOpen
+		log.Info().Str("domain", domainCode).Msg("circuit breaker: probing domain")
+		return true
+	default:
+		return false
+	}
+}
+
+// RecordResult учитывает результат скачивания чека (см. fetchResultLabel).
+// Возвращает true, если чек скачивался при сломанном домене и попытку тратить не нужно.
+func (b *DomainBreaker) RecordResult(domainCode, resultLabel string, err error) (isDomainBroken bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	st := b.state(domainCode)
+
+	if isNeutralFetchResult(resultLabel) {
+		if st.State == BreakerHalfOpen {
+			b.open(domainCode, st)
+			return true
+		}
+		return false
+	}
+
+	if !isBreakingFetchResult(resultLabel) {
+		if st.State != BreakerClosed {
+			log.Info().Str("domain", domainCode).Msg("circuit breaker: domain resumed")
+		}
+		*st = BreakerState{State: BreakerClosed}
+		return false
+	}
+
+	st.ConsecutiveFailures++
+	if err != nil {
+		st.LastError = err.Error()
+	}
+	if st.State == BreakerHalfOpen {
+		b.open(domainCode, st)
+		return true
+	}
+	threshold := b.cfg.BreakerThreshold(domainCode)
+	if threshold > 0 && st.ConsecutiveFailures >= threshold {
+		b.open(domainCode, st)
+		return true
+	}
+	return false
+}
+
+func (b *DomainBreaker) open(domainCode string, st *BreakerState) {
+	now := b.now()
+	nextProbeAt := now.Add(b.cfg.BreakerProbeInterval(domainCode))
+	if st.State == BreakerClosed {
+		st.OpenedAt = &now
+	}
+	st.State = BreakerOpen
+	st.NextProbeAt = &nextProbeAt
+	log.Warn().Str("domain", domainCode).Int("failures", st.ConsecutiveFailures).Str("last_error", st.LastError).
+		Time("next_probe_at", nextProbeAt).Msg("circuit breaker: domain paused")
+}
+
+// NextProbeAt — ближайшее время пробного запроса среди доменов на паузе
+func (b *DomainBreaker) NextProbeAt() (time.Time, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var res time.Time
+	found := false
+	for _, st := range b.states {
+		if st.State == BreakerOpen && (!found || st.NextProbeAt.Before(res)) {
+			res = *st.NextProbeAt
+			found = true
+		}
+	}
+	return res, found
+}
+
+func (b *DomainBreaker) State(domainCode string) BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return *b.state(domainCode)
+}
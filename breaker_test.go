@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"receipt_qr_scanner/utils"
+	"testing"
+	"time"
+)
+
+func TestDomainBreaker(t *testing.T) {
+	threshold := 3
+	cfg := &Config{Domains: map[string]*DomainConfig{
+		"kz-ktc": {BreakerThreshold: threshold, BreakerProbeInterval: utils.Duration{Duration: time.Minute}},
+	}}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := NewDomainBreaker(cfg)
+	b.now = func() time.Time { return now }
+	errMalformed := errors.New("response data malformed")
+
+	// сетевые ошибки и «чек не найден» не ломают домен, успех сбрасывает счётчик
+	b.RecordResult("kz-ktc", "malformed", errMalformed)
+	b.RecordResult("kz-ktc", "timeout", errors.New("timeout"))
+	b.RecordResult("kz-ktc", "not_found", errors.New("not found"))
+	if st := b.State("kz-ktc"); st.State != BreakerClosed || st.ConsecutiveFailures != 0 {
+		t.Fatalf("state = %+v, want closed without failures", st)
+	}
+
+	for i := 1; i <= threshold; i++ {
+		if !b.Allow("kz-ktc") {
+			t.Fatalf("Allow() = false before threshold")
+		}
+		isBroken := b.RecordResult("kz-ktc", "unexpected_status", errMalformed)
+		if isBroken != (i == threshold) {
+			t.Errorf("failure #%d: RecordResult() = %v", i, isBroken)
+		}
+	}
+	if st := b.State("kz-ktc"); st.State != BreakerOpen || st.NextProbeAt == nil || !st.NextProbeAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("state = %+v, want open until %s", st, now.Add(time.Minute))
+	}
+	if !b.IsPaused("kz-ktc") || b.Allow("kz-ktc") {
+		t.Errorf("domain is not paused")
+	}
+	if b.IsPaused("ru-fns") || !b.Allow("ru-fns") {
+		t.Errorf("other domain is paused")
+	}
+
+	// неудачная проба — снова пауза
+	now = now.Add(time.Minute)
+	if b.IsPaused("kz-ktc") || !b.Allow("kz-ktc") {
+		t.Fatalf("probe is not allowed after probe interval")
+	}
+	if b.Allow("kz-ktc") {
+		t.Errorf("second probe is allowed")
+	}
+	if !b.RecordResult("kz-ktc", "malformed", errMalformed) {
+		t.Errorf("failed probe: RecordResult() = false")
+	}
+	if probeAt, ok := b.NextProbeAt(); !ok || !probeAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("NextProbeAt() = %s, %v", probeAt, ok)
+	}
+
+	// удачная — домен снова работает
+	now = now.Add(time.Minute)
+	b.Allow("kz-ktc")
+	if b.RecordResult("kz-ktc", "ok", nil) {
+		t.Errorf("successful probe: RecordResult() = true")
+	}
+	if st := b.State("kz-ktc"); st.State != BreakerClosed || b.IsPaused("kz-ktc") {
+		t.Errorf("state = %+v, want closed", st)
+	}
+	if _, ok := b.NextProbeAt(); ok {
+		t.Errorf("NextProbeAt() found paused domain")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"receipt_qr_scanner/receipts"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+// типы событий для подписчиков ReceiptsBroadcaster
+const (
+	BroadcastReceipt        = "receipt"         //чек добавлен или обновлён
+	BroadcastReceiptDeleted = "receipt_deleted" //чек удалён
+	BroadcastUpdaterStatus  = "updater_status"  //изменилось состояние апдейтера
+)
+
+type BroadcastEvent struct {
+	Seq           int64 //порядковый номер события (см. ReceiptsBroadcaster.EventID)
+	Kind          string
+	Receipt       *receipts.Receipt //для BroadcastReceipt
+	ReceiptID     int64             //для BroadcastReceiptDeleted
+	UpdaterStatus *ComponentStatus  //для BroadcastUpdaterStatus
+}
+
+// сколько последних событий помнить для продолжения подписки после переподключения (Last-Event-ID)
+const broadcastHistorySize = 1000
+
+// размер очереди подписчика: при переполнении из очереди выкидывается более старое обновление
+// того же чека, а если такого нет — подписчик отключается
+const subscriberQueueSize = 256
+
+// SubscriptionFilter — какие события нужны подписчику (события апдейтера приходят всем)
+type SubscriptionFilter struct {
+	Receipts bool   //получать чеки и их удаления
+	Search   string //только чеки, подходящие под поиск (как в /api/receipts_list)
+}
+
+func (f SubscriptionFilter) Matches(ev BroadcastEvent) bool {
+	switch ev.Kind {
+	case BroadcastReceipt:
+		return f.Receipts && receiptMatchesSearch(ev.Receipt, f.Search)
+	case BroadcastReceiptDeleted:
+		return f.Receipts
+	default:
+		return true
+	}
+}
+
+// Subscriber — подписка на события: свой буфер, свой фильтр
+type Subscriber struct {
+	filter     SubscriptionFilter
+	startSeq   int64
+	queue      []BroadcastEvent
+	isClosed   bool
+	notifyChan chan struct{}
+	mutex      sync.Mutex
+}
+
+func newSubscriber(filter SubscriptionFilter, startSeq int64) *Subscriber {
+	return &Subscriber{filter: filter, startSeq: startSeq, notifyChan: make(chan struct{}, 1)}
+}
+
+// Notify срабатывает, когда в очереди появились события или подписка закрылась
+func (s *Subscriber) Notify() <-chan struct{} {
+	return s.notifyChan
+}
+
+// StartSeq — номер последнего события на момент подписки (им помечается начальный список чеков)
+func (s *Subscriber) StartSeq() int64 {
+	return s.startSeq
+}
+
+// Take забирает накопившиеся события. isOpen == false — подписка закрыта
+// (рассыльщик остановлен или подписчик не успевал разбирать очередь).
+func (s *Subscriber) Take() (events []BroadcastEvent, isOpen bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events = s.queue
+	s.queue = nil
+	return events, !s.isClosed
+}
+
+// push добавляет событие в очередь (если оно проходит фильтр), false — очередь переполнена
+func (s *Subscriber) push(ev BroadcastEvent) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isClosed || !s.filter.Matches(ev) {
+		return true
+	}
+	if len(s.queue) >= subscriberQueueSize {
+		if ev.Kind != BroadcastReceipt {
+			return false
+		}
+		i := indexOfReceiptEvent(s.queue, ev.Receipt.ID)
+		if i == -1 {
+			return false
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+	}
+	s.queue = append(s.queue, ev)
+	s.notify()
+	return true
+}
+
+func (s *Subscriber) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.isClosed = true
+	s.notify()
+}
+
+func (s *Subscriber) notify() {
+	select {
+	case s.notifyChan <- struct{}{}:
+	default:
+	}
+}
+
+func indexOfReceiptEvent(events []BroadcastEvent, receiptID int64) int {
+	for i, ev := range events {
+		if ev.Kind == BroadcastReceipt && ev.Receipt.ID == receiptID {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReceiptsBroadcaster рассылает события подписчикам (SSE и WebSocket).
+// Рассылка никого не ждёт: у каждого подписчика своя очередь (см. subscriberQueueSize).
+// Последние события хранятся, чтоб переподключившийся клиент получил только пропущенное.
+type ReceiptsBroadcaster struct {
+	inChan chan BroadcastEvent
+	// отличает ID событий этого запуска от ID прошлых (после перезапуска продолжать нечего)
+	epoch       string
+	lastSeq     int64
+	history     []BroadcastEvent
+	subscribers map[*Subscriber]struct{}
+	isClosed    bool
+	mutex       sync.Mutex
+	// WebSocket-обработчики: сервер при остановке не ждёт перехваченные соединения,
+	// а они могут ещё добавлять чеки (см. WaitWSHandlers)
+	wsHandlers sync.WaitGroup
+}
+
+func NewReceiptsBroadcaster() *ReceiptsBroadcaster {
+	b := &ReceiptsBroadcaster{
+		inChan:      make(chan BroadcastEvent, 10),
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+	go func() {
+		for ev := range b.inChan {
+			b.broadcast(ev)
+		}
+	}()
+	return b
+}
+
+// FeedFrom загружает обновлённые чеки по ID и рассылает их клиентам, пока updatedReceiptIDsChan не закрыт.
+// Чеки, которые не удалось загрузить, пропускаются (ошибка пишется в health).
+func (b *ReceiptsBroadcaster) FeedFrom(db *sql.DB, health *Health, updatedReceiptIDsChan chan int64) {
+	for recID := range updatedReceiptIDsChan {
+		rec, err := loadReceipt(db, recID)
+		if merry.Is(err, sql.ErrNoRows) {
+			continue //чек уже удалён
+		}
+		if err != nil {
+			log.Error().Stack().Err(err).Int64("id", recID).Msg("can not load updated receipt")
+			health.SetError(broadcasterComponent, err)
+			continue
+		}
+		health.SetOK(broadcasterComponent)
+		b.inChan <- BroadcastEvent{Kind: BroadcastReceipt, Receipt: rec}
+	}
+}
+
+func (b *ReceiptsBroadcaster) BroadcastDeleted(receiptID int64) {
+	b.inChan <- BroadcastEvent{Kind: BroadcastReceiptDeleted, ReceiptID: receiptID}
+}
+
+func (b *ReceiptsBroadcaster) BroadcastUpdaterStatus(status ComponentStatus) {
+	b.inChan <- BroadcastEvent{Kind: BroadcastUpdaterStatus, UpdaterStatus: &status}
+}
+
+// EventID — ID события для клиента (SSE id:, Last-Event-ID)
+func (b *ReceiptsBroadcaster) EventID(seq int64) string {
+	return b.epoch + "-" + strconv.FormatInt(seq, 10)
+}
+
+func (b *ReceiptsBroadcaster) parseEventID(eventID string) (int64, bool) {
+	epoch, seqStr, ok := strings.Cut(eventID, "-")
+	if !ok || epoch != b.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	return seq, err == nil
+}
+
+// Subscribe создаёт подписку. Если lastEventID (ID последнего полученного клиентом события) ещё
+// в истории, в очередь сразу попадают все пропущенные события и isResumed == true,
+// иначе клиенту нужно заново загрузить список чеков.
+// После Close возвращает сразу закрытую подписку.
+func (b *ReceiptsBroadcaster) Subscribe(filter SubscriptionFilter, lastEventID string) (sub *Subscriber, isResumed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	sub = newSubscriber(filter, b.lastSeq)
+	if b.isClosed {
+		sub.close()
+		return sub, false
+	}
+	if lastEventID != "" {
+		if missed, ok := b.missedEvents(lastEventID); ok {
+			isResumed = true
+			for _, ev := range missed {
+				if !sub.push(ev) {
+					isResumed = false //пропущено слишком много, проще загрузить список заново
+					sub.queue = nil
+					break
+				}
+			}
+		}
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub, isResumed
+}
+
+// missedEvents — события после lastEventID, если они все ещё есть в истории
+func (b *ReceiptsBroadcaster) missedEvents(lastEventID string) ([]BroadcastEvent, bool) {
+	seq, ok := b.parseEventID(lastEventID)
+	if !ok || seq > b.lastSeq {
+		return nil, false
+	}
+	oldestSeq := b.lastSeq + 1
+	if len(b.history) > 0 {
+		oldestSeq = b.history[0].Seq
+	}
+	if seq < oldestSeq-1 {
+		return nil, false //часть пропущенных событий уже забыта
+	}
+	return b.history[len(b.history)-int(b.lastSeq-seq):], true
+}
+
+func (b *ReceiptsBroadcaster) Unsubscribe(sub *Subscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, sub)
+	sub.close()
+}
+
+func (b *ReceiptsBroadcaster) ClientsCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers)
+}
+
+// Close закрывает все подписки (SSE- и WebSocket-обработчики после этого завершаются)
+func (b *ReceiptsBroadcaster) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.isClosed = true
+	for sub := range b.subscribers {
+		sub.close()
+		delete(b.subscribers, sub)
+	}
+}
+
+// WaitWSHandlers ждёт завершения WebSocket-обработчиков (после Close), но не дольше ctx
+func (b *ReceiptsBroadcaster) WaitWSHandlers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.wsHandlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return merry.Prepend(ctx.Err(), "waiting for WS handlers")
+	}
+}
+
+func (b *ReceiptsBroadcaster) broadcast(ev BroadcastEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastSeq++
+	ev.Seq = b.lastSeq
+	b.history = append(b.history, ev)
+	if len(b.history) > broadcastHistorySize {
+		b.history = append(b.history[:0], b.history[len(b.history)-broadcastHistorySize:]...)
+	}
+
+	for sub := range b.subscribers {
+		if !sub.push(ev) {
+			log.Warn().Msg("broadcaster: subscriber queue overflow, disconnecting")
+			sub.close()
+			delete(b.subscribers, sub)
+		}
+	}
+}
+
+// receiptMatchesSearch повторяет фильтр search_key LIKE из searchAndReadReceipts
+func receiptMatchesSearch(rec *receipts.Receipt, searchQuery string) bool {
+	return searchQuery == "" || strings.Contains(rec.SearchKey, strings.ToLower(searchQuery))
+}
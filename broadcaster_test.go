@@ -0,0 +1,135 @@
+package main
+
+import (
+	"receipt_qr_scanner/receipts"
+	"testing"
+)
+
+func takeEvents(t *testing.T, sub *Subscriber) ([]BroadcastEvent, bool) {
+	t.Helper()
+	select {
+	case <-sub.Notify():
+	default:
+		return nil, true
+	}
+	return sub.Take()
+}
+
+func TestBroadcasterSlowSubscriber(t *testing.T) {
+	b := NewReceiptsBroadcaster()
+	slow, _ := b.Subscribe(SubscriptionFilter{Receipts: true}, "")
+	fast, _ := b.Subscribe(SubscriptionFilter{Receipts: true}, "")
+
+	// повторные обновления одного чека схлопываются, рассылка не блокируется
+	for i := 0; i < subscriberQueueSize*2; i++ {
+		b.broadcast(BroadcastEvent{Kind: BroadcastReceipt, Receipt: &receipts.Receipt{ID: int64(i % 10)}})
+		if events, isOpen := takeEvents(t, fast); len(events) != 1 || !isOpen {
+			t.Fatalf("fast subscriber got %d events (open: %v), want 1", len(events), isOpen)
+		}
+	}
+	events, isOpen := takeEvents(t, slow)
+	if len(events) != subscriberQueueSize || !isOpen {
+		t.Fatalf("slow subscriber got %d events (open: %v), want %d", len(events), isOpen, subscriberQueueSize)
+	}
+	lastSeq := int64(subscriberQueueSize * 2)
+	if last := events[len(events)-1]; last.Receipt.ID != (lastSeq-1)%10 || last.Seq != lastSeq {
+		t.Errorf("last event = #%d seq %d, want #%d seq %d", last.Receipt.ID, last.Seq, (lastSeq-1)%10, lastSeq)
+	}
+
+	// удаления не схлопываются: при переполнении подписчик отключается
+	for i := 0; i < subscriberQueueSize+1; i++ {
+		b.broadcast(BroadcastEvent{Kind: BroadcastReceiptDeleted, ReceiptID: int64(i)})
+		takeEvents(t, fast)
+	}
+	events, isOpen = takeEvents(t, slow)
+	if len(events) != subscriberQueueSize || isOpen {
+		t.Errorf("slow subscriber got %d events (open: %v), want %d and closed", len(events), isOpen, subscriberQueueSize)
+	}
+	if n := b.ClientsCount(); n != 1 {
+		t.Errorf("clients count = %d, want 1", n)
+	}
+}
+
+func TestBroadcasterFilter(t *testing.T) {
+	b := NewReceiptsBroadcaster()
+	all, _ := b.Subscribe(SubscriptionFilter{Receipts: true}, "")
+	found, _ := b.Subscribe(SubscriptionFilter{Receipts: true, Search: "Молоко"}, "")
+	statusOnly, _ := b.Subscribe(SubscriptionFilter{}, "")
+
+	b.broadcast(BroadcastEvent{Kind: BroadcastReceipt, Receipt: &receipts.Receipt{ID: 1, SearchKey: "хлеб"}})
+	b.broadcast(BroadcastEvent{Kind: BroadcastReceipt, Receipt: &receipts.Receipt{ID: 2, SearchKey: "молоко 3.2%"}})
+	b.broadcast(BroadcastEvent{Kind: BroadcastReceiptDeleted, ReceiptID: 1})
+	b.broadcast(BroadcastEvent{Kind: BroadcastUpdaterStatus, UpdaterStatus: &ComponentStatus{IsOK: true}})
+
+	for _, c := range []struct {
+		Name  string
+		Sub   *Subscriber
+		Kinds []string
+	}{
+		{"all", all, []string{BroadcastReceipt, BroadcastReceipt, BroadcastReceiptDeleted, BroadcastUpdaterStatus}},
+		{"found", found, []string{BroadcastReceipt, BroadcastReceiptDeleted, BroadcastUpdaterStatus}},
+		{"status only", statusOnly, []string{BroadcastUpdaterStatus}},
+	} {
+		events, _ := takeEvents(t, c.Sub)
+		var kinds []string
+		for _, ev := range events {
+			kinds = append(kinds, ev.Kind)
+		}
+		if len(kinds) != len(c.Kinds) {
+			t.Errorf("%s: got %v, want %v", c.Name, kinds, c.Kinds)
+			continue
+		}
+		for i := range kinds {
+			if kinds[i] != c.Kinds[i] {
+				t.Errorf("%s: got %v, want %v", c.Name, kinds, c.Kinds)
+				break
+			}
+		}
+	}
+	if events, _ := found.Take(); len(events) != 0 {
+		t.Errorf("found: unexpected extra events %v", events)
+	}
+}
+
+func TestBroadcasterResume(t *testing.T) {
+	b := NewReceiptsBroadcaster()
+	for i := 1; i <= 5; i++ {
+		b.broadcast(BroadcastEvent{Kind: BroadcastReceipt, Receipt: &receipts.Receipt{ID: int64(i)}})
+	}
+
+	sub, isResumed := b.Subscribe(SubscriptionFilter{Receipts: true}, b.EventID(3))
+	events, _ := takeEvents(t, sub)
+	if !isResumed || len(events) != 2 || events[0].Seq != 4 || events[1].Seq != 5 {
+		t.Errorf("resumed = %v, events = %+v, want seq 4 and 5", isResumed, events)
+	}
+
+	sub, isResumed = b.Subscribe(SubscriptionFilter{Receipts: true}, b.EventID(5))
+	if events, _ := takeEvents(t, sub); !isResumed || len(events) != 0 {
+		t.Errorf("resumed = %v, events = %+v, want nothing missed", isResumed, events)
+	}
+	if sub.StartSeq() != 5 {
+		t.Errorf("start seq = %d, want 5", sub.StartSeq())
+	}
+
+	for _, eventID := range []string{"", "someepoch-3", b.EventID(6), "garbage"} {
+		sub, isResumed := b.Subscribe(SubscriptionFilter{Receipts: true}, eventID)
+		if events, _ := takeEvents(t, sub); isResumed || len(events) != 0 {
+			t.Errorf("%q: resumed = %v, events = %+v, want not resumed", eventID, isResumed, events)
+		}
+	}
+
+	// пропущенное уже выпало из истории (фильтр без чеков — чтоб не переполнить очередь)
+	for i := 0; i < broadcastHistorySize; i++ {
+		b.broadcast(BroadcastEvent{Kind: BroadcastReceiptDeleted, ReceiptID: int64(i)})
+	}
+	if _, isResumed := b.Subscribe(SubscriptionFilter{}, b.EventID(4)); isResumed {
+		t.Errorf("resumed from forgotten event")
+	}
+	if _, isResumed := b.Subscribe(SubscriptionFilter{}, b.EventID(5)); !isResumed {
+		t.Errorf("not resumed from oldest remembered event")
+	}
+	// пропущено больше, чем влезает в очередь
+	if _, isResumed := b.Subscribe(SubscriptionFilter{Receipts: true}, b.EventID(5)); isResumed {
+		t.Errorf("resumed with queue overflow")
+	}
+}
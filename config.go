@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+const configFName = "config.json"
+const defaultServerAddr = "127.0.0.1:9010"
+const defaultDBFName = "main.db"
+
+// сколько раз пытаться скачать чек, прежде чем сдаться (см. saveReceiptFailure)
+const defaultReceiptRetries = 10
+
+// HTTPConfig — настройки HTTP-клиента домена, незаданные (нулевые) значения берутся из utils.DefaultHTTPClientConfig
+type HTTPConfig struct {
+	Timeout    utils.Duration `json:"timeout"`
+	Retries    *int           `json:"retries"`
+	RetryDelay utils.Duration `json:"retry_delay"`
+	// в байтах, отрицательное — без ограничения
+	MaxBodySize int64  `json:"max_body_size"`
+	UserAgent   string `json:"user_agent"`
+}
+
+// DomainConfig — секция домена в конфиге, ключ секции — код домена (ru-fns, kz-ktc, ...)
+type DomainConfig struct {
+	// nil — включён
+	Enabled *bool  `json:"enabled"`
+	BaseURL string `json:"base_url"`
+	// см. utils.ParseProxyURL
+	Proxy string     `json:"proxy"`
+	HTTP  HTTPConfig `json:"http"`
+	// 0 — defaultReceiptRetries
+	ReceiptRetries int64             `json:"receipt_retries"`
+	Credentials    map[string]string `json:"credentials"`
+	// после скольких неожиданных ответов провайдера подряд ставить домен на паузу
+	// (0 — defaultBreakerThreshold, отрицательное — никогда), см. DomainBreaker
+	BreakerThreshold     int            `json:"breaker_threshold"`
+	BreakerProbeInterval utils.Duration `json:"breaker_probe_interval"`
+}
+
+func (d DomainConfig) IsEnabled() bool {
+	return d.Enabled == nil || *d.Enabled
+}
+
+// WebhookConfig — адрес, на который POST-запросом отправляются события чеков (см. Webhooks)
+type WebhookConfig struct {
+	// уникальное имя, по нему доставки в очереди связаны с вебхуком
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// пусто — все события (см. receiptEvents)
+	Events []string `json:"events"`
+	// 0 — defaultWebhookTimeout
+	Timeout utils.Duration `json:"timeout"`
+	Proxy   string         `json:"proxy"`
+}
+
+func (w WebhookConfig) HandlesEvent(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// SMTPConfig — почтовый сервер для уведомлений (см. SMTPNotifier)
+type SMTPConfig struct {
+	// host:port
+	Addr string `json:"addr"`
+	// пустой — без авторизации
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// NotificationsConfig — уведомления о чеках (см. Notifications)
+type NotificationsConfig struct {
+	SMTP *SMTPConfig `json:"smtp"`
+	// время ежедневной сводки скачанных чеков (ЧЧ:ММ, местное), пустое — без сводки
+	DigestAt string `json:"digest_at"`
+	// предупреждать о чеках, которые так и не удалось скачать
+	FailureWarnings bool `json:"failure_warnings"`
+}
+
+func (n NotificationsConfig) IsEnabled() bool {
+	return n.SMTP != nil && (n.DigestAt != "" || n.FailureWarnings)
+}
+
+// Config — настройки из config.json в папке конфига, флаги командной строки переопределяют их
+type Config struct {
+	Env      utils.Env `json:"env"`
+	Addr     string    `json:"addr"`
+	DebugTLS bool      `json:"debug_tls"`
+	// путь к файлу БД, относительный — от папки конфига
+	DBPath        string                   `json:"db_path"`
+	Domains       map[string]*DomainConfig `json:"domains"`
+	Webhooks      []WebhookConfig          `json:"webhooks"`
+	Notifications NotificationsConfig      `json:"notifications"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Env:     utils.Env{Val: "dev"},
+		Addr:    defaultServerAddr,
+		DBPath:  defaultDBFName,
+		Domains: map[string]*DomainConfig{},
+	}
+}
+
+// loadConfig читает конфиг поверх значений по умолчанию.
+// Отсутствующий файл не считается ошибкой, если mustExist == false.
+func loadConfig(fpath string, mustExist bool) (*Config, error) {
+	cfg := defaultConfig()
+
+	file, err := os.Open(fpath)
+	if os.IsNotExist(err) && !mustExist {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, merry.Prependf(err, "config %s", fpath)
+	}
+	if cfg.Domains == nil {
+		cfg.Domains = map[string]*DomainConfig{}
+	}
+	log.Info().Str("fpath", fpath).Msg("config loaded")
+	return cfg, nil
+}
+
+// Domain возвращает секцию домена (пустую, если её нет в конфиге)
+func (c *Config) Domain(code string) DomainConfig {
+	if d := c.Domains[code]; d != nil {
+		return *d
+	}
+	return DomainConfig{}
+}
+
+// domainRef возвращает изменяемую секцию домена, создавая её при необходимости (для переопределения флагами)
+func (c *Config) domainRef(code string) *DomainConfig {
+	if c.Domains == nil {
+		c.Domains = map[string]*DomainConfig{}
+	}
+	d := c.Domains[code]
+	if d == nil {
+		d = &DomainConfig{}
+		c.Domains[code] = d
+	}
+	return d
+}
+
+func (c *Config) ReceiptRetries(domainCode string) int64 {
+	if retries := c.Domain(domainCode).ReceiptRetries; retries > 0 {
+		return retries
+	}
+	return defaultReceiptRetries
+}
+
+func (c *Config) BreakerThreshold(domainCode string) int {
+	if threshold := c.Domain(domainCode).BreakerThreshold; threshold != 0 {
+		return threshold
+	}
+	return defaultBreakerThreshold
+}
+
+func (c *Config) BreakerProbeInterval(domainCode string) time.Duration {
+	if interval := c.Domain(domainCode).BreakerProbeInterval.Duration; interval > 0 {
+		return interval
+	}
+	return defaultBreakerProbeInterval
+}
+
+// Webhook возвращает вебхук по имени (пустой, если его нет в конфиге)
+func (c *Config) Webhook(name string) WebhookConfig {
+	for _, hook := range c.Webhooks {
+		if hook.Name == name {
+			return hook
+		}
+	}
+	return WebhookConfig{}
+}
+
+func (c *Config) EnabledDomains(domains []receipts.Domain) []receipts.Domain {
+	var res []receipts.Domain
+	for _, d := range domains {
+		if c.Domain(d.Code).IsEnabled() {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+func (c *Config) DBFPath(cfgDir string) string {
+	if filepath.IsAbs(c.DBPath) || strings.HasPrefix(c.DBPath, "file:") {
+		return c.DBPath
+	}
+	return filepath.Join(cfgDir, c.DBPath)
+}
+
+// Validate проверяет конфиг целиком и возвращает все найденные ошибки одной
+func (c *Config) Validate(domains []receipts.Domain) error {
+	var errs []string
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Addr == "" {
+		addErr("addr: must not be empty")
+	}
+	if c.DBPath == "" {
+		addErr("db_path: must not be empty")
+	}
+
+	for code, dc := range c.Domains {
+		domainI := slices.IndexFunc(domains, func(d receipts.Domain) bool { return d.Code == code })
+		if domainI == -1 {
+			addErr("domains.%s: unknown domain, must be one of: %s", code, joinDomainCodes(domains, ", "))
+			continue
+		}
+		if dc == nil {
+			continue
+		}
+		domain := domains[domainI]
+
+		if dc.BaseURL != "" {
+			u, err := url.Parse(dc.BaseURL)
+			if err != nil {
+				addErr("domains.%s.base_url: %s", code, err)
+			} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				addErr("domains.%s.base_url: must be like http(s)://host[:port], got '%s'", code, dc.BaseURL)
+			}
+		}
+		if dc.Proxy != "" {
+			if _, err := utils.ParseProxyURL(dc.Proxy); err != nil {
+				addErr("domains.%s.proxy: %s", code, err)
+			}
+		}
+		if dc.HTTP.Timeout.Duration < 0 {
+			addErr("domains.%s.http.timeout: must not be negative", code)
+		}
+		if dc.HTTP.Retries != nil && *dc.HTTP.Retries < 0 {
+			addErr("domains.%s.http.retries: must not be negative", code)
+		}
+		if dc.HTTP.RetryDelay.Duration < 0 {
+			addErr("domains.%s.http.retry_delay: must not be negative", code)
+		}
+		if dc.ReceiptRetries < 0 {
+			addErr("domains.%s.receipt_retries: must not be negative", code)
+		}
+		if dc.BreakerProbeInterval.Duration < 0 {
+			addErr("domains.%s.breaker_probe_interval: must not be negative", code)
+		}
+		for name := range dc.Credentials {
+			if !slices.Contains(domain.CredentialNames, name) {
+				if len(domain.CredentialNames) == 0 {
+					addErr("domains.%s.credentials: domain has no credentials", code)
+				} else {
+					addErr("domains.%s.credentials.%s: unknown credential, must be one of: %s",
+						code, name, strings.Join(domain.CredentialNames, ", "))
+				}
+			}
+		}
+	}
+
+	hookNames := map[string]bool{}
+	for i, hook := range c.Webhooks {
+		if hook.Name == "" {
+			addErr("webhooks[%d].name: must not be empty", i)
+		} else if hookNames[hook.Name] {
+			addErr("webhooks[%d].name: duplicate name '%s'", i, hook.Name)
+		}
+		hookNames[hook.Name] = true
+
+		u, err := url.Parse(hook.URL)
+		if err != nil {
+			addErr("webhooks[%d].url: %s", i, err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			addErr("webhooks[%d].url: must be like http(s)://host[:port]/path, got '%s'", i, hook.URL)
+		}
+		if hook.Secret == "" {
+			addErr("webhooks[%d].secret: must not be empty", i)
+		}
+		for _, event := range hook.Events {
+			if !slices.Contains(receiptEvents, event) {
+				addErr("webhooks[%d].events: unknown event '%s', must be one of: %s", i, event, strings.Join(receiptEvents, ", "))
+			}
+		}
+		if hook.Timeout.Duration < 0 {
+			addErr("webhooks[%d].timeout: must not be negative", i)
+		}
+		if hook.Proxy != "" {
+			if _, err := utils.ParseProxyURL(hook.Proxy); err != nil {
+				addErr("webhooks[%d].proxy: %s", i, err)
+			}
+		}
+	}
+
+	notifs := c.Notifications
+	if smtpCfg := notifs.SMTP; smtpCfg != nil {
+		if _, _, err := net.SplitHostPort(smtpCfg.Addr); err != nil {
+			addErr("notifications.smtp.addr: must be like host:port, got '%s'", smtpCfg.Addr)
+		}
+		if _, err := mail.ParseAddress(smtpCfg.From); err != nil {
+			addErr("notifications.smtp.from: %s", err)
+		}
+		if len(smtpCfg.To) == 0 {
+			addErr("notifications.smtp.to: must not be empty")
+		}
+		for _, addr := range smtpCfg.To {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				addErr("notifications.smtp.to: '%s': %s", addr, err)
+			}
+		}
+	} else if notifs.DigestAt != "" || notifs.FailureWarnings {
+		addErr("notifications: no notifier configured (notifications.smtp)")
+	}
+	if notifs.DigestAt != "" {
+		if _, err := time.Parse("15:04", notifs.DigestAt); err != nil {
+			addErr("notifications.digest_at: must be like HH:MM, got '%s'", notifs.DigestAt)
+		}
+	}
+
+	if len(c.EnabledDomains(domains)) == 0 {
+		addErr("domains: all domains are disabled")
+	}
+
+	if len(errs) > 0 {
+		slices.Sort(errs)
+		return merry.Errorf("wrong config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// HTTPClientConfig собирает настройки HTTP-клиента домена (конфиг должен быть уже проверен)
+func (d DomainConfig) HTTPClientConfig() (utils.HTTPClientConfig, error) {
+	httpCfg := utils.DefaultHTTPClientConfig
+	if d.HTTP.Timeout.Duration > 0 {
+		httpCfg.Timeout = d.HTTP.Timeout.Duration
+	}
+	if d.HTTP.Retries != nil {
+		httpCfg.Retries = *d.HTTP.Retries
+	}
+	if d.HTTP.RetryDelay.Duration > 0 {
+		httpCfg.RetryDelay = d.HTTP.RetryDelay.Duration
+	}
+	if d.HTTP.MaxBodySize != 0 {
+		httpCfg.MaxBodySize = d.HTTP.MaxBodySize
+	}
+	if d.HTTP.UserAgent != "" {
+		httpCfg.UserAgent = d.HTTP.UserAgent
+	}
+	if d.Proxy != "" {
+		proxyURL, err := utils.ParseProxyURL(d.Proxy)
+		if err != nil {
+			return httpCfg, merry.Wrap(err)
+		}
+		httpCfg.Proxy = proxyURL
+	}
+	return httpCfg, nil
+}
+
+func joinDomainCodes(domains []receipts.Domain, sep string) string {
+	codes := make([]string, len(domains))
+	for i, d := range domains {
+		codes[i] = d.Code
+	}
+	return strings.Join(codes, sep)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	fpath := filepath.Join(t.TempDir(), configFName)
+	if err := os.WriteFile(fpath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fpath
+}
+
+func TestLoadConfig(t *testing.T) {
+	fpath := writeTestConfig(t, `{
+		"env": "prod",
+		"db_path": "/var/lib/receipts.db",
+		"domains": {
+			"ru-fns": {
+				"proxy": "socks5://127.0.0.1:1080",
+				"http": {"timeout": "45s", "retries": 0},
+				"receipt_retries": 4,
+				"credentials": {"firebase_token": "tok", "device_id": "dev"}
+			},
+			"kz-ttc": {"enabled": false}
+		}
+	}`)
+
+	cfg, err := loadConfig(fpath, true)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if err := cfg.Validate(allDomains); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if !cfg.Env.IsProd() || cfg.Addr != defaultServerAddr {
+		t.Errorf("env = %s, addr = %s", cfg.Env, cfg.Addr)
+	}
+	if p := cfg.DBFPath("/cfg"); p != "/var/lib/receipts.db" {
+		t.Errorf("DBFPath() = %s", p)
+	}
+	if r := cfg.ReceiptRetries("ru-fns"); r != 4 {
+		t.Errorf("ReceiptRetries(ru-fns) = %d, want 4", r)
+	}
+	if r := cfg.ReceiptRetries("kz-ktc"); r != defaultReceiptRetries {
+		t.Errorf("ReceiptRetries(kz-ktc) = %d, want %d", r, defaultReceiptRetries)
+	}
+	if n := len(cfg.EnabledDomains(allDomains)); n != len(allDomains)-1 {
+		t.Errorf("enabled domains: %d, want %d", n, len(allDomains)-1)
+	}
+
+	httpCfg, err := cfg.Domain("ru-fns").HTTPClientConfig()
+	if err != nil {
+		t.Fatalf("HTTPClientConfig() error = %v", err)
+	}
+	if httpCfg.Timeout != 45*time.Second || httpCfg.Retries != 0 || httpCfg.Proxy.Host != "127.0.0.1:1080" {
+		t.Errorf("HTTPClientConfig() = %+v", httpCfg)
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), configFName)
+
+	cfg, err := loadConfig(fpath, false)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if err := cfg.Validate(allDomains); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if p := cfg.DBFPath("/cfg"); p != "/cfg/"+defaultDBFName {
+		t.Errorf("DBFPath() = %s", p)
+	}
+
+	if _, err := loadConfig(fpath, true); err == nil {
+		t.Errorf("loadConfig(mustExist) error = nil, want not found")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, wantErr string
+	}{
+		{"unknown field", `{"adr": "1.2.3.4:80"}`, `unknown field "adr"`},
+		{"wrong env", `{"env": "test"}`, "must be 'dev' or 'prod'"},
+		{"wrong duration", `{"domains": {"kz-ktc": {"http": {"timeout": "5 minutes"}}}}`, "time: unknown unit"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfig(writeTestConfig(t, tc.content), true)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("loadConfig() error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	fpath := writeTestConfig(t, `{
+		"domains": {
+			"xx-abc": {},
+			"kz-ktc": {"base_url": "consumer.oofd.kz", "proxy": "ftp://1.2.3.4", "receipt_retries": -1},
+			"kz-bee": {"credentials": {"token": "123"}},
+			"ru-fns": {"http": {"retries": -2}, "credentials": {"password": "123"}}
+		},
+		"webhooks": [
+			{"name": "budget", "url": "https://example.com/hook", "secret": "s"},
+			{"name": "budget", "url": "example.com", "events": ["receipt_deleted"]}
+		],
+		"notifications": {"smtp": {"addr": "localhost", "from": "me@example.com"}, "digest_at": "9am"}
+	}`)
+	cfg, err := loadConfig(fpath, true)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	err = cfg.Validate(allDomains)
+	if err == nil {
+		t.Fatalf("Validate() error = nil")
+	}
+	for _, want := range []string{
+		"domains.xx-abc: unknown domain",
+		"domains.kz-ktc.base_url:",
+		"domains.kz-ktc.proxy: unsupported proxy scheme 'ftp'",
+		"domains.kz-ktc.receipt_retries:",
+		"domains.kz-bee.credentials: domain has no credentials",
+		"domains.ru-fns.http.retries:",
+		"domains.ru-fns.credentials.password: unknown credential, must be one of: firebase_token, device_id",
+		"webhooks[1].name: duplicate name 'budget'",
+		"webhooks[1].url:",
+		"webhooks[1].secret: must not be empty",
+		"webhooks[1].events: unknown event 'receipt_deleted'",
+		"notifications.smtp.addr: must be like host:port",
+		"notifications.smtp.to: must not be empty",
+		"notifications.digest_at: must be like HH:MM",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v\nwant it to contain %q", err, want)
+		}
+	}
+}
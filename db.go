@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"math"
 	"receipt_qr_scanner/receipts"
 	"strconv"
 	"strings"
@@ -14,11 +17,16 @@ import (
 )
 
 var ErrReceiptRefAlreadyExists = merry.New("receipt ref already exists")
+var ErrReceiptDataInvalid = merry.New("receipt data is not valid JSON")
 
 type ReceiptPending struct {
 	ID        int64
 	Ref       receipts.ReceiptRef
 	IsCorrect bool
+	HasData   bool //уже скачан, это повторное скачивание (см. requestReceiptRefetch)
+	// если ref_text из БД не разбирается, Ref == nil, а тут текст и ошибка разбора
+	RefText string
+	RefErr  error
 }
 
 var migrations = []func(*sql.Tx) error{
@@ -190,6 +198,222 @@ var migrations = []func(*sql.Tx) error{
 		}
 		return merry.Wrap(rows.Err())
 	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE TABLE webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook TEXT NOT NULL,
+			event TEXT NOT NULL,
+			receipt_id INTEGER NOT NULL,
+			payload BLOB NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_error TEXT,
+			delivered_at DATETIME
+		)`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		_, err = tx.Exec(`
+		CREATE INDEX webhook_deliveries_pending_idx
+		ON webhook_deliveries (next_attempt_at) WHERE delivered_at IS NULL`)
+		return merry.Wrap(err)
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE TABLE notification_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event TEXT NOT NULL,
+			receipt_id INTEGER NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			sent_at DATETIME
+		)`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		_, err = tx.Exec(`
+		CREATE INDEX notification_events_unsent_idx
+		ON notification_events (event, id) WHERE sent_at IS NULL`)
+		return merry.Wrap(err)
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE receipts ADD COLUMN sum REAL NOT NULL DEFAULT 0`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		rows, err := tx.Query(`SELECT id, ref_text FROM receipts`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		for rows.Next() {
+			var id int64
+			var refText string
+			if err := rows.Scan(&id, &refText); err != nil {
+				return merry.Wrap(err)
+			}
+			ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+			if err != nil {
+				log.Warn().Err(err).Int64("id", id).Msg("can not parse receipt ref, sum left 0")
+				continue
+			}
+			if _, err := tx.Exec(`UPDATE receipts SET sum = ? WHERE id = ?`, ref.Sum(), id); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return merry.Wrap(err)
+		}
+		for _, column := range []string{"created_at", "updated_at", "saved_at", "sum"} {
+			_, err := tx.Exec(`CREATE INDEX receipts_` + column + `_idx ON receipts (` + column + `, id)`)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return nil
+	},
+	func(tx *sql.Tx) error {
+		// не UNIQUE: старые дубликаты сливаются отдельно (--merge-duplicates), новые не пускает saveRecieptRef
+		_, err := tx.Exec(`ALTER TABLE receipts ADD COLUMN fiscal_key TEXT`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		_, err = tx.Exec(`CREATE INDEX receipts_fiscal_key_idx ON receipts (fiscal_key) WHERE fiscal_key IS NOT NULL`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		rows, err := tx.Query(`SELECT id, ref_text FROM receipts`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		for rows.Next() {
+			var id int64
+			var refText string
+			if err := rows.Scan(&id, &refText); err != nil {
+				return merry.Wrap(err)
+			}
+			ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+			if err != nil {
+				log.Warn().Err(err).Int64("id", id).Msg("can not parse receipt ref, fiscal key left empty")
+				continue
+			}
+			if _, err := tx.Exec(`UPDATE receipts SET fiscal_key = ? WHERE id = ?`, fiscalKeyOrNull(ref), id); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return merry.Wrap(rows.Err())
+	},
+	func(tx *sql.Tx) error {
+		// у возвратов sum становится отрицательной, refund_of_id — исходная покупка (если нашлась)
+		for _, query := range []string{
+			`ALTER TABLE receipts ADD COLUMN is_refund INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE receipts ADD COLUMN refund_of_id INTEGER`,
+			`CREATE INDEX receipts_refund_of_id_idx ON receipts (refund_of_id) WHERE refund_of_id IS NOT NULL`,
+		} {
+			if _, err := tx.Exec(query); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		rows, err := tx.Query(`SELECT id, ref_text FROM receipts`)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		refundRefs := map[int64]receipts.ReceiptRef{}
+		for rows.Next() {
+			var id int64
+			var refText string
+			if err := rows.Scan(&id, &refText); err != nil {
+				return merry.Wrap(err)
+			}
+			ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+			if err != nil {
+				log.Warn().Err(err).Int64("id", id).Msg("can not parse receipt ref, assuming it is not a refund")
+				continue
+			}
+			if receipts.IsRefund(ref) {
+				refundRefs[id] = ref
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return merry.Wrap(err)
+		}
+		for id, ref := range refundRefs {
+			_, err := tx.Exec(`UPDATE receipts SET is_refund = 1, sum = ? WHERE id = ?`, receipts.SignedSum(ref), id)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		for id, ref := range refundRefs {
+			if err := linkReceiptRefunds(tx, ref, id); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return nil
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE TABLE unrecognized_refs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ref_text TEXT NOT NULL UNIQUE,
+			scanned_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			parse_errors TEXT NOT NULL
+		)`)
+		return merry.Wrap(err)
+	},
+	func(tx *sql.Tx) error {
+		// своя валюта чека (у введённых вручную), NULL — валюта домена
+		_, err := tx.Exec(`ALTER TABLE receipts ADD COLUMN currency TEXT`)
+		return merry.Wrap(err)
+	},
+	func(tx *sql.Tx) error {
+		// прошлые данные чеков (перед заменой новыми) и запрос повторного скачивания уже скачанного чека
+		for _, query := range []string{
+			`CREATE TABLE receipt_data_versions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				receipt_id INTEGER NOT NULL,
+				data BLOB NOT NULL,
+				fetched_at DATETIME NOT NULL,
+				replaced_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX receipt_data_versions_receipt_id_idx ON receipt_data_versions (receipt_id)`,
+			`ALTER TABLE receipts ADD COLUMN refetch_requested_at DATETIME`,
+		} {
+			if _, err := tx.Exec(query); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return nil
+	},
+	func(tx *sql.Tx) error {
+		// данные чеков теперь хранятся сжатыми (место освободится после VACUUM, см. --recompress-data)
+		count, err := recompressAllReceiptData(tx)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		log.Info().Int64("count", count).Msg("receipts data compressed")
+		return nil
+	},
+	func(tx *sql.Tx) error {
+		// данные, извлечённые из HTML-страниц чеков (они же теперь идут в search_key вместо страниц).
+		// Для уже скачанных чеков их заполняет -update-search-key: разбор зависит от кода доменов,
+		// а миграция должна давать один и тот же результат
+		_, err := tx.Exec(`ALTER TABLE receipts ADD COLUMN extracted_data TEXT`)
+		return merry.Wrap(err)
+	},
+	func(tx *sql.Tx) error {
+		// время скачивания данных (updated_at меняется и от неудачных попыток, пометок, запросов
+		// повторного скачивания), идёт в receipt_data_versions.fetched_at; для уже скачанных
+		// точнее updated_at ничего нет
+		for _, query := range []string{
+			`ALTER TABLE receipts ADD COLUMN data_fetched_at DATETIME`,
+			`UPDATE receipts SET data_fetched_at = updated_at WHERE data IS NOT NULL`,
+		} {
+			if _, err := tx.Exec(query); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return nil
+	},
 }
 
 func createTables(db *sql.DB) error {
@@ -220,8 +444,9 @@ func createTables(db *sql.DB) error {
 	return nil
 }
 
-func setupDB(configDir string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", configDir+"/main.db")
+// setupDB открывает БД (путь к файлу или DSN SQLite, например in-memory для тестов) и применяет миграции
+func setupDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
@@ -249,7 +474,7 @@ func updateAllSearchKeys(db *sql.DB, allDomains []receipts.Domain) error {
 	for rows.Next() {
 		var id int64
 		var refText string
-		var data string
+		var data []byte
 		err := rows.Scan(&id, &refText, &data)
 		if err != nil {
 			return merry.Wrap(err)
@@ -258,14 +483,25 @@ func updateAllSearchKeys(db *sql.DB, allDomains []receipts.Domain) error {
 		if err != nil {
 			return merry.Wrap(err)
 		}
-		searchKey, err := makeReceiptSearchKey(ref, data)
+		data, err = decompressReceiptData(data)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		// извлечённые данные тоже обновляются (разбор страниц мог улучшиться)
+		searchKey, extracted, err := makeReceiptDataSearchKey(ref, data)
 		if err != nil {
 			return merry.Wrap(err)
 		}
-		_, err = tx.Exec(`UPDATE receipts SET search_key = ? WHERE id = ?`, searchKey, id)
+		_, err = tx.Exec(`UPDATE receipts SET search_key = ?, extracted_data = ? WHERE id = ?`,
+			searchKey, nullableString(extracted), id)
 		if err != nil {
 			return merry.Wrap(err)
 		}
+		if sum, ok := receiptSumFromData(ref, data); ok {
+			if _, err := tx.Exec(`UPDATE receipts SET sum = ? WHERE id = ?`, sum, id); err != nil {
+				return merry.Wrap(err)
+			}
+		}
 		count++
 	}
 	if err := rows.Err(); err != nil {
@@ -286,7 +522,8 @@ func makeReceiptSearchKeyInner(valPrefix string, obj interface{}, items *[]strin
 		for k, v := range obj {
 			if k != "rawData" && //бесполезный для поиска base64
 				k != "qr" && //данные из QR-кода (иногда бывает)
-				k != "logo" { //путь к картинке (/static/logo/<...>.png, иногда бывает)
+				k != "logo" && //путь к картинке (/static/logo/<...>.png, иногда бывает)
+				k != "parseErrors" { //ошибки разбора страницы (в извлечённых данных)
 				makeReceiptSearchKeyInner(k+":", v, items)
 			}
 		}
@@ -313,7 +550,7 @@ func makeReceiptSearchKeyInner(valPrefix string, obj interface{}, items *[]strin
 func makeReceiptSearchKey(ref receipts.ReceiptRef, dataStr string) (string, error) {
 	items := []string{
 		"_domain:" + ref.Domain().Code,
-		"_currency:" + ref.Domain().CurrencySymbol,
+		"_currency:" + receipts.CurrencySymbol(ref),
 		"_flag:" + ref.Domain().FlagSymbol,
 		"_provider:" + ref.Domain().Provider.Name,
 	}
@@ -322,7 +559,7 @@ func makeReceiptSearchKey(ref receipts.ReceiptRef, dataStr string) (string, erro
 
 	if dataStr != "" {
 		if dataStr[0] == '<' {
-			items = append(items, dataStr) //TODO: better items
+			items = append(items, dataStr) //страница, из которой ничего не извлечено (см. extractReceiptData)
 		} else {
 			var data interface{}
 			if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
@@ -338,6 +575,47 @@ func makeReceiptSearchKey(ref receipts.ReceiptRef, dataStr string) (string, erro
 	return searchKey, nil
 }
 
+// extractReceiptData — структурированные данные из скачанных (см. Domain.ExtractReceiptData),
+// nil — домен их не извлекает или разобрать не получилось (тогда в поиск идут сами данные)
+func extractReceiptData(ref receipts.ReceiptRef, data []byte) []byte {
+	extract := ref.Domain().ExtractReceiptData
+	if extract == nil || len(data) == 0 {
+		return nil
+	}
+	extracted, err := extract(data)
+	if err != nil {
+		log.Warn().Err(err).Str("ref", ref.String()).Msg("can not extract receipt data")
+		return nil
+	}
+	return extracted
+}
+
+// receiptSumFromData — сумма из данных чека для доменов, у которых её нет в ссылке (см. Domain.ParseReceiptSum);
+// ok=false — сумма берётся из ссылки (или данные не разобрались, тогда остаётся прежняя)
+func receiptSumFromData(ref receipts.ReceiptRef, data []byte) (float64, bool) {
+	parse := ref.Domain().ParseReceiptSum
+	if parse == nil || len(data) == 0 {
+		return 0, false
+	}
+	sum, err := parse(data)
+	if err != nil {
+		log.Warn().Err(err).Str("ref", ref.String()).Msg("can not parse receipt sum from data")
+		return 0, false
+	}
+	return sum, true
+}
+
+// makeReceiptDataSearchKey — search_key для данных чека data и извлечённые из них данные
+func makeReceiptDataSearchKey(ref receipts.ReceiptRef, data []byte) (string, []byte, error) {
+	extracted := extractReceiptData(ref, data)
+	searchData := data
+	if extracted != nil {
+		searchData = extracted
+	}
+	searchKey, err := makeReceiptSearchKey(ref, string(searchData))
+	return searchKey, extracted, err
+}
+
 func escapeLike(str, escChar string) string {
 	str = strings.Replace(str, escChar, escChar+escChar, -1)
 	str = strings.Replace(str, "%", escChar+"%", -1)
@@ -345,17 +623,85 @@ func escapeLike(str, escChar string) string {
 	return str
 }
 
-func saveRecieptRef(db *sql.DB, ref receipts.ReceiptRef) (int64, error) {
-	searchKey, err := makeReceiptSearchKey(ref, "")
+// nullableString — buf как текст для колонки (NULL, если buf == nil)
+func nullableString(buf []byte) interface{} {
+	if buf == nil {
+		return nil
+	}
+	return string(buf)
+}
+
+// currencyOrNull — своя валюта ссылки для колонки currency (NULL — валюта домена)
+func currencyOrNull(ref receipts.ReceiptRef) interface{} {
+	if r, ok := ref.(receipts.ReceiptRefWithCurrency); ok {
+		return r.CurrencySymbol()
+	}
+	return nil
+}
+
+// fiscalKeyOrNull — ref.FiscalKey() для колонки fiscal_key (NULL, если неизвестен)
+func fiscalKeyOrNull(ref receipts.ReceiptRef) interface{} {
+	if key := ref.FiscalKey(); key != "" {
+		return key
+	}
+	return nil
+}
+
+// findReceiptDuplicate ищет уже сохранённый чек с той же ссылкой или тем же фискальным идентификатором
+// (тот же чек, отсканированный через другого провайдера или в другом формате). 0 — не найден.
+func findReceiptDuplicate(tx *sql.Tx, ref receipts.ReceiptRef) (int64, error) {
+	var id int64
+	err := tx.QueryRow(`
+		SELECT id FROM receipts WHERE unique_key = ? OR fiscal_key = ?
+		ORDER BY id LIMIT 1`, ref.UniqueKey(), fiscalKeyOrNull(ref)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, merry.Wrap(err)
+}
+
+// saveRecieptRef сохраняет новый чек, retries — сколько раз пытаться его скачать.
+// Если такой чек уже есть (см. findReceiptDuplicate), возвращает ErrReceiptRefAlreadyExists и ID этого чека.
+func saveRecieptRef(db *sql.DB, ref receipts.ReceiptRef, retries int64) (int64, error) {
+	// данные чека уже в ссылке: сохраняем их сразу, скачивать нечего
+	var data []byte
+	var isCorrect interface{} //NULL — ещё не скачан
+	if refWithData, ok := ref.(receipts.ReceiptRefWithData); ok {
+		data = refWithData.ReceiptData()
+		isCorrect = 1
+		retries = 0
+	}
+	searchKey, err := makeReceiptSearchKey(ref, string(data))
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	storedData, err := compressReceiptData(data)
 	if err != nil {
 		return 0, merry.Wrap(err)
 	}
 	createdAt := ref.CreatedAt()
 
-	res, err := db.Exec(`
-		INSERT INTO receipts (domain, unique_key, ref_text, created_at, search_key)
-		VALUES (?,?,?,?,?)`,
-		ref.Domain().Code, ref.UniqueKey(), ref.RefText(), createdAt, searchKey)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	existingID, err := findReceiptDuplicate(tx, ref)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if existingID != 0 {
+		return existingID, ErrReceiptRefAlreadyExists.Here()
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO receipts (domain, unique_key, fiscal_key, ref_text, created_at, sum, is_refund, currency,
+			is_correct, data, data_fetched_at, search_key, retries_left)
+		VALUES (?,?,?,?,?,?,?,?,?,?,CASE WHEN ? IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END,?,?)`,
+		ref.Domain().Code, ref.UniqueKey(), fiscalKeyOrNull(ref), ref.RefText(), createdAt,
+		receipts.SignedSum(ref), receipts.IsRefund(ref), currencyOrNull(ref),
+		isCorrect, storedData, storedData, searchKey, retries)
 	if sqlite3Error, ok := err.(sqlite3.Error); ok {
 		if sqlite3Error.ExtendedCode == sqlite3.ErrConstraintUnique {
 			return 0, ErrReceiptRefAlreadyExists.Here()
@@ -368,26 +714,28 @@ func saveRecieptRef(db *sql.DB, ref receipts.ReceiptRef) (int64, error) {
 	if err != nil {
 		return 0, merry.Wrap(err)
 	}
-	return rowID, nil
+	return rowID, merry.Wrap(tx.Commit())
 }
 
 var ErrReceiptRedirectDuplicate = merry.New("redirect target receipt already exists")
 
 // replaceReceiptRef заменяет чек с oldRef на newRef, очищает данные, ресетит таймстампы
 // (аналог удаления+вставки, но с сохранением ID).
-// Если чек с newRef уже есть, ничего не заменяет, только помечает oldRef как неудачный (retries_left = 0).
-// Нужно для замены прокси-чеков (kz-wip-proxy) на реальные.
-func replaceReceiptRef(db *sql.DB, oldRef receipts.ReceiptRef, newRef receipts.ReceiptRef) error {
+// Если чек с newRef уже есть (см. findReceiptDuplicate), ничего не заменяет, только помечает oldRef
+// как неудачный (retries_left = 0).
+// Нужно для замены прокси-чеков (kz-wip-proxy) на реальные, retries — как в saveRecieptRef.
+func replaceReceiptRef(db *sql.DB, oldRef receipts.ReceiptRef, newRef receipts.ReceiptRef, retries int64) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return merry.Wrap(err)
 	}
 	defer tx.Rollback()
 
-	// проверяем, существует ли чек с таким же unique_key
-	var existingID int64
-	err = tx.QueryRow(`SELECT id FROM receipts WHERE unique_key = ?`, newRef.UniqueKey()).Scan(&existingID)
-	if err == nil {
+	existingID, err := findReceiptDuplicate(tx, newRef)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if existingID != 0 {
 		// дубликат найден — помечаем прокси-чек как неудачный
 		_, err = tx.Exec(`
 			UPDATE receipts SET retries_left = 0, updated_at = CURRENT_TIMESTAMP
@@ -399,8 +747,6 @@ func replaceReceiptRef(db *sql.DB, oldRef receipts.ReceiptRef, newRef receipts.R
 			return merry.Wrap(err)
 		}
 		return ErrReceiptRedirectDuplicate.Here()
-	} else if err != sql.ErrNoRows {
-		return merry.Wrap(err)
 	}
 
 	// заменяем прокси-запись на реальную (сохраняем тот же ID)
@@ -411,13 +757,15 @@ func replaceReceiptRef(db *sql.DB, oldRef receipts.ReceiptRef, newRef receipts.R
 
 	_, err = tx.Exec(`
 		UPDATE receipts
-		SET domain = ?, unique_key = ?, ref_text = ?, created_at = ?,
-		    search_key = ?, is_correct = NULL, data = NULL,
-		    retries_left = 10, next_retry_at = CURRENT_TIMESTAMP,
+		SET domain = ?, unique_key = ?, fiscal_key = ?, ref_text = ?, created_at = ?, sum = ?,
+		    is_refund = ?, refund_of_id = NULL,
+		    search_key = ?, is_correct = NULL, data = NULL, data_fetched_at = NULL, extracted_data = NULL,
+		    retries_left = ?, next_retry_at = CURRENT_TIMESTAMP,
 		    updated_at = CURRENT_TIMESTAMP
 		WHERE unique_key = ?`,
-		newRef.Domain().Code, newRef.UniqueKey(), newRef.RefText(),
-		newRef.CreatedAt(), searchKey, oldRef.UniqueKey())
+		newRef.Domain().Code, newRef.UniqueKey(), fiscalKeyOrNull(newRef), newRef.RefText(),
+		newRef.CreatedAt(), receipts.SignedSum(newRef), receipts.IsRefund(newRef),
+		searchKey, retries, oldRef.UniqueKey())
 	if err != nil {
 		return merry.Wrap(err)
 	}
@@ -425,16 +773,41 @@ func replaceReceiptRef(db *sql.DB, oldRef receipts.ReceiptRef, newRef receipts.R
 	return merry.Wrap(tx.Commit())
 }
 
-func saveReceiptFailure(db *sql.DB, ref receipts.ReceiptRef, decreaseRetries bool) error {
-	_, err := db.Exec(`
+// saveReceiptFailure откладывает следующую попытку скачать чек (и тратит попытку, если decreaseRetries).
+// Возвращает, сколько попыток осталось.
+func saveReceiptFailure(db *sql.DB, ref receipts.ReceiptRef, decreaseRetries bool) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
 		UPDATE receipts
 		SET next_retry_at = datetime(CURRENT_TIMESTAMP,
 		        '+' || ((1-retries_left%2)*30 + (retries_left%2 | not ?)*20*3600) || ' seconds'),
 		    retries_left = CASE ? WHEN true THEN MAX(0, retries_left-1) ELSE retries_left END,
+		    refetch_requested_at = CASE WHEN ? AND retries_left <= 1 THEN NULL ELSE refetch_requested_at END,
 		    updated_at = CURRENT_TIMESTAMP
 		WHERE unique_key = ?`,
-		decreaseRetries, decreaseRetries,
+		decreaseRetries, decreaseRetries, decreaseRetries,
 		ref.UniqueKey())
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	var retriesLeft int64
+	err = tx.QueryRow(`SELECT retries_left FROM receipts WHERE unique_key = ?`, ref.UniqueKey()).Scan(&retriesLeft)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return retriesLeft, merry.Wrap(tx.Commit())
+}
+
+// saveReceiptUnfetchable отключает скачивание чека (например, если его ref_text больше не разбирается)
+func saveReceiptUnfetchable(db *sql.DB, id int64) error {
+	_, err := db.Exec(`
+		UPDATE receipts SET retries_left = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, id)
 	return merry.Wrap(err)
 }
 
@@ -446,70 +819,299 @@ func saveReceiptCorrectness(db *sql.DB, ref receipts.ReceiptRef) error {
 	return merry.Wrap(err)
 }
 
-func saveRecieptData(db *sql.DB, ref receipts.ReceiptRef, data []byte) error {
-	searchKey, err := makeReceiptSearchKey(ref, string(data))
+// requestReceiptRefetch ставит уже скачанный чек на повторное скачивание (данные заменятся,
+// прошлые уйдут в receipt_data_versions). Возвращает false, если чека нет или он ещё не скачан.
+func requestReceiptRefetch(db *sql.DB, id int64, retries int64) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE receipts
+		SET refetch_requested_at = CURRENT_TIMESTAMP,
+		    retries_left = MAX(retries_left, ?), next_retry_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND data IS NOT NULL`,
+		retries, id)
 	if err != nil {
-		return merry.Wrap(err)
+		return false, merry.Wrap(err)
 	}
-	_, err = db.Exec(`
-		UPDATE receipts SET is_correct = 1, data = ?, search_key = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE unique_key = ?`,
-		data, searchKey,
-		ref.UniqueKey())
-	return merry.Wrap(err)
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, merry.Wrap(err)
+	}
+	return count > 0, nil
 }
 
-func loadPendingReceipts(db *sql.DB, domainCodes []string, limit int64) ([]*ReceiptPending, error) {
-	args := make([]any, 0, len(domainCodes)+1)
-	for _, code := range domainCodes {
-		args = append(args, code)
-	}
-	args = append(args, limit)
+// ReceiptDataVersion — прошлые данные чека, заменённые более новыми
+type ReceiptDataVersion struct {
+	ID         int64     `json:"id"`
+	Data       string    `json:"data"`
+	FetchedAt  time.Time `json:"fetchedAt"`  //когда были скачаны
+	ReplacedAt time.Time `json:"replacedAt"` //когда заменены новыми
+}
 
+// loadReceiptDataVersions — прошлые данные чека, от новых к старым
+func loadReceiptDataVersions(db *sql.DB, receiptID int64) ([]*ReceiptDataVersion, error) {
 	rows, err := db.Query(`
-		SELECT id, ref_text, COALESCE(is_correct, 0)
-		FROM receipts
-		WHERE (is_correct IS NULL OR data IS NULL)
-		  AND next_retry_at <= CURRENT_TIMESTAMP
-		  AND retries_left > 0
-		  AND domain IN (`+strings.Repeat(",?", len(domainCodes))[1:]+`)
-		ORDER BY next_retry_at
-		LIMIT ?`, args...)
+		SELECT id, data, fetched_at, replaced_at FROM receipt_data_versions
+		WHERE receipt_id = ? ORDER BY id DESC`, receiptID)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
+	defer rows.Close()
 
-	var recs []*ReceiptPending
+	versions := []*ReceiptDataVersion{}
 	for rows.Next() {
-		rec := &ReceiptPending{}
-		var refText string
-		err = rows.Scan(&rec.ID, &refText, &rec.IsCorrect)
-		if err != nil {
+		v := &ReceiptDataVersion{}
+		var data []byte
+		if err := rows.Scan(&v.ID, &data, &v.FetchedAt, &v.ReplacedAt); err != nil {
 			return nil, merry.Wrap(err)
 		}
-		rec.Ref, err = receipts.ReceiptRefFromText(allDomains, refText)
-		if err != nil {
+		if data, err = decompressReceiptData(data); err != nil {
 			return nil, merry.Wrap(err)
 		}
-		recs = append(recs, rec)
+		v.Data = string(data)
+		versions = append(versions, v)
 	}
-	err = rows.Err()
+	return versions, merry.Wrap(rows.Err())
+}
+
+// saveReceiptDataVersion сохраняет прошлые данные чека prevData (как они лежали в БД),
+// если они отличаются от новых newData
+func saveReceiptDataVersion(tx *sql.Tx, receiptID int64, prevData []byte, prevFetchedAt time.Time, newData []byte) error {
+	prevRaw, err := decompressReceiptData(prevData)
 	if err != nil {
-		return nil, merry.Wrap(err)
+		return merry.Wrap(err)
 	}
-	return recs, nil
+	if bytes.Equal(prevRaw, newData) {
+		return nil
+	}
+	if prevData, err = compressReceiptData(prevData); err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO receipt_data_versions (receipt_id, data, fetched_at) VALUES (?, ?, ?)`,
+		receiptID, prevData, prevFetchedAt)
+	return merry.Wrap(err)
 }
 
-func loadNextRetryTime(db *sql.DB, domainCodes []string) (time.Time, error) {
-	args := make([]any, 0, len(domainCodes))
-	for _, code := range domainCodes {
-		args = append(args, code)
+func saveRecieptData(db *sql.DB, ref receipts.ReceiptRef, data []byte) error {
+	searchKey, extracted, err := makeReceiptDataSearchKey(ref, data)
+	if err != nil {
+		return ErrReceiptDataInvalid.Here().Append(err.Error())
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return merry.Wrap(err)
 	}
+	defer tx.Rollback()
 
-	var nextRetryAt time.Time
+	var id int64
+	var prevData []byte
+	var prevFetchedAt sql.NullTime //есть, если есть данные
+	err = tx.QueryRow(`SELECT id, data, data_fetched_at FROM receipts WHERE unique_key = ?`, ref.UniqueKey()).
+		Scan(&id, &prevData, &prevFetchedAt)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	storedData, err := compressReceiptData(data)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	// прошлые данные не теряются (при повторном скачивании), если они другие
+	if prevData != nil {
+		if err := saveReceiptDataVersion(tx, id, prevData, prevFetchedAt.Time, data); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	_, err = tx.Exec(`
+		UPDATE receipts
+		SET is_correct = 1, data = ?, data_fetched_at = CURRENT_TIMESTAMP, extracted_data = ?, search_key = ?,
+		    refetch_requested_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		storedData, nullableString(extracted), searchKey, id)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if sum, ok := receiptSumFromData(ref, data); ok {
+		if _, err := tx.Exec(`UPDATE receipts SET sum = ? WHERE id = ?`, sum, id); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	if err := linkReceiptRefunds(tx, ref, id); err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(tx.Commit())
+}
+
+// насколько раньше возврата может быть исходная покупка
+const refundPurchaseMaxAge = 30 * 24 * time.Hour
+
+// linkReceiptRefunds связывает возвраты с покупками после сохранения данных чека id:
+// для возврата ищет исходную покупку, для покупки — ещё не связанные с покупками возвраты
+// (покупка могла скачаться позже возврата). Домены без ParseReceiptSummary пропускает.
+func linkReceiptRefunds(tx *sql.Tx, ref receipts.ReceiptRef, id int64) error {
+	domain := ref.Domain()
+	if domain.ParseReceiptSummary == nil {
+		return nil
+	}
+	if receipts.IsRefund(ref) {
+		return merry.Wrap(linkRefundToPurchase(tx, domain, id))
+	}
+
+	rows, err := tx.Query(`
+		SELECT id FROM receipts
+		WHERE domain = ? AND is_refund = 1 AND refund_of_id IS NULL AND data IS NOT NULL
+		  AND created_at >= ? AND created_at <= ?`,
+		domain.Code, ref.CreatedAt(), ref.CreatedAt().Add(refundPurchaseMaxAge))
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	var refundIDs []int64
+	for rows.Next() {
+		var refundID int64
+		if err := rows.Scan(&refundID); err != nil {
+			return merry.Wrap(err)
+		}
+		refundIDs = append(refundIDs, refundID)
+	}
+	if err := rows.Err(); err != nil {
+		return merry.Wrap(err)
+	}
+	for _, refundID := range refundIDs {
+		if err := linkRefundToPurchase(tx, domain, refundID); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// linkRefundToPurchase ищет для возврата самую позднюю подходящую покупку (см. refundMatchesPurchase)
+// не раньше refundPurchaseMaxAge, у которой за вычетом уже связанных возвратов осталось не меньше суммы
+// этого возврата. Не нашлась — возврат остаётся несвязанным.
+func linkRefundToPurchase(tx *sql.Tx, domain receipts.Domain, refundID int64) error {
+	var createdAt time.Time
+	var sum float64
+	var data []byte
+	err := tx.QueryRow(`SELECT created_at, sum, data FROM receipts WHERE id = ?`, refundID).Scan(&createdAt, &sum, &data)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if data, err = decompressReceiptData(data); err != nil {
+		return merry.Wrap(err)
+	}
+	refund, err := domain.ParseReceiptSummary(data)
+	if err != nil {
+		log.Warn().Err(err).Int64("id", refundID).Msg("can not parse refund data, not linking it to purchase")
+		return nil
+	}
+	if refund.SellerID == "" || len(refund.ItemNames) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT p.id, p.data FROM receipts p
+		WHERE p.domain = ? AND p.is_refund = 0 AND p.data IS NOT NULL
+		  AND p.created_at >= ? AND p.created_at <= ?
+		  AND p.sum + COALESCE((SELECT SUM(r.sum) FROM receipts r WHERE r.refund_of_id = p.id AND r.id != ?), 0)
+		      + 0.005 >= ?
+		ORDER BY p.created_at DESC, p.id DESC`,
+		domain.Code, createdAt.Add(-refundPurchaseMaxAge), createdAt, refundID, -sum)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	var purchaseID int64
+	for rows.Next() {
+		var id int64
+		var purchaseData []byte
+		if err := rows.Scan(&id, &purchaseData); err != nil {
+			rows.Close()
+			return merry.Wrap(err)
+		}
+		if purchaseData, err = decompressReceiptData(purchaseData); err != nil {
+			rows.Close()
+			return merry.Wrap(err)
+		}
+		purchase, err := domain.ParseReceiptSummary(purchaseData)
+		if err != nil {
+			continue
+		}
+		if refundMatchesPurchase(refund, purchase) {
+			purchaseID = id
+			break
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return merry.Wrap(err)
+	}
+	if purchaseID == 0 {
+		return nil
+	}
+	_, err = tx.Exec(`UPDATE receipts SET refund_of_id = ? WHERE id = ?`, purchaseID, refundID)
+	return merry.Wrap(err)
+}
+
+// refundMatchesPurchase: продавец тот же, и все товары возврата есть в покупке
+func refundMatchesPurchase(refund, purchase receipts.ReceiptSummary) bool {
+	if refund.SellerID != purchase.SellerID {
+		return false
+	}
+	names := make(map[string]bool, len(purchase.ItemNames))
+	for _, name := range purchase.ItemNames {
+		names[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	for _, name := range refund.ItemNames {
+		if !names[strings.ToLower(strings.TrimSpace(name))] {
+			return false
+		}
+	}
+	return true
+}
+
+func loadPendingReceipts(db *sql.DB, domainCodes []string, limit int64) ([]*ReceiptPending, error) {
+	args := make([]any, 0, len(domainCodes)+1)
+	for _, code := range domainCodes {
+		args = append(args, code)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(`
+		SELECT id, ref_text, COALESCE(is_correct, 0), data IS NOT NULL
+		FROM receipts
+		WHERE (is_correct IS NULL OR data IS NULL OR refetch_requested_at IS NOT NULL)
+		  AND next_retry_at <= CURRENT_TIMESTAMP
+		  AND retries_left > 0
+		  AND domain IN (`+strings.Repeat(",?", len(domainCodes))[1:]+`)
+		ORDER BY next_retry_at, id
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	var recs []*ReceiptPending
+	for rows.Next() {
+		rec := &ReceiptPending{}
+		err = rows.Scan(&rec.ID, &rec.RefText, &rec.IsCorrect, &rec.HasData)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		rec.Ref, rec.RefErr = receipts.ReceiptRefFromText(allDomains, rec.RefText)
+		recs = append(recs, rec)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return recs, nil
+}
+
+func loadNextRetryTime(db *sql.DB, domainCodes []string) (time.Time, error) {
+	args := make([]any, 0, len(domainCodes))
+	for _, code := range domainCodes {
+		args = append(args, code)
+	}
+
+	var nextRetryAt time.Time
 	err := db.QueryRow(`
 		SELECT next_retry_at FROM receipts
-		WHERE data IS NULL
+		WHERE (data IS NULL OR refetch_requested_at IS NOT NULL)
 		  AND retries_left > 0
 		  AND domain IN (`+strings.Repeat(",?", len(domainCodes))[1:]+`)
 		ORDER BY next_retry_at`, args...).Scan(&nextRetryAt)
@@ -522,22 +1124,219 @@ func loadNextRetryTime(db *sql.DB, domainCodes []string) (time.Time, error) {
 	return nextRetryAt, nil
 }
 
+type ReceiptCounts struct {
+	Domain  string
+	Pending int64
+	Failed  int64
+}
+
+// loadReceiptCounts считает ждущие скачивания и окончательно неудачные чеки по доменам
+func loadReceiptCounts(db *sql.DB) ([]ReceiptCounts, error) {
+	rows, err := db.Query(`
+		SELECT domain,
+		       SUM((is_correct IS NULL OR data IS NULL OR refetch_requested_at IS NOT NULL) AND retries_left > 0),
+		       SUM(data IS NULL AND retries_left <= 0)
+		FROM receipts
+		GROUP BY domain
+		ORDER BY domain`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+
+	var counts []ReceiptCounts
+	for rows.Next() {
+		var c ReceiptCounts
+		if err := rows.Scan(&c.Domain, &c.Pending, &c.Failed); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, merry.Wrap(rows.Err())
+}
+
+type WebhookDelivery struct {
+	ID        int64
+	Webhook   string
+	Event     string
+	ReceiptID int64
+	Payload   []byte
+	Attempts  int64
+}
+
+func saveWebhookDelivery(db *sql.DB, webhook, event string, receiptID int64, payload []byte) error {
+	_, err := db.Exec(`
+		INSERT INTO webhook_deliveries (webhook, event, receipt_id, payload) VALUES (?,?,?,?)`,
+		webhook, event, receiptID, payload)
+	return merry.Wrap(err)
+}
+
+func saveWebhookDelivered(db *sql.DB, id int64) error {
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, last_error = NULL, delivered_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, id)
+	return merry.Wrap(err)
+}
+
+func saveWebhookDeliveryFailure(db *sql.DB, id int64, deliveryErr error, retryDelay time.Duration) error {
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, last_error = ?,
+		    next_attempt_at = datetime(CURRENT_TIMESTAMP, '+' || ? || ' seconds')
+		WHERE id = ?`,
+		deliveryErr.Error(), int64(retryDelay.Seconds()), id)
+	return merry.Wrap(err)
+}
+
+// loadDueWebhookDeliveries — недоставленные события вебхуков webhooks, время отправки которых пришло
+func loadDueWebhookDeliveries(db *sql.DB, webhooks []string, limit int64) ([]*WebhookDelivery, error) {
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+	args := make([]any, 0, len(webhooks)+1)
+	for _, name := range webhooks {
+		args = append(args, name)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(`
+		SELECT id, webhook, event, receipt_id, payload, attempts
+		FROM webhook_deliveries
+		WHERE delivered_at IS NULL
+		  AND next_attempt_at <= CURRENT_TIMESTAMP
+		  AND webhook IN (`+strings.Repeat(",?", len(webhooks))[1:]+`)
+		ORDER BY next_attempt_at, id
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		if err := rows.Scan(&d.ID, &d.Webhook, &d.Event, &d.ReceiptID, &d.Payload, &d.Attempts); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, merry.Wrap(rows.Err())
+}
+
+func loadNextWebhookAttemptTime(db *sql.DB, webhooks []string) (time.Time, error) {
+	if len(webhooks) == 0 {
+		return time.Date(2200, 12, 31, 23, 59, 59, 0, time.UTC), nil
+	}
+	args := make([]any, 0, len(webhooks))
+	for _, name := range webhooks {
+		args = append(args, name)
+	}
+
+	var nextAttemptAt time.Time
+	err := db.QueryRow(`
+		SELECT next_attempt_at FROM webhook_deliveries
+		WHERE delivered_at IS NULL
+		  AND webhook IN (`+strings.Repeat(",?", len(webhooks))[1:]+`)
+		ORDER BY next_attempt_at
+		LIMIT 1`, args...).Scan(&nextAttemptAt)
+	if err == sql.ErrNoRows {
+		return time.Date(2200, 12, 31, 23, 59, 59, 0, time.UTC), nil //timedelta can store ~292 years
+	}
+	if err != nil {
+		return time.Time{}, merry.Wrap(err)
+	}
+	return nextAttemptAt, nil
+}
+
+type NotificationEvent struct {
+	ID        int64
+	Event     string
+	CreatedAt time.Time
+	Receipt   *receipts.Receipt
+}
+
+func saveNotificationEvent(db *sql.DB, event string, receiptID int64) error {
+	_, err := db.Exec(`INSERT INTO notification_events (event, receipt_id) VALUES (?,?)`, event, receiptID)
+	return merry.Wrap(err)
+}
+
+// loadUnsentNotificationEvents — неотправленные события (вместе с чеками), от старых к новым
+func loadUnsentNotificationEvents(db *sql.DB, event string) ([]*NotificationEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, receipt_id, created_at FROM notification_events
+		WHERE sent_at IS NULL AND event = ?
+		ORDER BY id`, event)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+
+	var events []*NotificationEvent
+	var receiptIDs []int64
+	for rows.Next() {
+		e := &NotificationEvent{Event: event}
+		var receiptID int64
+		if err := rows.Scan(&e.ID, &receiptID, &e.CreatedAt); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		events = append(events, e)
+		receiptIDs = append(receiptIDs, receiptID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	rows.Close()
+
+	for i, e := range events {
+		if e.Receipt, err = loadReceipt(db, receiptIDs[i]); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	return events, nil
+}
+
+func saveNotificationEventsSent(db *sql.DB, events []*NotificationEvent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer tx.Rollback()
+	for _, e := range events {
+		if _, err := tx.Exec(`UPDATE notification_events SET sent_at = CURRENT_TIMESTAMP WHERE id = ?`, e.ID); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return merry.Wrap(tx.Commit())
+}
+
 type SQLMultiScanner interface {
 	Scan(...interface{}) error
 }
 
 const receiptSQLFields = `id, domain,
 saved_at, updated_at, created_at, search_key,
-COALESCE(is_correct, FALSE), ref_text, CAST(COALESCE(data, '') AS text),
+COALESCE(is_correct, FALSE), is_refund, sum, COALESCE(refund_of_id, 0), COALESCE(currency, ''),
+ref_text, data, COALESCE(extracted_data, ''),
 retries_left, next_retry_at`
 
 func scanReceipt(row SQLMultiScanner, rec *receipts.Receipt) error {
+	var data []byte
 	err := row.Scan(
 		&rec.ID, &rec.Domain,
 		&rec.SavedAt, &rec.UpdatedAt, &rec.CreatedAt, &rec.SearchKey,
-		&rec.IsCorrect, &rec.RefText, &rec.Data,
+		&rec.IsCorrect, &rec.IsRefund, &rec.Sum, &rec.RefundOfID, &rec.Currency,
+		&rec.RefText, &data, &rec.ExtractedData,
 		&rec.RetriesLeft, &rec.NextRetryAt)
-	return merry.Wrap(err)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	// данные хранятся сжатыми (см. compressReceiptData)
+	if data, err = decompressReceiptData(data); err != nil {
+		return merry.Wrap(err)
+	}
+	rec.Data = string(data)
+	return nil
 }
 
 func loadReceipt(db *sql.DB, id int64) (*receipts.Receipt, error) {
@@ -565,12 +1364,17 @@ func readReceiptsFromRows(rows *sql.Rows) ([]*receipts.Receipt, error) {
 	return recs, nil
 }
 
-func searchAndReadReceipts(db *sql.DB, filter []string, args []interface{}, searchQuery, sortColumn string) ([]*receipts.Receipt, error) {
-	sql := `SELECT ` + receiptSQLFields + ` FROM receipts`
+func appendReceiptsSearchFilter(filter []string, args []interface{}, searchQuery string) ([]string, []interface{}) {
 	if searchQuery != "" {
 		filter = append(filter, `search_key LIKE ? ESCAPE '\'`)
 		args = append(args, "%"+escapeLike(strings.ToLower(searchQuery), `\`)+"%")
 	}
+	return filter, args
+}
+
+func searchAndReadReceipts(db *sql.DB, filter []string, args []interface{}, searchQuery, sortColumn string) ([]*receipts.Receipt, error) {
+	sql := `SELECT ` + receiptSQLFields + ` FROM receipts`
+	filter, args = appendReceiptsSearchFilter(filter, args, searchQuery)
 	if len(filter) > 0 {
 		sql += " WHERE " + strings.Join(filter, " AND ")
 	}
@@ -601,3 +1405,360 @@ func loadReceiptsSortedByCreatedAt(db *sql.DB, beforeTime time.Time, searchQuery
 	}
 	return searchAndReadReceipts(db, filter, args, searchQuery, "created_at")
 }
+
+// receiptsSortMode — колонка сортировки списка чеков и выражение для её значения в курсоре
+// (время — как текст: в SQLite оно хранится и сравнивается строкой, при разборе в time.Time
+// курсор мог бы разойтись с хранимым значением)
+type receiptsSortMode struct {
+	Column  string
+	KeyExpr string
+}
+
+var receiptsSortModes = map[string]receiptsSortMode{
+	"id":         {"id", ""},
+	"created_at": {"created_at", "CAST(created_at AS TEXT)"},
+	"updated_at": {"updated_at", "CAST(updated_at AS TEXT)"},
+	"saved_at":   {"saved_at", "CAST(saved_at AS TEXT)"},
+	"sum":        {"sum", "sum"},
+}
+
+const receiptsPageDefaultSize = 25
+const receiptsPageMaxSize = 500
+
+var ErrReceiptsCursorInvalid = merry.New("receipts cursor is invalid")
+
+// ReceiptsCursor — место в списке чеков: значение колонки сортировки и ID последнего загруженного чека
+// (ID различает чеки с одинаковым значением). Клиенту отдаётся закодированным (см. Encode).
+type ReceiptsCursor struct {
+	SortMode string      `json:"s"`
+	Asc      bool        `json:"a,omitempty"`
+	Key      interface{} `json:"k,omitempty"`
+	ID       int64       `json:"i"`
+}
+
+func (c ReceiptsCursor) Encode() string {
+	buf, _ := json.Marshal(c) //тут только строки и числа
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func DecodeReceiptsCursor(str string) (*ReceiptsCursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, ErrReceiptsCursorInvalid.Here()
+	}
+	c := &ReceiptsCursor{}
+	if err := json.Unmarshal(buf, c); err != nil {
+		return nil, ErrReceiptsCursorInvalid.Here()
+	}
+	mode, ok := receiptsSortModes[c.SortMode]
+	if !ok {
+		return nil, ErrReceiptsCursorInvalid.Here()
+	}
+	switch c.Key.(type) {
+	case nil:
+		ok = mode.KeyExpr == ""
+	case string:
+		ok = mode.KeyExpr != "" && c.SortMode != "sum"
+	case float64:
+		ok = c.SortMode == "sum"
+	default:
+		ok = false
+	}
+	if !ok {
+		return nil, ErrReceiptsCursorInvalid.Here()
+	}
+	return c, nil
+}
+
+// ReceiptsListQuery — параметры страницы списка чеков. Cursor (если есть) должен быть
+// от того же режима и направления сортировки.
+type ReceiptsListQuery struct {
+	SortMode string
+	Asc      bool
+	Search   string
+	Cursor   *ReceiptsCursor
+	Limit    int
+}
+
+// scannerWithExtra дописывает в Scan дополнительные колонки после полей чека
+type scannerWithExtra struct {
+	SQLMultiScanner
+	extra []interface{}
+}
+
+func (s scannerWithExtra) Scan(dest ...interface{}) error {
+	return s.SQLMultiScanner.Scan(append(dest, s.extra...)...)
+}
+
+// loadReceiptsPage загружает страницу чеков после q.Cursor.
+// nextCursor == nil, если это последняя страница.
+func loadReceiptsPage(db *sql.DB, q ReceiptsListQuery) (recs []*receipts.Receipt, nextCursor *ReceiptsCursor, err error) {
+	mode, ok := receiptsSortModes[q.SortMode]
+	if !ok {
+		return nil, nil, merry.Errorf("unknown receipts sort mode %q", q.SortMode)
+	}
+	if q.Cursor != nil && (q.Cursor.SortMode != q.SortMode || q.Cursor.Asc != q.Asc) {
+		return nil, nil, ErrReceiptsCursorInvalid.Here()
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = receiptsPageDefaultSize
+	}
+	if limit > receiptsPageMaxSize {
+		limit = receiptsPageMaxSize
+	}
+	order, cmp := "DESC", "<"
+	if q.Asc {
+		order, cmp = "ASC", ">"
+	}
+
+	var filter []string
+	var args []interface{}
+	if q.Cursor != nil {
+		if mode.KeyExpr == "" {
+			filter = append(filter, "id "+cmp+" ?")
+			args = append(args, q.Cursor.ID)
+		} else {
+			filter = append(filter, "("+mode.Column+", id) "+cmp+" (?, ?)")
+			args = append(args, q.Cursor.Key, q.Cursor.ID)
+		}
+	}
+	filter, args = appendReceiptsSearchFilter(filter, args, q.Search)
+
+	keyExpr := mode.KeyExpr
+	if keyExpr == "" {
+		keyExpr = "NULL"
+	}
+	query := `SELECT ` + receiptSQLFields + `, ` + keyExpr + ` FROM receipts`
+	if len(filter) > 0 {
+		query += " WHERE " + strings.Join(filter, " AND ")
+	}
+	query += " ORDER BY " + mode.Column + " " + order + ", id " + order + " LIMIT ?"
+	args = append(args, limit+1) //лишний чек — чтоб узнать, есть ли следующая страница
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+	var lastKey interface{}
+	for rows.Next() {
+		if len(recs) == limit {
+			nextCursor = &ReceiptsCursor{SortMode: q.SortMode, Asc: q.Asc, Key: lastKey, ID: recs[len(recs)-1].ID}
+			break
+		}
+		rec := &receipts.Receipt{}
+		if err := scanReceipt(scannerWithExtra{rows, []interface{}{&lastKey}}, rec); err != nil {
+			return nil, nil, merry.Wrap(err)
+		}
+		recs = append(recs, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	return recs, nextCursor, nil
+}
+
+// deleteReceipt удаляет чек и его неотправленные уведомления. false — такого чека нет.
+// Уже поставленные в очередь вебхуки (с готовым телом) всё равно отправятся.
+func deleteReceipt(db *sql.DB, id int64) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	found, err := deleteReceiptTx(tx, id)
+	if err != nil || !found {
+		return false, merry.Wrap(err)
+	}
+	return true, merry.Wrap(tx.Commit())
+}
+
+func deleteReceiptTx(tx *sql.Tx, id int64) (bool, error) {
+	res, err := tx.Exec(`DELETE FROM receipts WHERE id = ?`, id)
+	if err != nil {
+		return false, merry.Wrap(err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, merry.Wrap(err)
+	}
+	if count == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(`DELETE FROM notification_events WHERE receipt_id = ? AND sent_at IS NULL`, id); err != nil {
+		return false, merry.Wrap(err)
+	}
+	if _, err := tx.Exec(`DELETE FROM receipt_data_versions WHERE receipt_id = ?`, id); err != nil {
+		return false, merry.Wrap(err)
+	}
+	// возвраты удалённой покупки становятся несвязанными
+	if _, err := tx.Exec(`UPDATE receipts SET refund_of_id = NULL WHERE refund_of_id = ?`, id); err != nil {
+		return false, merry.Wrap(err)
+	}
+	return true, nil
+}
+
+// mergeDuplicateReceipts пересчитывает fiscal_key всех чеков и из каждой группы чеков с одинаковым
+// fiscal_key оставляет один (скачанный, если такой есть, иначе самый старый), остальные удаляет.
+// Возвращает ID удалённых чеков.
+func mergeDuplicateReceipts(db *sql.DB, allDomains []receipts.Domain) ([]int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, ref_text FROM receipts`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	id2fiscalKey := map[int64]interface{}{}
+	for rows.Next() {
+		var id int64
+		var refText string
+		if err := rows.Scan(&id, &refText); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+		if err != nil {
+			log.Warn().Err(err).Int64("id", id).Msg("can not parse receipt ref, skipping")
+			continue
+		}
+		id2fiscalKey[id] = fiscalKeyOrNull(ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	for id, fiscalKey := range id2fiscalKey {
+		if _, err := tx.Exec(`UPDATE receipts SET fiscal_key = ? WHERE id = ?`, fiscalKey, id); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+
+	rows, err = tx.Query(`
+		SELECT id, fiscal_key FROM receipts
+		WHERE fiscal_key IN (SELECT fiscal_key FROM receipts WHERE fiscal_key IS NOT NULL GROUP BY fiscal_key HAVING count(*) > 1)
+		ORDER BY fiscal_key, data IS NOT NULL DESC, COALESCE(is_correct, 0) DESC, id`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	var keptID int64
+	var prevFiscalKey string
+	var deletedIDs []int64
+	id2keptID := map[int64]int64{}
+	for rows.Next() {
+		var id int64
+		var fiscalKey string
+		if err := rows.Scan(&id, &fiscalKey); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if fiscalKey != prevFiscalKey {
+			keptID, prevFiscalKey = id, fiscalKey
+			continue
+		}
+		deletedIDs = append(deletedIDs, id)
+		id2keptID[id] = keptID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	for _, id := range deletedIDs {
+		if err := moveDuplicateReceiptTx(tx, id, id2keptID[id]); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if _, err := deleteReceiptTx(tx, id); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		log.Info().Int64("id", id).Int64("kept_id", id2keptID[id]).Msg("duplicate receipt removed")
+	}
+	return deletedIDs, merry.Wrap(tx.Commit())
+}
+
+// moveDuplicateReceiptTx переносит на оставляемый чек keptID всё, что ссылается на удаляемый дубликат dupID:
+// связанные возвраты, прошлые версии данных и сами данные (как прошлую версию, если они другие)
+func moveDuplicateReceiptTx(tx *sql.Tx, dupID, keptID int64) error {
+	if _, err := tx.Exec(`UPDATE receipts SET refund_of_id = ? WHERE refund_of_id = ?`, keptID, dupID); err != nil {
+		return merry.Wrap(err)
+	}
+	if _, err := tx.Exec(`UPDATE receipt_data_versions SET receipt_id = ? WHERE receipt_id = ?`, keptID, dupID); err != nil {
+		return merry.Wrap(err)
+	}
+
+	var dupData []byte
+	var dupFetchedAt sql.NullTime
+	var dupUpdatedAt time.Time
+	err := tx.QueryRow(`SELECT data, data_fetched_at, updated_at FROM receipts WHERE id = ?`, dupID).
+		Scan(&dupData, &dupFetchedAt, &dupUpdatedAt)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if dupData == nil {
+		return nil
+	}
+	if !dupFetchedAt.Valid {
+		dupFetchedAt.Time = dupUpdatedAt
+	}
+	// данные есть и у оставляемого (чеки с данными оставляются первыми)
+	var keptData []byte
+	if err := tx.QueryRow(`SELECT data FROM receipts WHERE id = ?`, keptID).Scan(&keptData); err != nil {
+		return merry.Wrap(err)
+	}
+	if keptData, err = decompressReceiptData(keptData); err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(saveReceiptDataVersion(tx, keptID, dupData, dupFetchedAt.Time, keptData))
+}
+
+// countReceipts — сколько всего чеков подходит под поиск
+func countReceipts(db *sql.DB, searchQuery string) (int64, error) {
+	filter, args := appendReceiptsSearchFilter(nil, nil, searchQuery)
+	query := `SELECT count(*) FROM receipts`
+	if len(filter) > 0 {
+		query += " WHERE " + strings.Join(filter, " AND ")
+	}
+	var count int64
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return count, nil
+}
+
+// sumReceiptsByCurrency — суммы подходящих под поиск чеков по валютам (символ валюты -> сумма).
+// Возвраты вычитаются (их sum отрицательная).
+func sumReceiptsByCurrency(db *sql.DB, searchQuery string) (map[string]float64, error) {
+	filter, args := appendReceiptsSearchFilter(nil, nil, searchQuery)
+	query := `SELECT domain, COALESCE(currency, ''), SUM(sum) FROM receipts`
+	if len(filter) > 0 {
+		query += " WHERE " + strings.Join(filter, " AND ")
+	}
+	query += " GROUP BY domain, currency"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+
+	currencies := make(map[string]string, len(allDomains))
+	for _, d := range allDomains {
+		currencies[d.Code] = d.CurrencySymbol
+	}
+	totals := map[string]float64{}
+	for rows.Next() {
+		var domainCode, currency string
+		var sum float64
+		if err := rows.Scan(&domainCode, &currency, &sum); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if currency == "" {
+			var ok bool
+			if currency, ok = currencies[domainCode]; !ok {
+				currency = domainCode
+			}
+		}
+		totals[currency] = math.Round((totals[currency]+sum)*100) / 100
+	}
+	return totals, merry.Wrap(rows.Err())
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"os"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/uz_soliq"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+func TestLoadReceiptsPage(t *testing.T) {
+	db := openTestDB(t)
+
+	// у нескольких чеков одинаковое время — страницы не должны их терять или повторять
+	type testRec struct {
+		Time string
+		Sum  string
+	}
+	var ids []int64
+	for i, r := range []testRec{
+		{"20260310T093045", "50.00"},
+		{"20260310T093045", "10.00"},
+		{"20260311T100000", "30.00"},
+		{"20260310T093045", "70.00"},
+		{"20260309T080000", "20.00"},
+		{"20260311T100000", "60.00"},
+		{"20260310T093045", "40.00"},
+	} {
+		refText := "https://app.kassa.wipon.kz/consumer?i=56123400" + strconv.Itoa(10+i) + "&f=600407890704&s=" + r.Sum + "&t=" + r.Time
+		ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := saveRecieptRef(db, ref, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	loadAll := func(sortMode string, asc bool, limit int) []int64 {
+		t.Helper()
+		var res []int64
+		q := ReceiptsListQuery{SortMode: sortMode, Asc: asc, Limit: limit}
+		for page := 0; ; page++ {
+			recs, next, err := loadReceiptsPage(db, q)
+			if err != nil {
+				t.Fatalf("%s page %d: %v", sortMode, page, err)
+			}
+			if len(recs) > limit || (next != nil && len(recs) != limit) {
+				t.Fatalf("%s page %d: %d receipts, next cursor %v", sortMode, page, len(recs), next)
+			}
+			for _, rec := range recs {
+				res = append(res, rec.ID)
+			}
+			if next == nil {
+				return res
+			}
+			if q.Cursor, err = DecodeReceiptsCursor(next.Encode()); err != nil {
+				t.Fatalf("%s page %d: decoding cursor: %v", sortMode, page, err)
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		SortMode string
+		Asc      bool
+		Want     []int64 //индексы в ids
+	}{
+		{"id", false, []int64{6, 5, 4, 3, 2, 1, 0}},
+		{"id", true, []int64{0, 1, 2, 3, 4, 5, 6}},
+		{"created_at", false, []int64{5, 2, 6, 3, 1, 0, 4}},
+		{"created_at", true, []int64{4, 0, 1, 3, 6, 2, 5}},
+		{"sum", false, []int64{3, 5, 0, 6, 2, 4, 1}},
+		{"sum", true, []int64{1, 4, 2, 6, 0, 5, 3}},
+	} {
+		var want []int64
+		for _, i := range c.Want {
+			want = append(want, ids[i])
+		}
+		for _, limit := range []int{1, 3, 7, 25} {
+			if got := loadAll(c.SortMode, c.Asc, limit); !slices.Equal(got, want) {
+				t.Errorf("%s asc=%v limit=%d: got %v, want %v", c.SortMode, c.Asc, limit, got, want)
+			}
+		}
+	}
+
+	// курсор другого режима сортировки не подходит
+	_, next, err := loadReceiptsPage(db, ReceiptsListQuery{SortMode: "sum", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadReceiptsPage(db, ReceiptsListQuery{SortMode: "created_at", Cursor: next}); err == nil {
+		t.Errorf("expected error for cursor of other sort mode")
+	}
+	for _, str := range []string{"garbage", next.Encode() + "x", (&ReceiptsCursor{SortMode: "sum", Key: "text"}).Encode()} {
+		if _, err := DecodeReceiptsCursor(str); err == nil {
+			t.Errorf("DecodeReceiptsCursor(%q): expected error", str)
+		}
+	}
+
+	for search, want := range map[string]int64{"": 7, "5612340012": 1, "nothing": 0} {
+		if count, err := countReceipts(db, search); err != nil || count != want {
+			t.Errorf("countReceipts(%q) = %d, %v, want %d", search, count, err, want)
+		}
+	}
+}
+
+func TestReceiptFiscalKeyDedup(t *testing.T) {
+	db := openTestDB(t)
+
+	save := func(refText string) (int64, error) {
+		t.Helper()
+		ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saveRecieptRef(db, ref, 10)
+	}
+
+	// тот же чек через другого провайдера и в другом формате времени
+	for _, refs := range [][2]string{
+		{"http://consumer.oofd.kz?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045",
+			"https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045"},
+		{"t=20230808T1011&s=123.45&fn=9999078900012345&i=1234&fp=1234567890&n=1",
+			"t=20230808T101100&s=123.45&fn=9999078900012345&i=1234&fp=1234567890&n=1"},
+	} {
+		id, err := save(refs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dupID, err := save(refs[1]); !merry.Is(err, ErrReceiptRefAlreadyExists) || dupID != id {
+			t.Errorf("saving %s after %s = #%d, %v, want #%d and already exists", refs[1], refs[0], dupID, err, id)
+		}
+	}
+
+	// редирект прокси-чека на уже сохранённый чек
+	proxyRef, _ := receipts.ReceiptRefFromText(allDomains, "https://app.kassa.wipon.kz/links/check/8ab7d2bb-1234-4a8c-4321-02185db12a23")
+	if _, err := saveRecieptRef(db, proxyRef, 10); err != nil {
+		t.Fatal(err)
+	}
+	newRef, _ := receipts.ReceiptRefFromText(allDomains, "https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045")
+	if err := replaceReceiptRef(db, proxyRef, newRef, 10); !merry.Is(err, ErrReceiptRedirectDuplicate) {
+		t.Errorf("replaceReceiptRef() error = %v, want redirect duplicate", err)
+	}
+}
+
+func TestMergeDuplicateReceipts(t *testing.T) {
+	db := openTestDB(t)
+
+	// дубликаты, сохранённые до появления fiscal_key
+	var ids []int64
+	for _, r := range []struct {
+		RefText string
+		Data    interface{}
+	}{
+		{"http://consumer.oofd.kz?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045", nil},
+		{"https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045", `{"ok":1}`},
+		{"https://app.kassa.wipon.kz/consumer?t=20260310T093045&s=2750.00&f=600407890704&i=5612340017&x=1", `{"ok":2}`},
+		{"t=20230808T1011&s=123.45&fn=9999078900012345&i=1234&fp=1234567890&n=1", nil},
+		{"t=20230808T101100&s=123.45&fn=9999078900012345&i=1234&fp=1234567890&n=1", nil},
+		{"https://app.kassa.wipon.kz/links/check/8ab7d2bb-1234-4a8c-4321-02185db12a23", nil},
+		{"https://app.kassa.wipon.kz/links/check/019c387e-9e9a-717c-a70e-d6c7f6e0ff67", nil},
+	} {
+		res, err := db.Exec(`
+			INSERT INTO receipts (domain, unique_key, ref_text, created_at, data, is_correct)
+			VALUES ('', ?, ?, CURRENT_TIMESTAMP, ?, ?)`, r.RefText, r.RefText, r.Data, r.Data != nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	if _, err := db.Exec(`INSERT INTO notification_events (event, receipt_id) VALUES (?, ?)`, EventReceiptFailed, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	// возврат, связанный с дубликатом, и прошлые данные дубликата
+	res, err := db.Exec(`
+		INSERT INTO receipts (domain, unique_key, ref_text, created_at, is_refund, refund_of_id)
+		VALUES ('', 'refund', 'refund', CURRENT_TIMESTAMP, 1, ?)`, ids[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	refundID, _ := res.LastInsertId()
+	if _, err := db.Exec(`INSERT INTO receipt_data_versions (receipt_id, data, fetched_at) VALUES (?, '{"ok":0}', CURRENT_TIMESTAMP)`, ids[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	deletedIDs, err := mergeDuplicateReceipts(db, allDomains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(deletedIDs)
+	// из kz остаётся скачанный, из ru — самый старый
+	if want := []int64{ids[0], ids[2], ids[4]}; !slices.Equal(deletedIDs, want) {
+		t.Errorf("deleted %v, want %v", deletedIDs, want)
+	}
+	var count int
+	db.QueryRow(`SELECT count(*) FROM receipts`).Scan(&count)
+	if count != 5 {
+		t.Errorf("receipts left: %d, want 5", count)
+	}
+	var refundOfID int64
+	db.QueryRow(`SELECT refund_of_id FROM receipts WHERE id = ?`, refundID).Scan(&refundOfID)
+	if refundOfID != ids[3] {
+		t.Errorf("refund linked to #%d, want kept #%d", refundOfID, ids[3])
+	}
+	// данные дубликата и его прошлые версии — прошлые версии оставшегося
+	versions, err := loadReceiptDataVersions(db, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versionsData []string
+	for _, v := range versions {
+		versionsData = append(versionsData, v.Data)
+	}
+	slices.Sort(versionsData)
+	if want := []string{`{"ok":0}`, `{"ok":2}`}; !slices.Equal(versionsData, want) {
+		t.Errorf("kept receipt versions = %v, want %v", versionsData, want)
+	}
+	db.QueryRow(`SELECT count(*) FROM notification_events`).Scan(&count)
+	if count != 0 {
+		t.Errorf("notification events left: %d, want 0", count)
+	}
+
+	if deletedIDs, err := mergeDuplicateReceipts(db, allDomains); err != nil || len(deletedIDs) != 0 {
+		t.Errorf("second merge = %v, %v, want nothing", deletedIDs, err)
+	}
+}
+
+func TestReceiptRefundLinking(t *testing.T) {
+	db := openTestDB(t)
+
+	ruData := func(inn string, items ...string) string {
+		data := `{"ticket":{"document":{"receipt":{"userInn":"` + inn + `","items":[`
+		for i, item := range items {
+			if i > 0 {
+				data += ","
+			}
+			data += `{"name":"` + item + `","quantity":1}`
+		}
+		return data + `]}}}}`
+	}
+	save := func(refText, data string) int64 {
+		t.Helper()
+		ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := saveRecieptRef(db, ref, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data != "" {
+			if err := saveRecieptData(db, ref, []byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id
+	}
+
+	purchaseID := save("t=20260301T1000&s=300.00&fn=9999078900012345&i=10&fp=1000000001&n=1",
+		ruData("7701234567", "Чайник", "Кефир 1%"))
+	refundID := save("t=20260303T1200&s=250.00&fn=9999078900012345&i=11&fp=1000000002&n=2",
+		ruData("7701234567  ", "чайник"))
+	// от покупки осталось 50, этот возврат больше
+	overRefundID := save("t=20260303T1230&s=100.00&fn=9999078900012345&i=13&fp=1000000004&n=2",
+		ruData("7701234567", "Кефир 1%"))
+	// другой продавец
+	otherSellerID := save("t=20260303T1300&s=50.00&fn=9999078900012345&i=12&fp=1000000003&n=2",
+		ruData("7709999999", "Кефир 1%"))
+	// покупка скачалась позже возврата
+	lateRefundID := save("t=20260305T1000&s=90.00&fn=9999078900054321&i=20&fp=2000000002&n=2",
+		ruData("7705555555", "Молоко"))
+	latePurchaseRef := "t=20260304T1000&s=90.00&fn=9999078900054321&i=19&fp=2000000001&n=1"
+	latePurchaseID := save(latePurchaseRef, "")
+	ref, _ := receipts.ReceiptRefFromText(allDomains, latePurchaseRef)
+	if err := saveRecieptData(db, ref, []byte(ruData("7705555555", "Молоко"))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		ID         int64
+		IsRefund   bool
+		Sum        float64
+		RefundOfID int64
+	}{
+		{purchaseID, false, 300, 0},
+		{refundID, true, -250, purchaseID},
+		{overRefundID, true, -100, 0},
+		{otherSellerID, true, -50, 0},
+		{lateRefundID, true, -90, latePurchaseID},
+		{latePurchaseID, false, 90, 0},
+	} {
+		rec, err := loadReceipt(db, c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.IsRefund != c.IsRefund || rec.Sum != c.Sum || rec.RefundOfID != c.RefundOfID {
+			t.Errorf("receipt #%d: isRefund=%v sum=%v refundOf=%d, want %v %v %d",
+				c.ID, rec.IsRefund, rec.Sum, rec.RefundOfID, c.IsRefund, c.Sum, c.RefundOfID)
+		}
+	}
+
+	totals, err := sumReceiptsByCurrency(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals["₽"] != -100 {
+		t.Errorf("totals = %v, want ₽: -100", totals)
+	}
+
+	// возврат удалённой покупки остаётся без связи
+	if ok, err := deleteReceipt(db, purchaseID); err != nil || !ok {
+		t.Fatalf("deleteReceipt() = %v %v", ok, err)
+	}
+	if rec, err := loadReceipt(db, refundID); err != nil || rec.RefundOfID != 0 {
+		t.Errorf("refund of deleted purchase: refundOf=%d %v, want 0", rec.RefundOfID, err)
+	}
+}
+
+func TestReceiptRefetchAndDataVersions(t *testing.T) {
+	db := openTestDB(t)
+
+	ref, err := receipts.ReceiptRefFromText(allDomains, "t=20260301T1000&s=300.00&fn=9999078900012345&i=10&fp=1000000001&n=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := saveRecieptRef(db, ref, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingIDs := func() []int64 {
+		t.Helper()
+		recs, err := loadPendingReceipts(db, []string{ref.Domain().Code}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, rec := range recs {
+			ids = append(ids, rec.ID)
+		}
+		return ids
+	}
+
+	// ещё не скачан — повторно скачивать нечего
+	if ok, err := requestReceiptRefetch(db, id, 5); err != nil || ok {
+		t.Errorf("refetch of not fetched = %v %v, want false", ok, err)
+	}
+
+	for _, data := range []string{`{"v":1}`, `{"v":1}`, `{"v":2}`} {
+		if err := saveRecieptData(db, ref, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := loadReceiptDataVersions(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Data != `{"v":1}` {
+		t.Errorf("versions = %+v, want only v1 (same data is not duplicated)", versions)
+	}
+	if ids := pendingIDs(); len(ids) != 0 {
+		t.Errorf("pending = %v, want none", ids)
+	}
+
+	// повторное скачивание: чек снова в очереди, с данными
+	if ok, err := requestReceiptRefetch(db, id, 2); err != nil || !ok {
+		t.Fatalf("refetch = %v %v, want true", ok, err)
+	}
+	if recs, _ := loadPendingReceipts(db, []string{ref.Domain().Code}, 10); len(recs) != 1 || !recs[0].HasData {
+		t.Fatalf("pending = %+v, want #%d with data", recs, id)
+	}
+	if err := saveRecieptData(db, ref, []byte(`{"v":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := pendingIDs(); len(ids) != 0 {
+		t.Errorf("pending after refetch = %v, want none", ids)
+	}
+	if versions, _ := loadReceiptDataVersions(db, id); len(versions) != 2 || versions[0].Data != `{"v":2}` {
+		t.Errorf("versions = %+v, want v2 and v1", versions)
+	}
+
+	// неудачное повторное скачивание: после последней попытки запрос снимается, данные остаются
+	if _, err := requestReceiptRefetch(db, id, 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := saveReceiptFailure(db, ref, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`UPDATE receipts SET next_retry_at = CURRENT_TIMESTAMP`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids := pendingIDs(); len(ids) != 0 {
+		t.Errorf("pending after failed refetch = %v, want none", ids)
+	}
+	rec, err := loadReceipt(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.IsCorrect || rec.Data != `{"v":3}` {
+		t.Errorf("receipt after failed refetch = %+v, want v3 kept", rec)
+	}
+
+	if _, err := deleteReceipt(db, id); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := loadReceiptDataVersions(db, id); len(versions) != 0 {
+		t.Errorf("versions of deleted receipt = %+v", versions)
+	}
+}
+
+func TestReceiptSumFromData(t *testing.T) {
+	// uz-soliq пока не в allDomains (см. main.go)
+	prevDomains := allDomains
+	allDomains = append(slices.Clone(allDomains), uz_soliq.Domain)
+	t.Cleanup(func() { allDomains = prevDomains })
+	db := openTestDB(t)
+
+	ref, err := receipts.ReceiptRefFromText(allDomains, "https://ofd.soliq.uz/check?t=UZ191211501001&r=12345&c=20240115143000&s=123456789012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := saveRecieptRef(db, ref, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals, err := sumReceiptsByCurrency(db, ""); err != nil || totals["сўм"] != 0 {
+		t.Errorf("totals before fetch = %v %v, want 0", totals, err)
+	}
+
+	page, err := os.ReadFile("uz_soliq/testdata/check.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRecieptData(db, ref, page); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := loadReceipt(db, id)
+	if err != nil || rec.Sum != 29000 || !strings.Contains(rec.SearchKey, "sut 1l") {
+		t.Errorf("fetched receipt sum %v, search key %q, %v", rec.Sum, rec.SearchKey, err)
+	}
+	if totals, err := sumReceiptsByCurrency(db, ""); err != nil || totals["сўм"] != 29000 {
+		t.Errorf("totals = %v %v, want 29000 сўм", totals, err)
+	}
+}
+
+func TestReceiptDataVersionFetchedAt(t *testing.T) {
+	db := openTestDB(t)
+
+	ref, err := receipts.ReceiptRefFromText(allDomains, "t=20260301T1000&s=300.00&fn=9999078900012345&i=10&fp=1000000001&n=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := saveRecieptRef(db, ref, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRecieptData(db, ref, []byte(`{"v":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	// данные скачаны давно, после этого чек менялся (запрос повторного скачивания, неудачная попытка)
+	_, err = db.Exec(`UPDATE receipts SET data_fetched_at = '2026-03-01 10:00:00', updated_at = '2026-03-05 10:00:00' WHERE id = ?`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRecieptData(db, ref, []byte(`{"v":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := loadReceiptDataVersions(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	if len(versions) != 1 || !versions[0].FetchedAt.Equal(want) {
+		t.Errorf("versions = %+v, want v1 fetched at %s", versions, want)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "github.com/ansel1/merry"
+
+// события жизненного цикла чека (отправляются апдейтером и сервером)
+const (
+	EventReceiptAdded   = "receipt_added"   //чек отсканирован
+	EventReceiptFetched = "receipt_fetched" //данные чека скачаны
+	EventReceiptCorrect = "receipt_correct" //провайдер подтвердил, что чек существует
+	EventReceiptFailed  = "receipt_failed"  //попытки скачать чек закончились
+)
+
+var receiptEvents = []string{EventReceiptAdded, EventReceiptFetched, EventReceiptCorrect, EventReceiptFailed}
+
+// ReceiptEventHandler получает события чеков. Обработчик должен только быстро сохранить событие
+// (например, в очередь в БД), сама отправка — в его фоновом компоненте.
+type ReceiptEventHandler interface {
+	HandleReceiptEvent(event string, receiptID int64) error
+}
+
+// ReceiptEvents рассылает события чеков всем обработчикам (вебхуки, уведомления)
+type ReceiptEvents []ReceiptEventHandler
+
+func (e ReceiptEvents) Emit(event string, receiptID int64) error {
+	for _, handler := range e {
+		if err := handler.HandleReceiptEvent(event, receiptID); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"receipt_qr_scanner/utils"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+const updaterComponent = "updater"
+const broadcasterComponent = "broadcaster"
+
+// паузы перед перезапуском упавшего компонента: от минимальной, удваиваясь до максимальной
+var supervisorMinBackoff = time.Second
+var supervisorMaxBackoff = 5 * time.Minute
+
+type ComponentStatus struct {
+	Name        string     `json:"name"`
+	IsOK        bool       `json:"isOk"`
+	Restarts    int64      `json:"restarts"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// Health — состояние фоновых компонентов (апдейтер, рассылка обновлений)
+type Health struct {
+	components []*ComponentStatus
+	onChange   func(ComponentStatus)
+	mutex      sync.Mutex
+}
+
+func NewHealth(componentNames ...string) *Health {
+	h := &Health{}
+	for _, name := range componentNames {
+		h.components = append(h.components, &ComponentStatus{Name: name, IsOK: true, UpdatedAt: time.Now()})
+	}
+	return h
+}
+
+func (h *Health) component(name string) *ComponentStatus {
+	for _, c := range h.components {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &ComponentStatus{Name: name}
+	h.components = append(h.components, c)
+	return c
+}
+
+// SetOnChange задаёт функцию, которая вызывается, когда компонент становится (не)работающим
+// или получает новую ошибку. Вызывается синхронно, поэтому должна быть быстрой.
+func (h *Health) SetOnChange(onChange func(ComponentStatus)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.onChange = onChange
+}
+
+func (h *Health) SetOK(name string) {
+	h.mutex.Lock()
+	c := h.component(name)
+	wasOK := c.IsOK
+	c.IsOK = true
+	c.UpdatedAt = time.Now()
+	status, onChange := *c, h.onChange
+	h.mutex.Unlock()
+
+	if !wasOK && onChange != nil {
+		onChange(status)
+	}
+}
+
+func (h *Health) SetError(name string, err error) {
+	h.mutex.Lock()
+	now := time.Now()
+	c := h.component(name)
+	c.IsOK = false
+	c.LastError = err.Error()
+	c.LastErrorAt = &now
+	c.UpdatedAt = now
+	status, onChange := *c, h.onChange
+	h.mutex.Unlock()
+
+	if onChange != nil {
+		onChange(status)
+	}
+}
+
+func (h *Health) Status(name string) ComponentStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return *h.component(name)
+}
+
+func (h *Health) addRestart(name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.component(name).Restarts++
+}
+
+func (h *Health) Statuses() []ComponentStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	res := make([]ComponentStatus, len(h.components))
+	for i, c := range h.components {
+		res[i] = *c
+	}
+	return res
+}
+
+func (h *Health) IsOK() bool {
+	for _, c := range h.Statuses() {
+		if !c.IsOK {
+			return false
+		}
+	}
+	return true
+}
+
+// superviseComponent выполняет run, пока не отменён ctx: после ошибки или паники
+// пишет её в health и перезапускает run с растущей паузой.
+func superviseComponent(ctx context.Context, health *Health, name string, run func(ctx context.Context) error) {
+	backoff := supervisorMinBackoff
+	for {
+		startedAt := time.Now()
+		err := runRecovered(ctx, run)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = merry.New("stopped unexpectedly")
+		}
+		// долго проработал — значит, упал не сразу после прошлого перезапуска
+		if time.Since(startedAt) > supervisorMaxBackoff {
+			backoff = supervisorMinBackoff
+		}
+
+		health.SetError(name, err)
+		health.addRestart(name)
+		log.Error().Stack().Err(err).Str("component", name).Str("restart_in", backoff.String()).Msg("component failed")
+
+		if err := utils.SleepCtx(ctx, backoff); err != nil {
+			return
+		}
+		backoff = min(backoff*2, supervisorMaxBackoff)
+	}
+}
+
+func runRecovered(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = merry.Errorf("panic: %v", p)
+		}
+	}()
+	return run(ctx)
+}
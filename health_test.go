@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+func TestSuperviseComponent(t *testing.T) {
+	supervisorMinBackoff, supervisorMaxBackoff = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { supervisorMinBackoff, supervisorMaxBackoff = time.Second, 5*time.Minute })
+
+	health := NewHealth("test")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		superviseComponent(ctx, health, "test", func(ctx context.Context) error {
+			runs++
+			switch runs {
+			case 1:
+				return merry.New("database is locked")
+			case 2:
+				panic("oops")
+			default:
+				health.SetOK("test")
+				<-ctx.Done()
+				return nil
+			}
+		})
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !health.IsOK() || health.Statuses()[0].Restarts < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("component not restarted: %+v", health.Statuses())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	status := health.Statuses()[0]
+	if runs != 3 || status.Restarts != 2 || status.LastError != "panic: oops" || status.LastErrorAt == nil {
+		t.Errorf("runs = %d, status = %+v", runs, status)
+	}
+}
@@ -1,6 +1,7 @@
 package kg_gns
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"receipt_qr_scanner/receipts"
@@ -12,13 +13,15 @@ import (
 
 var ErrReceiptItemsNotReady = merry.New("receipt items not ready")
 
-type Client struct{}
+type Client struct {
+	cfg receipts.ClientConfig
+}
 
 func (c *Client) Init() error {
 	return nil
 }
 
-func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
+func (c *Client) FetchReceipt(ctx context.Context, iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
 	res := receipts.FetchReceiptResult{ShouldDecreaseRetries: false, Data: nil}
 
 	ref, err := receipts.CastReceiptRefTo[ReceiptRef](iRef, Domain.Code)
@@ -26,12 +29,20 @@ func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error
 		return res, err
 	}
 
-	req, err := http.NewRequest("GET", ref.RefText(), nil)
+	// ссылка из QR-кода уже указывает на АПИ
+	apiURL := ref.RefText()
+	if c.cfg.BaseURL != "" {
+		if apiURL, err = utils.ReplaceURLBase(apiURL, c.cfg.BaseURL); err != nil {
+			return res, merry.Wrap(err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return res, merry.Wrap(err)
 	}
 
-	resp, buf, err := utils.GetHTTPBody(http.DefaultClient, req)
+	resp, buf, err := utils.GetHTTPBody(c.cfg.HTTPClientOr(utils.DefaultHTTPClient), req)
 	if err != nil {
 		return res, err
 	}
@@ -0,0 +1,46 @@
+package kg_gns
+
+import (
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
+	"testing"
+)
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("https://tax.salyk.kg/tax-web-control/client/api/v1/ticket?date=20230729T210806&type=3&operation_type=1&fn_number=000123&fd_number=123&fm=123&tin=123&regNumber=0001233&sum=12300")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /tax-web-control/client/api/v1/ticket"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:   "success",
+			Routes: map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket.json")},
+		},
+		{
+			Name:                      "items not ready",
+			Routes:                    map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket_not_ready.json")},
+			WantErr:                   ErrReceiptItemsNotReady,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "404",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(404, "testdata/not_found.html")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "500",
+			Routes:                    map[string]testutils.Fixture{route: testutils.JSONFixture(500, "testdata/server_error.json")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: false,
+		},
+		{
+			Name:                      "malformed",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/maintenance.html")},
+			WantErr:                   receipts.ErrResponseDataMalformed,
+			WantShouldDecreaseRetries: true,
+		},
+	})
+}
@@ -1,6 +1,7 @@
 package kg_gns
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"receipt_qr_scanner/receipts"
@@ -24,9 +25,10 @@ var Domain = receipts.Domain{
 	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
 		return NewReceiptRef(refText)
 	},
-	NewClient: func() receipts.Client {
-		return &Client{}
+	NewClient: func(cfg receipts.ClientConfig) receipts.Client {
+		return &Client{cfg: cfg}
 	},
+	ParseReceiptSummary: parseReceiptSummary,
 }
 
 func NewReceiptRef(refText string) (ReceiptRef, error) {
@@ -98,6 +100,13 @@ func (r ReceiptRef) UniqueKey() string {
 	return r.Domain().Code + ":" + strings.Join(items, "&")
 }
 
+// FiscalKey — серийный номер ФМ, номер ФД и ФПД
+func (r ReceiptRef) FiscalKey() string {
+	d := r.data
+	return "kg:" + strconv.FormatInt(d.FiscalModuleSerialNumber, 10) + ":" +
+		strconv.FormatInt(d.FiscalDocumentNumber, 10) + ":" + strconv.FormatInt(d.FiscalDocumentSign, 10)
+}
+
 func (r ReceiptRef) CreatedAt() time.Time {
 	return r.data.CreatedAt
 }
@@ -106,6 +115,11 @@ func (r ReceiptRef) Sum() float64 {
 	return r.data.Sum
 }
 
+// IsRefund — возврат продажи или возврат покупки
+func (r ReceiptRef) IsRefund() bool {
+	return r.data.OperationType == 2 || r.data.OperationType == 4
+}
+
 func (r ReceiptRef) SearchKeyItems() []string {
 	return []string{
 		"_created_at:" + r.data.CreatedAt.Format("2006-01-02 15:04"),
@@ -172,3 +186,20 @@ func parseRefText(refText string) (*ReceiptRefData, error) {
 	}
 	return &data, err
 }
+
+func parseReceiptSummary(data []byte) (receipts.ReceiptSummary, error) {
+	var d struct {
+		Tin   receipts.JSONString `json:"tin"`
+		Items []struct {
+			GoodName string `json:"goodName"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return receipts.ReceiptSummary{}, merry.Wrap(err)
+	}
+	summary := receipts.ReceiptSummary{SellerID: string(d.Tin)}
+	for _, item := range d.Items {
+		summary.ItemNames = append(summary.ItemNames, item.GoodName)
+	}
+	return summary, nil
+}
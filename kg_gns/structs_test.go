@@ -0,0 +1,21 @@
+package kg_gns
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseReceiptSummary(t *testing.T) {
+	data, err := os.ReadFile("testdata/ticket.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := parseReceiptSummary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.SellerID != "123" || !slices.Equal(summary.ItemNames, []string{"Самса"}) {
+		t.Errorf("parseReceiptSummary() = %#v", summary)
+	}
+}
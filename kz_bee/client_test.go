@@ -0,0 +1,34 @@
+package kz_bee
+
+import (
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
+	"testing"
+)
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("https://ofd.beeline.kz/t/?i=123456789012&f=012345678901&s=3300.00&t=20241108T123456")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /t/"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:   "success",
+			Routes: map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/ticket.html")},
+		},
+		{
+			Name:                      "page without ИТОГО",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/ticket_not_found.html")},
+			WantErr:                   receipts.ErrResponseDataMalformed,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "404",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(404, "testdata/not_found.html")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+	})
+}
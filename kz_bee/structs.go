@@ -1,100 +1,28 @@
 package kz_bee
 
 import (
-	"fmt"
-	"net/url"
-	"receipt_qr_scanner/kz_ktc"
+	"receipt_qr_scanner/kz_ofd"
 	"receipt_qr_scanner/receipts"
-	"sort"
-	"strings"
-	"time"
-
-	"github.com/ansel1/merry"
 )
 
-var Domain = receipts.Domain{
-	Code:           "kz-bee",
-	CurrencySymbol: "₸",
-	FlagSymbol:     "🇰🇿",
+var operator = &kz_ofd.Operator{
+	Code: "kz-bee",
 	Provider: receipts.Provider{
 		Name:       "ОФД Beeline (КаР-ТеЛ)",
 		ShortLabel: "B",
 		Color:      "#eec100", //хорошо бы #ead639, но на нём белую букву почти не видно
 	},
-	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
-		return NewReceiptRef(refText)
-	},
-	NewClient: func() receipts.Client {
-		return &Client{}
-	},
-}
-
-func NewReceiptRef(refText string) (ReceiptRef, error) {
-	data, err := parseRefText(refText)
-	if err != nil {
-		return ReceiptRef{}, merry.Wrap(err)
-	}
-	ref := ReceiptRef{text: refText, data: *data}
-	return ref, nil
-}
-
-type ReceiptRef struct {
-	text string
-	data ReceiptRefData
-}
-
-// формат тот же, что и в kz_ktc
-type ReceiptRefData = kz_ktc.ReceiptRefData
-
-func (r ReceiptRef) String() string {
-	return fmt.Sprintf("Ref{%s:%s}", r.Domain().Code, r.text)
-}
-
-func (r ReceiptRef) Domain() receipts.Domain {
-	return Domain
-}
-
-func (r ReceiptRef) RefText() string {
-	return r.text
-}
-
-func (r ReceiptRef) UniqueKey() string {
-	query := r.text
-	i := strings.LastIndex(r.text, "?")
-	if i != -1 {
-		query = query[i+1:]
-	}
-	items := strings.Split(query, "&")
-	sort.Strings(items)
-	return r.Domain().Code + ":" + strings.Join(items, "&")
-}
-
-func (r ReceiptRef) CreatedAt() time.Time {
-	return r.data.CreatedAt
-}
-
-func (r ReceiptRef) Sum() float64 {
-	return r.data.Sum
-}
-
-func (r ReceiptRef) SearchKeyItems() []string {
-	return r.data.SearchKeyItems()
-}
-
-func parseRefText(refText string) (*ReceiptRefData, error) {
 	// https://ofd.beeline.kz/t/?i=123456789012&f=012345678901&s=12345.0&t=20241108T123456
-	u, err := url.Parse(refText)
-	if err != nil {
-		return nil, merry.Wrap(err)
-	}
+	Hosts:          []string{"ofd.beeline.kz"},
+	DefaultBaseURL: "https://ofd.beeline.kz",
+	// https://ofd.beeline.kz/t/?i=123456789012&f=012345678901&s=12345.0&t=20241108T123456
+	FetchURL:         "/t/?f={f}&i={i}&s={s}&t={t}",
+	ValidateResponse: kz_ofd.PageContains("ИТОГО"),
+	ExtractData:      kz_ofd.ExtractTicketPage,
+}
 
-	if u.Host != "ofd.beeline.kz" {
-		return nil, merry.Errorf("unexpected host: %s", u.Host)
-	}
+var Domain = operator.Domain()
 
-	var data ReceiptRefData
-	if err := data.FillFromQuery(u.Query()); err != nil {
-		return nil, merry.Wrap(err)
-	}
-	return &data, nil
+func NewReceiptRef(refText string) (kz_ofd.ReceiptRef, error) {
+	return operator.NewReceiptRef(refText)
 }
@@ -37,19 +37,19 @@ func TestParseReceiptRef(t *testing.T) {
 				return
 			}
 
-			if ref.data.FiscalID != tt.wantFiscalID {
-				t.Errorf("FiscalID = %v, want %v", ref.data.FiscalID, tt.wantFiscalID)
+			if ref.Data().FiscalID != tt.wantFiscalID {
+				t.Errorf("FiscalID = %v, want %v", ref.Data().FiscalID, tt.wantFiscalID)
 			}
-			if ref.data.KkmFnsId != tt.wantKkmFnsId {
-				t.Errorf("KkmFnsId = %v, want %v", ref.data.KkmFnsId, tt.wantKkmFnsId)
+			if ref.Data().KkmFnsId != tt.wantKkmFnsId {
+				t.Errorf("KkmFnsId = %v, want %v", ref.Data().KkmFnsId, tt.wantKkmFnsId)
 			}
-			if ref.data.Sum != tt.wantSum {
-				t.Errorf("Sum = %v, want %v", ref.data.Sum, tt.wantSum)
+			if ref.Data().Sum != tt.wantSum {
+				t.Errorf("Sum = %v, want %v", ref.Data().Sum, tt.wantSum)
 			}
 
 			wantTime, _ := time.Parse("2006-01-02 15:04:05", tt.wantDate)
-			if !ref.data.CreatedAt.Equal(wantTime) {
-				t.Errorf("CreatedAt = %v, want %v", ref.data.CreatedAt, wantTime)
+			if !ref.Data().CreatedAt.Equal(wantTime) {
+				t.Errorf("CreatedAt = %v, want %v", ref.Data().CreatedAt, wantTime)
 			}
 
 			// Проверка методов интерфейса
@@ -68,6 +68,9 @@ func TestParseReceiptRef(t *testing.T) {
 			if ref.UniqueKey() != tt.wantUniqKey {
 				t.Errorf("UniqueKey() = %v, want %v", ref.UniqueKey(), tt.wantUniqKey)
 			}
+			if wantFiscalKey := "kz:" + tt.wantKkmFnsId + ":" + tt.wantFiscalID; ref.FiscalKey() != wantFiscalKey {
+				t.Errorf("FiscalKey() = %v, want %v", ref.FiscalKey(), wantFiscalKey)
+			}
 			if !slices.Equal(ref.SearchKeyItems(), strings.Split(tt.wantSearchKey, "   ")) {
 				t.Errorf("SearchKeyItems() = %v, want %v", ref.SearchKeyItems(), strings.Split(tt.wantSearchKey, "   "))
 			}
@@ -1,17 +1,20 @@
 package kz_jus
 
 import (
+	"receipt_qr_scanner/kz_ofd"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
 	"testing"
 	"time"
 )
 
 func TestBuildAPIURL(t *testing.T) {
 	tests := []struct {
-		data    ReceiptRefData
+		data    kz_ofd.ReceiptRefData
 		wantURL string
 	}{
 		{
-			data: ReceiptRefData{
+			data: kz_ofd.ReceiptRefData{
 				FiscalID:  "123456789012",
 				KkmFnsId:  "910101234567",
 				CreatedAt: time.Date(2025, 12, 8, 12, 34, 0, 0, time.UTC),
@@ -19,7 +22,7 @@ func TestBuildAPIURL(t *testing.T) {
 			wantURL: "https://cabinet.kofd.kz/api/tickets?registrationNumber=910101234567&ticketDate=2025-12-08&ticketNumber=123456789012",
 		},
 		{
-			data: ReceiptRefData{
+			data: kz_ofd.ReceiptRefData{
 				FiscalID:  "023456789012",
 				KkmFnsId:  "010101234567",
 				CreatedAt: time.Date(2025, 12, 20, 12, 34, 6, 0, time.UTC),
@@ -30,10 +33,49 @@ func TestBuildAPIURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.wantURL, func(t *testing.T) {
-			gotURL := makeAPIURL(tt.data)
+			gotURL := operator.BuildFetchURL(operator.DefaultBaseURL, tt.data)
 			if gotURL != tt.wantURL {
-				t.Errorf("makeAPIURL() =\n  %v\nwant:\n  %v", gotURL, tt.wantURL)
+				t.Errorf("BuildFetchURL() =\n  %v\nwant:\n  %v", gotURL, tt.wantURL)
 			}
 		})
 	}
 }
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("http://consumer.kofd.kz?i=123456789012&f=910101234567&s=1230.00&t=20251208T123400")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /api/tickets"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:   "success",
+			Routes: map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket.json")},
+		},
+		{
+			Name:                      "ticket not found",
+			Routes:                    map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket_not_found.json")},
+			WantErr:                   testutils.ErrAny,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "kkm not found",
+			Routes:                    map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/kkm_not_found.json")},
+			WantErr:                   testutils.ErrAny,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "404",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(404, "testdata/not_found.html")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "malformed",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/maintenance.html")},
+			WantErr:                   receipts.ErrResponseDataMalformed,
+			WantShouldDecreaseRetries: true,
+		},
+	})
+}
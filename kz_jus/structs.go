@@ -1,100 +1,31 @@
 package kz_jus
 
 import (
-	"fmt"
-	"net/url"
-	"receipt_qr_scanner/kz_ktc"
+	"receipt_qr_scanner/kz_ofd"
 	"receipt_qr_scanner/receipts"
-	"sort"
-	"strings"
-	"time"
-
-	"github.com/ansel1/merry"
 )
 
-var Domain = receipts.Domain{
-	Code:           "kz-jus",
-	CurrencySymbol: "₸",
-	FlagSymbol:     "🇰🇿",
+var operator = &kz_ofd.Operator{
+	Code: "kz-jus",
 	Provider: receipts.Provider{
 		Name:       "ОФД Jusan Mobile",
 		ShortLabel: "J",
 		Color:      "#FF5100",
 	},
-	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
-		return NewReceiptRef(refText)
-	},
-	NewClient: func() receipts.Client {
-		return &Client{}
-	},
-}
-
-func NewReceiptRef(refText string) (ReceiptRef, error) {
-	data, err := parseRefText(refText)
-	if err != nil {
-		return ReceiptRef{}, merry.Wrap(err)
-	}
-	ref := ReceiptRef{text: refText, data: *data}
-	return ref, nil
-}
-
-type ReceiptRef struct {
-	text string
-	data ReceiptRefData
-}
-
-// формат тот же, то и в kz_ktc
-type ReceiptRefData = kz_ktc.ReceiptRefData
-
-func (r ReceiptRef) String() string {
-	return fmt.Sprintf("Ref{%s:%s}", r.Domain().Code, r.text)
-}
-
-func (r ReceiptRef) Domain() receipts.Domain {
-	return Domain
-}
-
-func (r ReceiptRef) RefText() string {
-	return r.text
-}
-
-func (r ReceiptRef) UniqueKey() string {
-	query := r.text
-	i := strings.LastIndex(r.text, "?")
-	if i != -1 {
-		query = query[i+1:]
-	}
-	items := strings.Split(query, "&")
-	sort.Strings(items)
-	return r.Domain().Code + ":" + strings.Join(items, "&")
-}
-
-func (r ReceiptRef) CreatedAt() time.Time {
-	return r.data.CreatedAt
-}
-
-func (r ReceiptRef) Sum() float64 {
-	return r.data.Sum
-}
-
-func (r ReceiptRef) SearchKeyItems() []string {
-	return r.data.SearchKeyItems()
-}
-
-func parseRefText(refText string) (*ReceiptRefData, error) {
 	// http://consumer.kofd.kz?i=123456789012&f=010101234567&s=1230.00&t=20251208T123456
-	u, err := url.Parse(refText)
-	if err != nil {
-		return nil, merry.Wrap(err)
-	}
+	Hosts:          []string{"consumer.kofd.kz"},
+	DefaultBaseURL: "https://cabinet.kofd.kz",
+	// https://cabinet.kofd.kz/api/tickets?registrationNumber={f}&ticketNumber={i}&ticketDate={date}
+	FetchURL: "/api/tickets?registrationNumber={f}&ticketDate={date}&ticketNumber={i}",
+	// если номер ККМ неправильный:
+	// {"error":{"code":5,"text":"Касса не найдена"}}
+	// если ККМ такая есть, но нет чека с таким фискальным признаком:
+	// {"data":{"found":0,"ticket":null,"ticketUrl":null},"error":null}
+	ValidateResponse: kz_ofd.JSONFound("data", "found"),
+}
 
-	if u.Host != "consumer.kofd.kz" {
-		return nil, merry.Errorf("unexpected host: %s", u.Host)
-	}
+var Domain = operator.Domain()
 
-	var data ReceiptRefData
-	if err := data.FillFromQuery(u.Query()); err != nil {
-		return nil, merry.Wrap(err)
-	}
-	return &data, nil
+func NewReceiptRef(refText string) (kz_ofd.ReceiptRef, error) {
+	return operator.NewReceiptRef(refText)
 }
@@ -1,16 +1,14 @@
 package kz_ktc
 
 import (
-	"crypto/tls"
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
-	"fmt"
 	"net/http"
-	"net/url"
+	"receipt_qr_scanner/kz_ofd"
 	"receipt_qr_scanner/receipts"
 	"receipt_qr_scanner/utils"
-	"strconv"
 	"time"
 
 	"github.com/ansel1/merry"
@@ -56,6 +54,7 @@ IXnjEeWrFvAZQhk=
 -----END CERTIFICATE-----`
 
 type Client struct {
+	cfg        receipts.ClientConfig
 	customCert *x509.Certificate
 	httpClient *http.Client
 }
@@ -80,29 +79,33 @@ func (c *Client) Init() error {
 	}
 	rootCAs.AddCert(customCert)
 
-	c.customCert = customCert
-	c.httpClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{RootCAs: rootCAs},
-		},
+	httpClient, err := utils.HTTPClientWithRootCAs(c.cfg.HTTPClientOr(utils.DefaultHTTPClient), rootCAs)
+	if err != nil {
+		return merry.Prependf(err, "kz_ktc: failed to add certificate")
 	}
+
+	c.customCert = customCert
+	c.httpClient = httpClient
 	return nil
 }
 
-func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
+func (c *Client) FetchReceipt(ctx context.Context, iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
 	res := receipts.FetchReceiptResult{ShouldDecreaseRetries: false, Data: nil}
 
-	ref, err := receipts.CastReceiptRefTo[ReceiptRef](iRef, Domain.Code)
+	ref, err := receipts.CastReceiptRefTo[kz_ofd.ReceiptRef](iRef, Domain.Code)
 	if err != nil {
 		return res, err
 	}
+	if ref.Domain().Code != Domain.Code {
+		return res, merry.Errorf("%s: unexpected receipt ref %s", Domain.Code, iRef)
+	}
 
 	checkCertExpiration(c.customCert)
 
 	// https://consumer.oofd.kz/api/consumer-proxy/api/tickets/get-by-url?t={t}&i={i}&f={f}&s={s}
-	apiURL := makeAPIURL(ref.data)
+	apiURL := operator.BuildFetchURL(c.cfg.BaseURLOr(operator.DefaultBaseURL), ref.Data())
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return res, merry.Wrap(err)
 	}
@@ -139,21 +142,6 @@ func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error
 	return res, nil
 }
 
-func makeAPIURL(data ReceiptRefData) string {
-	dateStr := data.CreatedAt.Format("20060102T150405")
-
-	// 2 знака после запятой
-	sumStr := strconv.FormatFloat(data.Sum, 'f', 2, 64)
-
-	params := url.Values{}
-	params.Set("t", dateStr)
-	params.Set("i", data.FiscalID)
-	params.Set("f", data.KkmFnsId)
-	params.Set("s", sumStr)
-
-	return fmt.Sprintf("https://consumer.oofd.kz/api/consumer-proxy/api/tickets/get-by-url?%s", params.Encode())
-}
-
 // Проверка срока действия сертификата
 func checkCertExpiration(cert *x509.Certificate) {
 	now := time.Now()
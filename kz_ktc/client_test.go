@@ -1,6 +1,8 @@
 package kz_ktc
 
 import (
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
 	"testing"
 	"time"
 )
@@ -23,10 +25,37 @@ func TestBuildAPIURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.wantURL, func(t *testing.T) {
-			gotURL := makeAPIURL(tt.data)
+			gotURL := operator.BuildFetchURL(operator.DefaultBaseURL, tt.data)
 			if gotURL != tt.wantURL {
-				t.Errorf("buildAPIURL() =\n  %v\nwant:\n  %v", gotURL, tt.wantURL)
+				t.Errorf("BuildFetchURL() =\n  %v\nwant:\n  %v", gotURL, tt.wantURL)
 			}
 		})
 	}
 }
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("http://consumer.oofd.kz?i=0123456789&f=010101012345&s=1600.12&t=20240309T123456")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /api/consumer-proxy/api/tickets/get-by-url"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:   "success",
+			Routes: map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket.json")},
+		},
+		{
+			Name:                      "not found",
+			Routes:                    map[string]testutils.Fixture{route: testutils.JSONFixture(404, "testdata/ticket_not_found.json")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "malformed",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/maintenance.html")},
+			WantErr:                   receipts.ErrResponseDataMalformed,
+			WantShouldDecreaseRetries: true,
+		},
+	})
+}
@@ -0,0 +1,126 @@
+package kz_ofd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/utils"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+// Client — стандартный клиент ОФД: GET Operator.FetchURL и проверка ответа Operator.ValidateResponse
+type Client struct {
+	op  *Operator
+	cfg receipts.ClientConfig
+}
+
+func (c *Client) Init() error {
+	return nil
+}
+
+func (c *Client) FetchReceipt(ctx context.Context, iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
+	res := receipts.FetchReceiptResult{ShouldDecreaseRetries: false, Data: nil}
+
+	ref, err := receipts.CastReceiptRefTo[ReceiptRef](iRef, c.op.Code)
+	if err != nil {
+		return res, err
+	}
+	if ref.op != c.op {
+		return res, merry.Errorf("%s: unexpected receipt ref %s", c.op.Code, iRef)
+	}
+
+	fetchURL := c.op.BuildFetchURL(c.cfg.BaseURLOr(c.op.DefaultBaseURL), ref.data)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fetchURL, nil)
+	if err != nil {
+		return res, merry.Wrap(err)
+	}
+
+	resp, buf, err := utils.GetHTTPBody(c.cfg.HTTPClientOr(utils.DefaultHTTPClient), req)
+	if err != nil {
+		return res, err
+	}
+
+	logEvent := log.Debug().
+		Int("code", resp.StatusCode).Str("status", resp.Status).
+		Str("url", fetchURL)
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("<")) {
+		logEvent = logEvent.Int("html_length", len(buf))
+	} else {
+		logEvent = logEvent.Str("data", string(buf))
+	}
+	logEvent.Msgf("%s: response", c.op.Code)
+
+	if resp.Status != "200 OK" {
+		res.ShouldDecreaseRetries = true
+		return res, receipts.ErrUnexpectedHttpStatus.Here().Append(resp.Status).Append(string(buf))
+	}
+
+	if err := c.op.ValidateResponse(buf); err != nil {
+		res.ShouldDecreaseRetries = true
+		return res, merry.Prepend(err, c.op.Code)
+	}
+
+	res.Data = buf
+	return res, nil
+}
+
+// PageContains — ответ это HTML-страница чека, на которой обязательно есть marker
+func PageContains(marker string) func(buf []byte) error {
+	return func(buf []byte) error {
+		if !bytes.Contains(buf, []byte(marker)) {
+			return receipts.ErrResponseDataMalformed.Here().Append("receipt appears to be missing from the page")
+		}
+		return nil
+	}
+}
+
+// JSONFound — ответ это JSON, в котором по пути path лежит число найденных чеков (должно быть 1),
+// например {"data":{"found":1,...}} для JSONFound("data", "found")
+func JSONFound(path ...string) func(buf []byte) error {
+	return func(buf []byte) error {
+		value, err := jsonValueAt(buf, path)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if found, ok := value.(float64); !ok || found != 1 {
+			return merry.Errorf("receipt not found (%s=%v)", strings.Join(path, "."), value)
+		}
+		return nil
+	}
+}
+
+// JSONNotEmpty — ответ это JSON, в котором по пути path что-то есть (не null)
+func JSONNotEmpty(path ...string) func(buf []byte) error {
+	return func(buf []byte) error {
+		value, err := jsonValueAt(buf, path)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if value == nil {
+			return merry.Errorf("receipt not found (%s is empty)", strings.Join(path, "."))
+		}
+		return nil
+	}
+}
+
+// jsonValueAt — значение по пути path (nil, если его нет), ошибка — только если это вообще не JSON
+func jsonValueAt(buf []byte, path []string) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(buf, &value); err != nil {
+		return nil, receipts.ErrResponseDataMalformed.Here().Append(string(buf))
+	}
+	for _, key := range path {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		value = obj[key]
+	}
+	return value, nil
+}
@@ -0,0 +1,181 @@
+package kz_ofd
+
+import (
+	"bytes"
+	"encoding/json"
+	"receipt_qr_scanner/utils"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"golang.org/x/net/html"
+)
+
+// TicketPage — данные со страницы чека ОФД (kz-bee, kz-ttc отдают только HTML),
+// сохраняются рядом со страницей и идут в поиск вместо неё
+type TicketPage struct {
+	Seller          string       `json:"seller,omitempty"`
+	SellerBIN       string       `json:"sellerBin,omitempty"` //ИИН/БИН
+	Address         string       `json:"address,omitempty"`
+	KkmSerialNumber string       `json:"kkmSerialNumber,omitempty"` //ЗНМ
+	KkmFnsId        string       `json:"kkmFnsId,omitempty"`        //РНМ
+	FiscalID        string       `json:"fiscalId,omitempty"`        //ФП
+	ReceiptNumber   string       `json:"receiptNumber,omitempty"`
+	Items           []TicketItem `json:"items"`
+	Total           float64      `json:"total,omitempty"`
+	// что на странице есть, но не разобралось (строки товаров, итог)
+	ParseErrors []string `json:"parseErrors,omitempty"`
+}
+
+type TicketItem struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Price    float64 `json:"price"`
+	Sum      float64 `json:"sum"`
+}
+
+var (
+	ticketBINRe           = regexp.MustCompile(`ИИН/БИН\s*:\s*(\d+)`)
+	ticketKkmFnsIdRe      = regexp.MustCompile(`РНМ\s*:\s*(\S+)`)
+	ticketKkmSerialRe     = regexp.MustCompile(`ЗНМ\s*:\s*(\S+)`)
+	ticketAddressRe       = regexp.MustCompile(`Адрес\s*:\s*(.+)`)
+	ticketReceiptNumberRe = regexp.MustCompile(`Номер чека\s*:\s*(\S+)`)
+	// "3 960.00 x 0.5 кг = 1 980.00"
+	ticketItemAmountsRe = regexp.MustCompile(`([\d\s]+[.,]?\d*)\s*x\s*([\d\s]+[.,]?\d*)\s*\S+\s*=\s*([\d\s]+[.,]?\d*)`)
+)
+
+// ExtractTicketPage — Operator.ExtractData для страниц чеков вида ready_ticket
+// (шапка .ticket_header, товары .ready_ticket__items_list, итог .ticket_total), результат — JSON TicketPage
+func ExtractTicketPage(buf []byte) ([]byte, error) {
+	page, err := ParseTicketPage(buf)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	res, err := json.Marshal(page)
+	return res, merry.Wrap(err)
+}
+
+func ParseTicketPage(buf []byte) (TicketPage, error) {
+	page := TicketPage{Items: []TicketItem{}}
+
+	doc, err := html.Parse(bytes.NewReader(buf))
+	if err != nil {
+		return page, merry.Wrap(err)
+	}
+	ticket := utils.FindHTMLNode(doc, utils.HTMLHasClass("ready_ticket"))
+	if ticket == nil {
+		return page, merry.New("ticket not found on the page")
+	}
+
+	// скрытые элементы с фискальными данными (вне самого чека)
+	if node := utils.FindHTMLNode(doc, utils.HTMLHasClass("ticket_fiscal_mark")); node != nil {
+		page.FiscalID = utils.HTMLNodeText(node)
+	}
+	if node := utils.FindHTMLNode(doc, utils.HTMLHasClass("ticket_state_number")); node != nil {
+		page.KkmFnsId = utils.HTMLNodeText(node)
+	}
+
+	// шапка: первый div — продавец, дальше "Подпись: значение" (значение в span или прямо в тексте)
+	if header := utils.FindHTMLNode(ticket, utils.HTMLHasClass("ticket_header")); header != nil {
+		for i, div := range utils.HTMLChildElements(header, "div") {
+			text := utils.HTMLNodeText(div)
+			if i == 0 {
+				page.Seller = text
+				continue
+			}
+			// строка — одно поле: первое подошедшее по порядку (адрес может содержать и "ЗНМ:")
+			for _, f := range []struct {
+				re    *regexp.Regexp
+				field *string
+			}{
+				{ticketAddressRe, &page.Address},
+				{ticketBINRe, &page.SellerBIN},
+				{ticketKkmFnsIdRe, &page.KkmFnsId},
+				{ticketKkmSerialRe, &page.KkmSerialNumber},
+				{ticketReceiptNumberRe, &page.ReceiptNumber},
+			} {
+				if m := f.re.FindStringSubmatch(text); m != nil {
+					*f.field = m[1]
+					break
+				}
+			}
+		}
+	}
+
+	if list := utils.FindHTMLNode(ticket, utils.HTMLHasClass("ready_ticket__items_list")); list != nil {
+		for i, li := range utils.HTMLChildElements(list, "li") {
+			item, ok, err := parseTicketItem(li)
+			if err != nil {
+				page.ParseErrors = append(page.ParseErrors, "item "+strconv.Itoa(i+1)+": "+err.Error())
+			} else if ok {
+				page.Items = append(page.Items, item)
+			}
+		}
+	}
+
+	if node := utils.FindHTMLNode(ticket, utils.HTMLHasClass("ticket_total")); node != nil {
+		if page.Total, err = parseTicketAmount(utils.HTMLNodeText(node)); err != nil {
+			page.ParseErrors = append(page.ParseErrors, "total: "+err.Error())
+		}
+	}
+
+	if page.Seller == "" && len(page.Items) == 0 && page.Total == 0 {
+		return page, merry.New("ticket is empty")
+	}
+	return page, nil
+}
+
+// parseTicketItem разбирает строку товара, ok=false — это не товар (скидка, коррекция округления)
+func parseTicketItem(li *html.Node) (TicketItem, bool, error) {
+	// название: в kz-ttc — в span.wb-all, в kz-bee — текст перед первым вложенным элементом
+	var name string
+	if span := utils.FindHTMLNode(li, utils.HTMLHasClass("wb-all")); span != nil {
+		name = utils.HTMLNodeText(span)
+	} else {
+		for c := li.FirstChild; c != nil && c.Type == html.TextNode; c = c.NextSibling {
+			name += c.Data
+		}
+		name = utils.CollapseSpaces(name)
+	}
+
+	itemDiv := utils.FindHTMLNode(li, utils.HTMLHasClass("ready_ticket__item"))
+	if itemDiv == nil {
+		if name == "" {
+			return TicketItem{}, false, nil
+		}
+		return TicketItem{}, false, merry.Errorf("no amounts for %q", name)
+	}
+	// в <b> — GTIN, он не должен попасть в числа
+	text := utils.HTMLNodeTextSkipping(itemDiv, func(n *html.Node) bool { return n.Data == "b" })
+	if strings.Contains(strings.ToLower(text), "скидка") || strings.Contains(text, "Коррекция округления") {
+		return TicketItem{}, false, nil
+	}
+
+	m := ticketItemAmountsRe.FindStringSubmatch(text)
+	if m == nil {
+		if name == "" {
+			return TicketItem{}, false, nil
+		}
+		return TicketItem{}, false, merry.Errorf("unexpected amounts format %q", text)
+	}
+	item := TicketItem{Name: name}
+	var errPrice, errQuantity, errSum error
+	item.Price, errPrice = parseTicketAmount(m[1])
+	item.Quantity, errQuantity = parseTicketAmount(m[2])
+	item.Sum, errSum = parseTicketAmount(m[3])
+	for _, err := range []error{errPrice, errQuantity, errSum} {
+		if err != nil {
+			return TicketItem{}, false, err
+		}
+	}
+	return item, true, nil
+}
+
+// "4 920,50" или "3180.00" -> 4920.5
+func parseTicketAmount(str string) (float64, error) {
+	str = strings.Join(strings.Fields(str), "")
+	str = strings.ReplaceAll(str, ",", ".")
+	value, err := strconv.ParseFloat(str, 64)
+	return value, merry.Wrap(err)
+}
@@ -0,0 +1,122 @@
+package kz_ofd
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseTicketPage(t *testing.T) {
+	for _, tt := range []struct {
+		fname string
+		want  TicketPage
+	}{
+		{
+			fname: "kz_bee_ticket.html",
+			want: TicketPage{
+				Seller:          `Товарищество с ограниченной ответственностью "Asia Foods"`,
+				SellerBIN:       "210987654321",
+				Address:         "Республика Казахстан, г. Астана, р-н Есильский, ул. Кенесары, д. 10",
+				KkmSerialNumber: "WPKS0000054321",
+				KkmFnsId:        "010104567890",
+				FiscalID:        "887654321098",
+				Items: []TicketItem{
+					{Name: "ЛАПША РАМЕН ОСТРАЯ 120Г", Quantity: 2, Price: 1250, Sum: 2500},
+					{Name: "МОЛОКО БАНАНОВОЕ 200МЛ", Quantity: 3, Price: 450, Sum: 1350},
+				},
+				Total: 3700,
+			},
+		},
+		{
+			fname: "kz_ttc_ticket.html",
+			want: TicketPage{
+				Seller:          `ТОВАРИЩЕСТВО С ОГРАНИЧЕННОЙ ОТВЕТСТВЕННОСТЬЮ "AIMEDI GROUP"`,
+				SellerBIN:       "220540017611",
+				Address:         "Республика Казахстан, г. Алматы, р-н Медеуский, достык, д. 49, оф.",
+				KkmSerialNumber: "SWK00123456",
+				KkmFnsId:        "010101234567",
+				FiscalID:        "123456789012",
+				ReceiptNumber:   "12345",
+				Items: []TicketItem{
+					{Name: `1075 MONGE MINI PUP&JUN LAMB\RICE Сухой корм д/щенков и юниоров мелких собак баранина/рис (7,5кг)`,
+						Quantity: 0.5, Price: 3960, Sum: 1980},
+					{Name: `6" 2PC Лакомства для собак Кости жильные KZ091`, Quantity: 1, Price: 1200, Sum: 1200},
+				},
+				Total: 3180,
+			},
+		},
+	} {
+		buf, err := os.ReadFile("testdata/" + tt.fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := ParseTicketPage(buf)
+		if err != nil {
+			t.Errorf("%s: %v", tt.fname, err)
+			continue
+		}
+		if !reflect.DeepEqual(page, tt.want) {
+			t.Errorf("%s:\n  got  %+v\n  want %+v", tt.fname, page, tt.want)
+		}
+	}
+}
+
+func TestParseTicketPageHeader(t *testing.T) {
+	page, err := ParseTicketPage([]byte(`<div class="ready_ticket"><div class="ticket_header">
+		<div>ТОО "Магазин"</div>
+		<div>Мекен-жайы / Адрес: г. Алматы, мкр. ЗНМ: 7, д. 1</div>
+		<div>МЗН / ЗНМ: SWK00123456</div>
+	</div></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Address != "г. Алматы, мкр. ЗНМ: 7, д. 1" || page.KkmSerialNumber != "SWK00123456" {
+		t.Errorf("address %q, kkm serial number %q", page.Address, page.KkmSerialNumber)
+	}
+}
+
+func TestParseTicketPageItems(t *testing.T) {
+	page, err := ParseTicketPage([]byte(`<div class="ready_ticket"><ol class="ready_ticket__items_list">
+		<li><span class="wb-all"> Дорогие килограммы </span>
+			<div class="ready_ticket__item"><b>83465</b> 100 000.00 x 2.5 кг = 250 000.00 </div></li>
+		<li><div class="ready_ticket__item">Коррекция округления (Жеңілдік /скидка) 0.27</div></li>
+		<li><span class="wb-all">Непонятный</span><div class="ready_ticket__item">500 сом</div></li>
+		<li><span class="wb-all">Товар</span><div class="ready_ticket__item">200,50 x 2 шт = 401,00</div></li>
+	</ol><span class="ticket_total">в долг</span></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantItems := []TicketItem{
+		{Name: "Дорогие килограммы", Quantity: 2.5, Price: 100000, Sum: 250000},
+		{Name: "Товар", Quantity: 2, Price: 200.5, Sum: 401},
+	}
+	if !reflect.DeepEqual(page.Items, wantItems) {
+		t.Errorf("items = %+v, want %+v", page.Items, wantItems)
+	}
+	if len(page.ParseErrors) != 2 {
+		t.Errorf("parse errors = %q, want item 3 and total", page.ParseErrors)
+	}
+
+	for _, html := range []string{`<p>Чек не найден</p>`, `<div class="ready_ticket"><div class="ticket_body"></div></div>`} {
+		if _, err := ParseTicketPage([]byte(html)); err == nil {
+			t.Errorf("ParseTicketPage(%s) expected error", html)
+		}
+	}
+}
+
+func TestExtractTicketPage(t *testing.T) {
+	buf, err := ExtractTicketPage([]byte(`<div class="ready_ticket"><div class="ticket_header"><div><span>Minimal Receipt</span></div></div>
+		<span class="ticket_total">100.50</span></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page map[string]interface{}
+	if err := json.Unmarshal(buf, &page); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"seller": "Minimal Receipt", "items": []interface{}{}, "total": 100.5}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("ExtractTicketPage() = %s", buf)
+	}
+}
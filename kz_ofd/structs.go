@@ -0,0 +1,191 @@
+package kz_ofd
+
+import (
+	"fmt"
+	"net/url"
+	"receipt_qr_scanner/receipts"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Operator — ОФД Казахстана. Ссылки в QR-кодах у всех одинаковые (?i=ФП&f=РНМ&s=сумма&t=время)
+// и отличаются только хостом, а чек обычно скачивается одним GET-запросом,
+// так что новый ОФД — это такое описание (плюс фикстуры для тестов клиента).
+type Operator struct {
+	Code     string
+	Provider receipts.Provider
+	// хосты ссылок из QR-кодов, "*.example.kz" — любой поддомен example.kz
+	Hosts          []string
+	DefaultBaseURL string
+	// путь и query запроса чека относительно базового URL, с подстановками:
+	// {i} — ФП, {f} — РНМ, {s} — сумма (2 знака после запятой),
+	// {t} — время (20060102T150405), {date} — дата (2006-01-02)
+	FetchURL string
+	// проверка ответа 200 OK: чек ли это, а не ошибка или заглушка (см. PageContains, JSONFound, JSONNotEmpty)
+	ValidateResponse func(buf []byte) error
+	// свой клиент вместо стандартного (если одного GET-запроса недостаточно), FetchURL и ValidateResponse тогда не нужны
+	NewClient func(cfg receipts.ClientConfig) receipts.Client
+	// разбор ответа, если это HTML-страница (см. ExtractTicketPage), nil — ответ и так JSON
+	ExtractData func(buf []byte) ([]byte, error)
+}
+
+func (op *Operator) Domain() receipts.Domain {
+	newClient := op.NewClient
+	if newClient == nil {
+		newClient = func(cfg receipts.ClientConfig) receipts.Client {
+			return &Client{op: op, cfg: cfg}
+		}
+	}
+	return receipts.Domain{
+		Code:           op.Code,
+		CurrencySymbol: "₸",
+		FlagSymbol:     "🇰🇿",
+		Provider:       op.Provider,
+		ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
+			return op.NewReceiptRef(refText)
+		},
+		NewClient:          newClient,
+		ExtractReceiptData: op.ExtractData,
+	}
+}
+
+func (op *Operator) NewReceiptRef(refText string) (ReceiptRef, error) {
+	// http://consumer.oofd.kz?i=123456789&f=010101012345&s=1600.00&t=20240309T123456
+	u, err := url.Parse(refText)
+	if err != nil {
+		return ReceiptRef{}, merry.Wrap(err)
+	}
+
+	if !op.matchesHost(u.Host) {
+		return ReceiptRef{}, merry.Errorf("unexpected host: %s", u.Host)
+	}
+
+	var data ReceiptRefData
+	if err := data.FillFromQuery(u.Query()); err != nil {
+		return ReceiptRef{}, merry.Wrap(err)
+	}
+	return ReceiptRef{op: op, text: refText, data: data}, nil
+}
+
+func (op *Operator) matchesHost(host string) bool {
+	for _, pattern := range op.Hosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildFetchURL — URL запроса чека (FetchURL с подставленными данными)
+func (op *Operator) BuildFetchURL(baseURL string, data ReceiptRefData) string {
+	return baseURL + strings.NewReplacer(
+		"{i}", url.QueryEscape(data.FiscalID),
+		"{f}", url.QueryEscape(data.KkmFnsId),
+		"{s}", strconv.FormatFloat(data.Sum, 'f', 2, 64),
+		"{t}", data.CreatedAt.Format("20060102T150405"),
+		"{date}", data.CreatedAt.Format("2006-01-02"),
+	).Replace(op.FetchURL)
+}
+
+type ReceiptRef struct {
+	op   *Operator
+	text string
+	data ReceiptRefData
+}
+
+type ReceiptRefData struct {
+	// ФП, фискальный признак ККМ (возможно нужно сохранять нули в начале).
+	// Поле так называется в ответе kz-ktc
+	FiscalID string
+	// Код ККМ, РНМ, регистрационный номер ККМ (нужно сохранять нули в начале).
+	// Поле так называется в ответе kz-ktc
+	KkmFnsId  string
+	Sum       float64
+	CreatedAt time.Time
+}
+
+func (d ReceiptRefData) SearchKeyItems() []string {
+	return []string{
+		"_created_at:" + d.CreatedAt.Format("2006-01-02 15:04"),
+		"_fiscal_id:" + d.FiscalID,
+		"_kkm_fns_id:" + d.KkmFnsId,
+		"_sum:" + strconv.FormatFloat(d.Sum, 'f', 2, 64),
+	}
+}
+
+// FiscalKey — чек однозначно определяется кассой и фискальным признаком, у всех ОФД Казахстана они одни
+func (d ReceiptRefData) FiscalKey() string {
+	return "kz:" + d.KkmFnsId + ":" + d.FiscalID
+}
+
+func (d *ReceiptRefData) FillFromQuery(query url.Values) error {
+	var err error
+	d.FiscalID, err = receipts.ReadString(query, "i")
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	d.KkmFnsId, err = receipts.ReadString(query, "f")
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	d.Sum, err = receipts.ReadFloat64(query, "s")
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	d.CreatedAt, err = receipts.ReadTime(query, "t")
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return nil
+}
+
+func (r ReceiptRef) Data() ReceiptRefData {
+	return r.data
+}
+
+func (r ReceiptRef) String() string {
+	return fmt.Sprintf("Ref{%s:%s}", r.op.Code, r.text)
+}
+
+func (r ReceiptRef) Domain() receipts.Domain {
+	return r.op.Domain()
+}
+
+func (r ReceiptRef) RefText() string {
+	return r.text
+}
+
+func (r ReceiptRef) UniqueKey() string {
+	query := r.text
+	i := strings.LastIndex(r.text, "?")
+	if i != -1 {
+		query = query[i+1:]
+	}
+	items := strings.Split(query, "&")
+	sort.Strings(items)
+	return r.op.Code + ":" + strings.Join(items, "&")
+}
+
+func (r ReceiptRef) FiscalKey() string {
+	return r.data.FiscalKey()
+}
+
+func (r ReceiptRef) CreatedAt() time.Time {
+	return r.data.CreatedAt
+}
+
+func (r ReceiptRef) Sum() float64 {
+	return r.data.Sum
+}
+
+func (r ReceiptRef) SearchKeyItems() []string {
+	return r.data.SearchKeyItems()
+}
@@ -0,0 +1,81 @@
+package kz_ofd
+
+import (
+	"receipt_qr_scanner/receipts"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+var testOperator = &Operator{
+	Code:             "kz-test",
+	Hosts:            []string{"consumer.test.kz", "*.ofd-test.kz"},
+	DefaultBaseURL:   "https://api.test.kz",
+	FetchURL:         "/ticket?rnm={f}&fp={i}&sum={s}&time={t}&date={date}",
+	ValidateResponse: JSONFound("found"),
+}
+
+func TestOperatorNewReceiptRef(t *testing.T) {
+	for refText, wantOk := range map[string]bool{
+		"https://consumer.test.kz?i=123&f=010101234567&s=10.00&t=20260101T120000":      true,
+		"https://a.ofd-test.kz/t/?i=123&f=010101234567&s=10.00&t=20260101T120000":      true,
+		"https://ofd-test.kz.evil.com/?i=123&f=010101234567&s=10.00&t=20260101T120000": false,
+		"https://consumer.oofd.kz?i=123&f=010101234567&s=10.00&t=20260101T120000":      false,
+		"https://consumer.test.kz?i=123&f=010101234567&t=20260101T120000":              false,
+	} {
+		ref, err := testOperator.NewReceiptRef(refText)
+		if (err == nil) != wantOk {
+			t.Errorf("NewReceiptRef(%s) error = %v, want ok=%v", refText, err, wantOk)
+			continue
+		}
+		if wantOk && ref.Domain().Code != "kz-test" {
+			t.Errorf("Domain().Code = %s, want kz-test", ref.Domain().Code)
+		}
+	}
+}
+
+func TestOperatorBuildFetchURL(t *testing.T) {
+	data := ReceiptRefData{
+		FiscalID:  "0123456789",
+		KkmFnsId:  "010101012345",
+		Sum:       1600.1,
+		CreatedAt: time.Date(2024, 3, 9, 12, 34, 56, 0, time.UTC),
+	}
+	want := "https://api.test.kz/ticket?rnm=010101012345&fp=0123456789&sum=1600.10&time=20240309T123456&date=2024-03-09"
+	if got := testOperator.BuildFetchURL(testOperator.DefaultBaseURL, data); got != want {
+		t.Errorf("BuildFetchURL() =\n  %v\nwant:\n  %v", got, want)
+	}
+}
+
+func TestResponseValidators(t *testing.T) {
+	malformed := receipts.ErrResponseDataMalformed
+	tests := []struct {
+		name     string
+		validate func([]byte) error
+		buf      string
+		wantErr  error //nil — ок, merry.New("") — любая ошибка кроме malformed
+	}{
+		{"page ok", PageContains("ИТОГО"), "<html>ИТОГО: 100</html>", nil},
+		{"page without marker", PageContains("ИТОГО"), "<html>Чек не найден</html>", malformed},
+		{"found", JSONFound("data", "found"), `{"data":{"found":1}}`, nil},
+		{"not found", JSONFound("data", "found"), `{"data":{"found":0,"ticket":null}}`, merry.New("")},
+		{"found missing", JSONFound("data", "found"), `{"error":{"code":5}}`, merry.New("")},
+		{"found in HTML", JSONFound("found"), `<html>maintenance</html>`, malformed},
+		{"not empty", JSONNotEmpty("data", "ticket"), `{"data":{"ticket":{}}}`, nil},
+		{"empty", JSONNotEmpty("data", "ticket"), `{"data":{"ticket":null}}`, merry.New("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validate([]byte(tt.buf))
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("error = %v, want nil", err)
+			case tt.wantErr == malformed && !merry.Is(err, malformed):
+				t.Errorf("error = %v, want malformed", err)
+			case tt.wantErr != nil && tt.wantErr != malformed && (err == nil || merry.Is(err, malformed)):
+				t.Errorf("error = %v, want not found", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package kz_wfd
+
+import (
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
+	"testing"
+)
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("https://consumer.wofd.kz?i=123456789012&f=010101234567&s=12345.00&t=20260101T120000")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /api/tickets"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:   "success",
+			Routes: map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket.json")},
+		},
+		{
+			Name:                      "ticket not found",
+			Routes:                    map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket_not_found.json")},
+			WantErr:                   testutils.ErrAny,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "404",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(404, "testdata/not_found.html")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "malformed",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/maintenance.html")},
+			WantErr:                   receipts.ErrResponseDataMalformed,
+			WantShouldDecreaseRetries: true,
+		},
+	})
+}
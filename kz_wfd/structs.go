@@ -1,100 +1,28 @@
 package kz_wfd
 
 import (
-	"fmt"
-	"net/url"
-	"receipt_qr_scanner/kz_ktc"
+	"receipt_qr_scanner/kz_ofd"
 	"receipt_qr_scanner/receipts"
-	"sort"
-	"strings"
-	"time"
-
-	"github.com/ansel1/merry"
 )
 
-var Domain = receipts.Domain{
-	Code:           "kz-wfd",
-	CurrencySymbol: "₸",
-	FlagSymbol:     "🇰🇿",
+var operator = &kz_ofd.Operator{
+	Code: "kz-wfd",
 	Provider: receipts.Provider{
 		Name:       "ОФД WOFD (Smartcontract)",
 		ShortLabel: "W",
 		Color:      "#006eee",
 	},
-	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
-		return NewReceiptRef(refText)
-	},
-	NewClient: func() receipts.Client {
-		return &Client{}
-	},
-}
-
-func NewReceiptRef(refText string) (ReceiptRef, error) {
-	data, err := parseRefText(refText)
-	if err != nil {
-		return ReceiptRef{}, merry.Wrap(err)
-	}
-	ref := ReceiptRef{text: refText, data: *data}
-	return ref, nil
-}
-
-type ReceiptRef struct {
-	text string
-	data ReceiptRefData
-}
-
-// формат тот же, что и в kz_ktc
-type ReceiptRefData = kz_ktc.ReceiptRefData
-
-func (r ReceiptRef) String() string {
-	return fmt.Sprintf("Ref{%s:%s}", r.Domain().Code, r.text)
-}
-
-func (r ReceiptRef) Domain() receipts.Domain {
-	return Domain
-}
-
-func (r ReceiptRef) RefText() string {
-	return r.text
-}
-
-func (r ReceiptRef) UniqueKey() string {
-	query := r.text
-	i := strings.LastIndex(r.text, "?")
-	if i != -1 {
-		query = query[i+1:]
-	}
-	items := strings.Split(query, "&")
-	sort.Strings(items)
-	return r.Domain().Code + ":" + strings.Join(items, "&")
-}
-
-func (r ReceiptRef) CreatedAt() time.Time {
-	return r.data.CreatedAt
-}
-
-func (r ReceiptRef) Sum() float64 {
-	return r.data.Sum
-}
-
-func (r ReceiptRef) SearchKeyItems() []string {
-	return r.data.SearchKeyItems()
-}
-
-func parseRefText(refText string) (*ReceiptRefData, error) {
 	// https://consumer.wofd.kz?i=123456789012&f=010101234567&s=12345.00&t=20260101T120000
-	u, err := url.Parse(refText)
-	if err != nil {
-		return nil, merry.Wrap(err)
-	}
+	Hosts:          []string{"consumer.wofd.kz"},
+	DefaultBaseURL: "https://cabinet.wofd.kz",
+	// https://cabinet.wofd.kz/api/tickets?registrationNumber={012345}&ticketNumber={98765}&ticketDate={YYYY-MM-DD}
+	FetchURL: "/api/tickets?registrationNumber={f}&ticketDate={date}&ticketNumber={i}",
+	// формат ответа: {"found":1,"ticket":[...]}
+	ValidateResponse: kz_ofd.JSONFound("found"),
+}
 
-	if u.Host != "consumer.wofd.kz" {
-		return nil, merry.Errorf("unexpected host: %s", u.Host)
-	}
+var Domain = operator.Domain()
 
-	var data ReceiptRefData
-	if err := data.FillFromQuery(u.Query()); err != nil {
-		return nil, merry.Wrap(err)
-	}
-	return &data, nil
+func NewReceiptRef(refText string) (kz_ofd.ReceiptRef, error) {
+	return operator.NewReceiptRef(refText)
 }
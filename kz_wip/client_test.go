@@ -1,17 +1,20 @@
 package kz_wip
 
 import (
+	"receipt_qr_scanner/kz_ofd"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
 	"testing"
 	"time"
 )
 
 func TestBuildAPIURL(t *testing.T) {
 	tests := []struct {
-		data    ReceiptRefData
+		data    kz_ofd.ReceiptRefData
 		wantURL string
 	}{
 		{
-			data: ReceiptRefData{
+			data: kz_ofd.ReceiptRefData{
 				FiscalID:  "5612340017",
 				KkmFnsId:  "600407890704",
 				Sum:       2750.00,
@@ -20,7 +23,7 @@ func TestBuildAPIURL(t *testing.T) {
 			wantURL: "https://api.kassa.wipon.kz/api/v1/consumer?f=600407890704&i=5612340017&s=2750.00&t=20260310T093045",
 		},
 		{
-			data: ReceiptRefData{
+			data: kz_ofd.ReceiptRefData{
 				FiscalID:  "2987650017",
 				KkmFnsId:  "010105432842",
 				Sum:       8430.00,
@@ -32,10 +35,43 @@ func TestBuildAPIURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.wantURL, func(t *testing.T) {
-			gotURL := makeAPIURL(tt.data)
+			gotURL := operator.BuildFetchURL(operator.DefaultBaseURL, tt.data)
 			if gotURL != tt.wantURL {
-				t.Errorf("makeAPIURL() =\n  %v\nwant:\n  %v", gotURL, tt.wantURL)
+				t.Errorf("BuildFetchURL() =\n  %v\nwant:\n  %v", gotURL, tt.wantURL)
 			}
 		})
 	}
 }
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /api/v1/consumer"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:   "success",
+			Routes: map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket.json")},
+		},
+		{
+			Name:                      "ticket not found",
+			Routes:                    map[string]testutils.Fixture{route: testutils.JSONFixture(200, "testdata/ticket_not_found.json")},
+			WantErr:                   testutils.ErrAny,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "404",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(404, "testdata/not_found.html")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "malformed",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/maintenance.html")},
+			WantErr:                   receipts.ErrResponseDataMalformed,
+			WantShouldDecreaseRetries: true,
+		},
+	})
+}
@@ -2,6 +2,7 @@ package kz_wip_proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"image"
 	_ "image/png"
@@ -16,7 +17,9 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-type Client struct{}
+type Client struct {
+	cfg receipts.ClientConfig
+}
 
 func (c *Client) Init() error {
 	return nil
@@ -25,7 +28,7 @@ func (c *Client) Init() error {
 // регулярка для поиска base64 QR-кода в HTML
 var qrImageRegex = regexp.MustCompile(`<img\s+src="data:image/png;base64,\s*([A-Za-z0-9+/=\s]+)"`)
 
-func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
+func (c *Client) FetchReceipt(ctx context.Context, iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
 	res := receipts.FetchReceiptResult{ShouldDecreaseRetries: false}
 
 	ref, err := receipts.CastReceiptRefTo[ReceiptRef](iRef, Domain.Code)
@@ -34,13 +37,18 @@ func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error
 	}
 
 	fetchURL := ref.text
+	if c.cfg.BaseURL != "" {
+		if fetchURL, err = utils.ReplaceURLBase(fetchURL, c.cfg.BaseURL); err != nil {
+			return res, merry.Wrap(err)
+		}
+	}
 
-	req, err := http.NewRequest("GET", fetchURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fetchURL, nil)
 	if err != nil {
 		return res, merry.Wrap(err)
 	}
 
-	resp, buf, err := utils.GetHTTPBody(http.DefaultClient, req)
+	resp, buf, err := utils.GetHTTPBody(c.cfg.HTTPClientOr(utils.DefaultHTTPClient), req)
 	if err != nil {
 		return res, err
 	}
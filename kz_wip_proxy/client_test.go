@@ -1,6 +1,8 @@
 package kz_wip_proxy
 
 import (
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
 	"testing"
 )
 
@@ -32,3 +34,31 @@ func TestDecodeInnerQR_NoImage(t *testing.T) {
 		t.Error("expected error for HTML without QR image, got nil")
 	}
 }
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("https://app.kassa.wipon.kz/links/check/8ab7d2bb-1234-4a8c-4321-02185db12a23")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /links/check/8ab7d2bb-1234-4a8c-4321-02185db12a23"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:                "redirect",
+			Routes:              map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/check.html")},
+			WantRedirectRefText: "https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045",
+		},
+		{
+			Name:                      "page without QR",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/check_without_qr.html")},
+			WantErr:                   testutils.ErrAny,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:                      "404",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(404, "testdata/not_found.html")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+	})
+}
@@ -22,8 +22,8 @@ var Domain = receipts.Domain{
 	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
 		return NewReceiptRef(refText)
 	},
-	NewClient: func() receipts.Client {
-		return &Client{}
+	NewClient: func(cfg receipts.ClientConfig) receipts.Client {
+		return &Client{cfg: cfg}
 	},
 }
 
@@ -56,6 +56,11 @@ func (r ReceiptRef) UniqueKey() string {
 	return r.Domain().Code + ":" + r.uuid
 }
 
+// FiscalKey пустой: в ссылке только UUID, настоящий чек становится известен после редиректа
+func (r ReceiptRef) FiscalKey() string {
+	return ""
+}
+
 func (r ReceiptRef) CreatedAt() time.Time {
 	return time.Now()
 }
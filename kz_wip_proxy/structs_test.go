@@ -32,6 +32,9 @@ func TestParseReceiptRef(t *testing.T) {
 			if ref.UniqueKey() != tt.wantUniqKey {
 				t.Errorf("UniqueKey() = %v, want %v", ref.UniqueKey(), tt.wantUniqKey)
 			}
+			if ref.FiscalKey() != "" {
+				t.Errorf("FiscalKey() = %v, want empty", ref.FiscalKey())
+			}
 			if ref.Domain().Code != "kz-wip-proxy" {
 				t.Errorf("Domain().Code = %v, want kz-wip-proxy", ref.Domain().Code)
 			}
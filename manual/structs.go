@@ -0,0 +1,162 @@
+package manual
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"receipt_qr_scanner/receipts"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Domain — чеки, введённые вручную (без QR-кода). Скачивать нечего:
+// все данные лежат в самой ссылке "manual:{...json...}" и сохраняются сразу.
+var Domain = receipts.Domain{
+	Code:           "manual",
+	CurrencySymbol: "", //у каждого чека своя, см. ReceiptRef.CurrencySymbol
+	FlagSymbol:     "✍️",
+	Provider: receipts.Provider{
+		Name:       "Введён вручную",
+		ShortLabel: "Р",
+		Color:      "#808080",
+	},
+	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
+		return NewReceiptRef(refText)
+	},
+}
+
+const refTextPrefix = "manual:"
+
+// ReceiptData — введённые пользователем данные чека (они же — данные чека в БД)
+type ReceiptData struct {
+	// время покупки как на чеке, часовой пояс отбрасывается (как и у чеков из QR-кодов)
+	CreatedAt time.Time `json:"createdAt"`
+	Sum       float64   `json:"sum"`
+	Currency  string    `json:"currency"` //символ валюты, как у доменов: ₽, ₸, сом...
+	Seller    string    `json:"seller"`
+	Items     []Item    `json:"items,omitempty"`
+}
+
+type Item struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity,omitempty"`
+	Price    float64 `json:"price,omitempty"`
+	Sum      float64 `json:"sum,omitempty"`
+}
+
+func NewReceiptRef(refText string) (ReceiptRef, error) {
+	jsonStr, ok := strings.CutPrefix(refText, refTextPrefix)
+	if !ok {
+		return ReceiptRef{}, merry.Errorf("unexpected prefix, expected %q", refTextPrefix)
+	}
+	var data ReceiptData
+	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
+		return ReceiptRef{}, merry.Wrap(err)
+	}
+	return NewReceiptRefFromData(data)
+}
+
+// NewReceiptRefFromData проверяет и нормализует введённые данные и делает из них ссылку
+func NewReceiptRefFromData(data ReceiptData) (ReceiptRef, error) {
+	if data.CreatedAt.IsZero() {
+		return ReceiptRef{}, receipts.ReceiptRefFieldErr{Name: "createdAt", IsMissing: true}
+	}
+	t := data.CreatedAt
+	data.CreatedAt = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+
+	data.Sum = roundMoney(data.Sum)
+	if data.Sum <= 0 {
+		return ReceiptRef{}, receipts.ReceiptRefFieldErr{Name: "sum", ValueStr: formatMoney(data.Sum)}
+	}
+	data.Currency = strings.TrimSpace(data.Currency)
+	if data.Currency == "" {
+		return ReceiptRef{}, receipts.ReceiptRefFieldErr{Name: "currency", IsMissing: true}
+	}
+	data.Seller = strings.TrimSpace(data.Seller)
+	if data.Seller == "" {
+		return ReceiptRef{}, receipts.ReceiptRefFieldErr{Name: "seller", IsMissing: true}
+	}
+	data.Items = append([]Item(nil), data.Items...) //чтоб не менять слайс вызывающего
+	for i, item := range data.Items {
+		item.Name = strings.TrimSpace(item.Name)
+		if item.Name == "" {
+			return ReceiptRef{}, receipts.ReceiptRefFieldErr{Name: "items.name", ValueStr: strconv.Itoa(i), IsMissing: true}
+		}
+		item.Price = roundMoney(item.Price)
+		item.Sum = roundMoney(item.Sum)
+		data.Items[i] = item
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return ReceiptRef{}, merry.Wrap(err)
+	}
+	return ReceiptRef{text: refTextPrefix + string(buf), data: data}, nil
+}
+
+func roundMoney(sum float64) float64 {
+	return math.Round(sum*100) / 100
+}
+
+func formatMoney(sum float64) string {
+	return strconv.FormatFloat(sum, 'f', 2, 64)
+}
+
+// ReceiptRef — ссылка на введённый вручную чек. Текст ссылки всегда нормализованный
+// (поля проверены, JSON пересобран), так что одинаковые чеки дают одинаковый текст.
+type ReceiptRef struct {
+	text string
+	data ReceiptData
+}
+
+func (r ReceiptRef) Data() ReceiptData {
+	return r.data
+}
+
+func (r ReceiptRef) String() string {
+	return fmt.Sprintf("Ref{%s:%s}", Domain.Code, r.text)
+}
+
+func (r ReceiptRef) Domain() receipts.Domain {
+	return Domain
+}
+
+func (r ReceiptRef) RefText() string {
+	return r.text
+}
+
+func (r ReceiptRef) UniqueKey() string {
+	return r.text
+}
+
+// FiscalKey — у введённого вручную чека фискальных данных нет
+func (r ReceiptRef) FiscalKey() string {
+	return ""
+}
+
+func (r ReceiptRef) CreatedAt() time.Time {
+	return r.data.CreatedAt
+}
+
+func (r ReceiptRef) Sum() float64 {
+	return r.data.Sum
+}
+
+func (r ReceiptRef) CurrencySymbol() string {
+	return r.data.Currency
+}
+
+func (r ReceiptRef) ReceiptData() []byte {
+	return []byte(strings.TrimPrefix(r.text, refTextPrefix))
+}
+
+// SearchKeyItems — продавец и товары попадут в поиск из данных чека
+func (r ReceiptRef) SearchKeyItems() []string {
+	return []string{
+		"_created_at:" + r.data.CreatedAt.Format("2006-01-02 15:04"),
+		"_sum:" + formatMoney(r.data.Sum),
+	}
+}
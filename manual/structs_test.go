@@ -0,0 +1,59 @@
+package manual
+
+import (
+	"errors"
+	"receipt_qr_scanner/receipts"
+	"testing"
+	"time"
+)
+
+func TestNewReceiptRef(t *testing.T) {
+	ref, err := NewReceiptRef(`manual:{"createdAt":"2026-03-10T12:30:00+05:00","sum":99.999,"currency":" ₸","seller":"Базар ",` +
+		`"items":[{"name":" Хлеб","sum":100}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `manual:{"createdAt":"2026-03-10T12:30:00Z","sum":100,"currency":"₸","seller":"Базар","items":[{"name":"Хлеб","sum":100}]}`
+	if ref.RefText() != want || ref.UniqueKey() != want {
+		t.Errorf("RefText() =\n  %s\nwant:\n  %s", ref.RefText(), want)
+	}
+	if !ref.CreatedAt().Equal(time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)) || ref.Sum() != 100 || ref.CurrencySymbol() != "₸" {
+		t.Errorf("ref = %+v", ref.Data())
+	}
+	if string(ref.ReceiptData()) != want[len(refTextPrefix):] {
+		t.Errorf("ReceiptData() = %s", ref.ReceiptData())
+	}
+
+	// нормализованный текст разбирается в ту же ссылку
+	again, err := NewReceiptRef(ref.RefText())
+	if err != nil || again.RefText() != ref.RefText() {
+		t.Errorf("reparsed = %v %v", again, err)
+	}
+}
+
+func TestNewReceiptRefErrors(t *testing.T) {
+	createdAt := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		data      ReceiptData
+		wantField string
+	}{
+		{ReceiptData{Sum: 1, Currency: "₸", Seller: "A"}, "createdAt"},
+		{ReceiptData{CreatedAt: createdAt, Sum: 0.001, Currency: "₸", Seller: "A"}, "sum"},
+		{ReceiptData{CreatedAt: createdAt, Sum: -5, Currency: "₸", Seller: "A"}, "sum"},
+		{ReceiptData{CreatedAt: createdAt, Sum: 1, Currency: " ", Seller: "A"}, "currency"},
+		{ReceiptData{CreatedAt: createdAt, Sum: 1, Currency: "₸"}, "seller"},
+		{ReceiptData{CreatedAt: createdAt, Sum: 1, Currency: "₸", Seller: "A", Items: []Item{{Name: ""}}}, "items.name"},
+	} {
+		_, err := NewReceiptRefFromData(tt.data)
+		var fErr receipts.ReceiptRefFieldErr
+		if !errors.As(err, &fErr) || fErr.Name != tt.wantField {
+			t.Errorf("NewReceiptRefFromData(%+v) error = %v, want %s field error", tt.data, err, tt.wantField)
+		}
+	}
+
+	for _, refText := range []string{"https://example.com/?a=1", "manual:{oops"} {
+		if _, err := NewReceiptRef(refText); err == nil {
+			t.Errorf("NewReceiptRef(%s) expected error", refText)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/utils"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// границы корзин гистограммы длительности скачивания чека, в секундах
+var fetchDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type fetchCounterKey struct {
+	Domain string
+	Result string
+}
+
+type histogram struct {
+	Counts []int64 //по корзинам fetchDurationBuckets, не накопительно
+	Sum    float64
+	Count  int64
+}
+
+// Metrics — счётчики апдейтера для /metrics (остальные значения считаются при запросе)
+type Metrics struct {
+	fetches        map[fetchCounterKey]int64
+	fetchDurations map[string]*histogram
+	mutex          sync.Mutex
+}
+
+var metrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		fetches:        map[fetchCounterKey]int64{},
+		fetchDurations: map[string]*histogram{},
+	}
+}
+
+func (m *Metrics) ObserveFetch(domainCode, result string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.fetches[fetchCounterKey{domainCode, result}]++
+
+	h, ok := m.fetchDurations[domainCode]
+	if !ok {
+		h = &histogram{Counts: make([]int64, len(fetchDurationBuckets))}
+		m.fetchDurations[domainCode] = h
+	}
+	secs := duration.Seconds()
+	if i := sort.SearchFloat64s(fetchDurationBuckets, secs); i < len(fetchDurationBuckets) {
+		h.Counts[i]++
+	}
+	h.Sum += secs
+	h.Count++
+}
+
+func (m *Metrics) FetchCount(domainCode, result string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.fetches[fetchCounterKey{domainCode, result}]
+}
+
+// fetchResultLabel — тип результата скачивания чека для метрик
+func fetchResultLabel(res receipts.FetchReceiptResult, err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil && res.RedirectRefText != "":
+		return "redirect"
+	case err == nil:
+		return "ok"
+	case merry.Is(err, ErrClientPanic):
+		return "panic"
+	case merry.Is(err, receipts.ErrUnexpectedHttpStatus):
+		return "unexpected_status"
+	case merry.Is(err, receipts.ErrResponseDataMalformed):
+		return "malformed"
+	case merry.Is(err, utils.ErrResponseTooLarge):
+		return "too_large"
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	case res.ShouldDecreaseRetries:
+		return "not_found"
+	default:
+		return "other"
+	}
+}
+
+// MetricsSnapshot — значения, которые считаются в момент запроса /metrics
+type MetricsSnapshot struct {
+	ReceiptCounts     []ReceiptCounts
+	SSEClients        int
+	NextRetryIn       *time.Duration //nil, если ждущих чеков нет
+	ComponentStatuses []ComponentStatus
+	// код домена -> на паузе ли он (см. DomainBreaker)
+	DomainsPaused map[string]bool
+}
+
+// WritePrometheus пишет метрики в текстовом формате Prometheus
+// (https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format)
+func (m *Metrics) WritePrometheus(wr io.Writer, snap MetricsSnapshot) error {
+	var b strings.Builder
+	header := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	value := func(name string, val float64, labels ...string) {
+		b.WriteString(name)
+		if len(labels) > 0 {
+			b.WriteByte('{')
+			for i := 0; i < len(labels); i += 2 {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				b.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+			}
+			b.WriteByte('}')
+		}
+		b.WriteString(" " + strconv.FormatFloat(val, 'g', -1, 64) + "\n")
+	}
+
+	header("receipt_qr_scanner_pending_receipts", "gauge", "Receipts waiting to be fetched.")
+	for _, c := range snap.ReceiptCounts {
+		value("receipt_qr_scanner_pending_receipts", float64(c.Pending), "domain", c.Domain)
+	}
+	header("receipt_qr_scanner_failed_receipts", "gauge", "Receipts without data and with no retries left.")
+	for _, c := range snap.ReceiptCounts {
+		value("receipt_qr_scanner_failed_receipts", float64(c.Failed), "domain", c.Domain)
+	}
+
+	if snap.NextRetryIn != nil {
+		header("receipt_qr_scanner_next_retry_seconds", "gauge", "Time until the next pending receipt fetch.")
+		value("receipt_qr_scanner_next_retry_seconds", max(0, snap.NextRetryIn.Seconds()))
+	}
+
+	header("receipt_qr_scanner_domain_paused", "gauge", "Whether a domain is paused by the circuit breaker.")
+	pausedCodes := slices.Sorted(maps.Keys(snap.DomainsPaused))
+	for _, code := range pausedCodes {
+		paused := 0.0
+		if snap.DomainsPaused[code] {
+			paused = 1
+		}
+		value("receipt_qr_scanner_domain_paused", paused, "domain", code)
+	}
+
+	header("receipt_qr_scanner_sse_clients", "gauge", "Connected SSE clients.")
+	value("receipt_qr_scanner_sse_clients", float64(snap.SSEClients))
+
+	header("receipt_qr_scanner_component_up", "gauge", "Whether a background component is healthy.")
+	for _, c := range snap.ComponentStatuses {
+		up := 0.0
+		if c.IsOK {
+			up = 1
+		}
+		value("receipt_qr_scanner_component_up", up, "component", c.Name)
+	}
+	header("receipt_qr_scanner_component_restarts_total", "counter", "Background component restarts after failures.")
+	for _, c := range snap.ComponentStatuses {
+		value("receipt_qr_scanner_component_restarts_total", float64(c.Restarts), "component", c.Name)
+	}
+
+	m.mutex.Lock()
+	header("receipt_qr_scanner_fetches_total", "counter", "Receipt fetch attempts by result (ok, redirect or error type).")
+	fetchKeys := make([]fetchCounterKey, 0, len(m.fetches))
+	for k := range m.fetches {
+		fetchKeys = append(fetchKeys, k)
+	}
+	slices.SortFunc(fetchKeys, func(a, b fetchCounterKey) int {
+		return strings.Compare(a.Domain+" "+a.Result, b.Domain+" "+b.Result)
+	})
+	for _, k := range fetchKeys {
+		value("receipt_qr_scanner_fetches_total", float64(m.fetches[k]), "domain", k.Domain, "result", k.Result)
+	}
+
+	header("receipt_qr_scanner_fetch_duration_seconds", "histogram", "Receipt fetch duration by provider.")
+	domainCodes := make([]string, 0, len(m.fetchDurations))
+	for code := range m.fetchDurations {
+		domainCodes = append(domainCodes, code)
+	}
+	slices.Sort(domainCodes)
+	for _, code := range domainCodes {
+		h := m.fetchDurations[code]
+		cumulative := int64(0)
+		for i, le := range fetchDurationBuckets {
+			cumulative += h.Counts[i]
+			value("receipt_qr_scanner_fetch_duration_seconds_bucket", float64(cumulative), "domain", code, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		value("receipt_qr_scanner_fetch_duration_seconds_bucket", float64(h.Count), "domain", code, "le", "+Inf")
+		value("receipt_qr_scanner_fetch_duration_seconds_sum", h.Sum, "domain", code)
+		value("receipt_qr_scanner_fetch_duration_seconds_count", float64(h.Count), "domain", code)
+	}
+	m.mutex.Unlock()
+
+	_, err := io.WriteString(wr, b.String())
+	return merry.Wrap(err)
+}
+
+func escapeLabelValue(val string) string {
+	val = strings.ReplaceAll(val, `\`, `\\`)
+	val = strings.ReplaceAll(val, `"`, `\"`)
+	return strings.ReplaceAll(val, "\n", `\n`)
+}
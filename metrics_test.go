@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"receipt_qr_scanner/receipts"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+func TestFetchResultLabel(t *testing.T) {
+	for _, tc := range []struct {
+		res  receipts.FetchReceiptResult
+		err  error
+		want string
+	}{
+		{receipts.FetchReceiptResult{Data: []byte("{}")}, nil, "ok"},
+		{receipts.FetchReceiptResult{RedirectRefText: "https://example.com"}, nil, "redirect"},
+		{receipts.FetchReceiptResult{}, receipts.ErrUnexpectedHttpStatus.Here(), "unexpected_status"},
+		{receipts.FetchReceiptResult{}, merry.Prepend(receipts.ErrResponseDataMalformed, "kz-ktc"), "malformed"},
+		{receipts.FetchReceiptResult{}, merry.Wrap(context.DeadlineExceeded), "timeout"},
+		{receipts.FetchReceiptResult{ShouldDecreaseRetries: true}, merry.New("receipt not found"), "not_found"},
+		{receipts.FetchReceiptResult{}, merry.New("session is not ready"), "other"},
+	} {
+		if got := fetchResultLabel(tc.res, tc.err); got != tc.want {
+			t.Errorf("fetchResultLabel(%+v, %v) = %s, want %s", tc.res, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	m := NewMetrics()
+	m.ObserveFetch("kz-ktc", "ok", 300*time.Millisecond)
+	m.ObserveFetch("kz-ktc", "ok", 2*time.Second)
+	m.ObserveFetch("kz-ktc", "malformed", 90*time.Second)
+
+	nextRetryIn := 90 * time.Second
+	var buf strings.Builder
+	err := m.WritePrometheus(&buf, MetricsSnapshot{
+		ReceiptCounts:     []ReceiptCounts{{Domain: "kz-ktc", Pending: 3, Failed: 1}},
+		SSEClients:        2,
+		NextRetryIn:       &nextRetryIn,
+		ComponentStatuses: []ComponentStatus{{Name: updaterComponent, IsOK: false, Restarts: 4}},
+		DomainsPaused:     map[string]bool{"kz-ktc": true, "ru-fns": false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE receipt_qr_scanner_pending_receipts gauge\n",
+		`receipt_qr_scanner_pending_receipts{domain="kz-ktc"} 3` + "\n",
+		`receipt_qr_scanner_failed_receipts{domain="kz-ktc"} 1` + "\n",
+		"receipt_qr_scanner_next_retry_seconds 90\n",
+		"receipt_qr_scanner_sse_clients 2\n",
+		`receipt_qr_scanner_domain_paused{domain="kz-ktc"} 1` + "\n",
+		`receipt_qr_scanner_domain_paused{domain="ru-fns"} 0` + "\n",
+		`receipt_qr_scanner_component_up{component="updater"} 0` + "\n",
+		`receipt_qr_scanner_component_restarts_total{component="updater"} 4` + "\n",
+		`receipt_qr_scanner_fetches_total{domain="kz-ktc",result="malformed"} 1` + "\n",
+		`receipt_qr_scanner_fetches_total{domain="kz-ktc",result="ok"} 2` + "\n",
+		`receipt_qr_scanner_fetch_duration_seconds_bucket{domain="kz-ktc",le="0.25"} 0` + "\n",
+		`receipt_qr_scanner_fetch_duration_seconds_bucket{domain="kz-ktc",le="0.5"} 1` + "\n",
+		`receipt_qr_scanner_fetch_duration_seconds_bucket{domain="kz-ktc",le="60"} 2` + "\n",
+		`receipt_qr_scanner_fetch_duration_seconds_bucket{domain="kz-ktc",le="+Inf"} 3` + "\n",
+		`receipt_qr_scanner_fetch_duration_seconds_sum{domain="kz-ktc"} 92.3` + "\n",
+		`receipt_qr_scanner_fetch_duration_seconds_count{domain="kz-ktc"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output does not contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"receipt_qr_scanner/receipts"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+const notificationsComponent = "notifications"
+
+// пауза перед предупреждением о неудачных чеках, чтоб собрать одновременно упавшие в одно письмо
+var failureWarningDelay = time.Minute
+
+// пауза перед повтором неудачной отправки уведомления
+var notifyRetryDelay = 5 * time.Minute
+
+// Notifications копит события скачанных и окончательно неудачных чеков (таблица notification_events)
+// и отправляет через Notifier ежедневную сводку скачанных чеков и предупреждения о неудачных по доменам.
+type Notifications struct {
+	db       *sql.DB
+	cfg      NotificationsConfig
+	notifier Notifier
+	wakeChan chan struct{}
+	now      func() time.Time
+}
+
+func NewNotifications(db *sql.DB, cfg NotificationsConfig, notifier Notifier) *Notifications {
+	return &Notifications{db: db, cfg: cfg, notifier: notifier, wakeChan: make(chan struct{}, 1), now: time.Now}
+}
+
+func (n *Notifications) IsEnabled() bool {
+	return n != nil && n.cfg.IsEnabled()
+}
+
+func (n *Notifications) HandleReceiptEvent(event string, receiptID int64) error {
+	if !n.IsEnabled() {
+		return nil
+	}
+	if (event == EventReceiptFetched && n.cfg.DigestAt != "") || (event == EventReceiptFailed && n.cfg.FailureWarnings) {
+		if err := saveNotificationEvent(n.db, event, receiptID); err != nil {
+			return merry.Wrap(err)
+		}
+		select {
+		case n.wakeChan <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run отправляет уведомления, пока не отменён ctx. Ошибки отправки повторяются через notifyRetryDelay,
+// наружу возвращаются только ошибки БД. Неотправленные события остаются в БД до следующего запуска.
+func (n *Notifications) Run(ctx context.Context, health *Health) error {
+	farFuture := n.now().Add(200 * 365 * 24 * time.Hour)
+	nextDigestAt := farFuture
+	if n.cfg.DigestAt != "" {
+		nextDigestAt = nextDailyTime(n.now(), n.cfg.DigestAt)
+	}
+
+	timer := time.NewTimer(time.Hour)
+	for {
+		nextWarningAt := farFuture
+		if n.cfg.FailureWarnings {
+			var err error
+			if nextWarningAt, err = n.sendFailureWarnings(ctx); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		if !n.now().Before(nextDigestAt) {
+			var err error
+			if nextDigestAt, err = n.sendDigest(ctx); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		health.SetOK(notificationsComponent)
+
+		nextRunAt := nextDigestAt
+		if nextWarningAt.Before(nextRunAt) {
+			nextRunAt = nextWarningAt
+		}
+		delay := nextRunAt.Sub(n.now())
+		if delay < time.Second {
+			delay = time.Second
+		}
+		if !timer.Stop() && len(timer.C) > 0 {
+			<-timer.C
+		}
+		timer.Reset(delay)
+
+		select {
+		case <-n.wakeChan:
+		case <-timer.C:
+		case <-ctx.Done():
+			log.Info().Msg("notifications stopped")
+			return nil
+		}
+	}
+}
+
+// sendFailureWarnings отправляет по предупреждению на каждый домен с новыми неудачными чеками.
+// Возвращает, когда вызвать её снова.
+func (n *Notifications) sendFailureWarnings(ctx context.Context) (time.Time, error) {
+	now := n.now()
+	events, err := loadUnsentNotificationEvents(n.db, EventReceiptFailed)
+	if err != nil {
+		return now, merry.Wrap(err)
+	}
+	if len(events) == 0 {
+		return now.Add(200 * 365 * 24 * time.Hour), nil
+	}
+	if sendAt := events[0].CreatedAt.Add(failureWarningDelay); now.Before(sendAt) {
+		return sendAt, nil //ждём, вдруг упадут ещё
+	}
+
+	var domainCodes []string
+	domain2events := map[string][]*NotificationEvent{}
+	for _, e := range events {
+		code := e.Receipt.Domain
+		if _, ok := domain2events[code]; !ok {
+			domainCodes = append(domainCodes, code)
+		}
+		domain2events[code] = append(domain2events[code], e)
+	}
+
+	for _, code := range domainCodes {
+		domainEvents := domain2events[code]
+		subject := fmt.Sprintf("%s: не удалось скачать чеки (%d)", code, len(domainEvents))
+		text := "Попытки скачать эти чеки закончились:\n\n" + formatNotificationReceipts(domainEvents)
+		if err := n.notifier.Notify(ctx, subject, text); err != nil {
+			log.Warn().Err(err).Str("domain", code).Str("retry_in", notifyRetryDelay.String()).Msg("failure warning notification failed")
+			return now.Add(notifyRetryDelay), nil
+		}
+		log.Info().Str("domain", code).Int("count", len(domainEvents)).Msg("failure warning sent")
+		if err := saveNotificationEventsSent(n.db, domainEvents); err != nil {
+			return now, merry.Wrap(err)
+		}
+	}
+	return now.Add(200 * 365 * 24 * time.Hour), nil
+}
+
+// sendDigest отправляет сводку скачанных с прошлой сводки чеков (если они есть).
+// Возвращает время следующей сводки.
+func (n *Notifications) sendDigest(ctx context.Context) (time.Time, error) {
+	now := n.now()
+	events, err := loadUnsentNotificationEvents(n.db, EventReceiptFetched)
+	if err != nil {
+		return now, merry.Wrap(err)
+	}
+	if len(events) > 0 {
+		subject := fmt.Sprintf("Скачанные чеки (%d)", len(events))
+		text := "С прошлой сводки скачаны чеки:\n\n" + formatNotificationReceipts(events)
+		if err := n.notifier.Notify(ctx, subject, text); err != nil {
+			log.Warn().Err(err).Str("retry_in", notifyRetryDelay.String()).Msg("digest notification failed")
+			return now.Add(notifyRetryDelay), nil
+		}
+		log.Info().Int("count", len(events)).Msg("digest sent")
+		if err := saveNotificationEventsSent(n.db, events); err != nil {
+			return now, merry.Wrap(err)
+		}
+	}
+	return nextDailyTime(now, n.cfg.DigestAt), nil
+}
+
+// formatNotificationReceipts — список чеков для письма; сумма и валюта — сохранённые в БД
+// (сумма может браться из скачанных данных, у возвратов она отрицательная, валюта бывает своя у чека)
+func formatNotificationReceipts(events []*NotificationEvent) string {
+	var b strings.Builder
+	for _, e := range events {
+		rec := e.Receipt
+		currency := rec.Currency
+		if currency == "" {
+			if i := slices.IndexFunc(allDomains, func(d receipts.Domain) bool { return d.Code == rec.Domain }); i != -1 {
+				currency = allDomains[i].CurrencySymbol
+			}
+		}
+		sumStr := strconv.FormatFloat(rec.Sum, 'f', 2, 64) + " " + currency
+		fmt.Fprintf(&b, "%s  %s  %s\n  %s\n", rec.CreatedAt.Format("2006-01-02 15:04"), rec.Domain, sumStr, rec.RefText)
+	}
+	return b.String()
+}
+
+// nextDailyTime — ближайшее после now время hhmm (ЧЧ:ММ) в часовом поясе now
+func nextDailyTime(now time.Time, hhmm string) time.Time {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return now.Add(24 * time.Hour) //конфиг уже проверен, сюда попасть не должны
+	}
+	res := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !res.After(now) {
+		res = res.AddDate(0, 0, 1)
+	}
+	return res
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"receipt_qr_scanner/receipts"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMail struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+}
+
+// startTestSMTPServer — минимальный SMTP-сервер (без TLS и авторизации), складывающий письма в список
+func startTestSMTPServer(t *testing.T) (string, func() []testMail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mails []testMail
+	var mutex sync.Mutex
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+		rd := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost test")
+		var cur testMail
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				cur = testMail{From: strings.Trim(strings.TrimSpace(line)[10:], "<>")}
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				cur.To = append(cur.To, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+				reply("250 ok")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(line, "."))
+				}
+				msg, err := mail.ReadMessage(strings.NewReader(data.String()))
+				if err != nil {
+					t.Errorf("reading mail: %v", err)
+					return
+				}
+				cur.Subject, _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+				text, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+				cur.Text = string(text)
+				mutex.Lock()
+				mails = append(mails, cur)
+				mutex.Unlock()
+				reply("250 ok")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return ln.Addr().String(), func() []testMail {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]testMail(nil), mails...)
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, getMails := startTestSMTPServer(t)
+	notifier := NewSMTPNotifier(SMTPConfig{Addr: addr, From: "Scanner <scanner@example.com>", To: []string{"me@example.com"}})
+
+	if err := notifier.Notify(context.Background(), "Скачанные чеки (1)", "строка 1\nстрока 2\n"); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	mails := getMails()
+	if len(mails) != 1 {
+		t.Fatalf("mails count = %d, want 1", len(mails))
+	}
+	m := mails[0]
+	if m.From != "scanner@example.com" || len(m.To) != 1 || m.To[0] != "me@example.com" {
+		t.Errorf("mail from=%q to=%v", m.From, m.To)
+	}
+	if m.Subject != "Скачанные чеки (1)" {
+		t.Errorf("subject = %q", m.Subject)
+	}
+	if m.Text != "строка 1\r\nстрока 2\r\n" {
+		t.Errorf("text = %q", m.Text)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	db := openTestDB(t)
+	addr, getMails := startTestSMTPServer(t)
+
+	cfg := NotificationsConfig{
+		SMTP:            &SMTPConfig{Addr: addr, From: "scanner@example.com", To: []string{"me@example.com"}},
+		DigestAt:        "09:00",
+		FailureWarnings: true,
+	}
+	notifs := NewNotifications(db, cfg, NewSMTPNotifier(*cfg.SMTP))
+
+	saveRef := func(refText string) int64 {
+		t.Helper()
+		ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := saveRecieptRef(db, ref, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	fetchedID := saveRef("https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045")
+	failedID := saveRef("https://app.kassa.wipon.kz/consumer?i=5612340018&f=600407890704&s=100.50&t=20260311T100000")
+
+	for _, e := range []struct {
+		Event string
+		ID    int64
+	}{
+		{EventReceiptAdded, fetchedID}, //не нужно ни сводке, ни предупреждениям
+		{EventReceiptFetched, fetchedID},
+		{EventReceiptFailed, failedID},
+	} {
+		if err := notifs.HandleReceiptEvent(e.Event, e.ID); err != nil {
+			t.Fatalf("HandleReceiptEvent(%s) error = %v", e.Event, err)
+		}
+	}
+
+	// предупреждение ждёт failureWarningDelay, чтоб собрать неудачные чеки вместе
+	nextAt, err := notifs.sendFailureWarnings(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getMails()) != 0 || nextAt.Before(time.Now()) {
+		t.Errorf("warning sent too early (mails: %d, next at %s)", len(getMails()), nextAt)
+	}
+
+	notifs.now = func() time.Time { return time.Now().Add(failureWarningDelay + time.Second) }
+	if _, err := notifs.sendFailureWarnings(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	digestNow := time.Date(2026, 3, 12, 9, 0, 0, 0, time.Local)
+	notifs.now = func() time.Time { return digestNow }
+	nextDigestAt, err := notifs.sendDigest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := digestNow.AddDate(0, 0, 1); !nextDigestAt.Equal(want) {
+		t.Errorf("next digest at %s, want %s", nextDigestAt, want)
+	}
+
+	mails := getMails()
+	if len(mails) != 2 {
+		t.Fatalf("mails count = %d, want 2: %+v", len(mails), mails)
+	}
+	if want := "kz-wip: не удалось скачать чеки (1)"; mails[0].Subject != want {
+		t.Errorf("warning subject = %q, want %q", mails[0].Subject, want)
+	}
+	if !strings.Contains(mails[0].Text, "100.50") || strings.Contains(mails[0].Text, "2750.00") {
+		t.Errorf("warning text = %q", mails[0].Text)
+	}
+	if want := "Скачанные чеки (1)"; mails[1].Subject != want {
+		t.Errorf("digest subject = %q, want %q", mails[1].Subject, want)
+	}
+	if !strings.Contains(mails[1].Text, "2750.00") || strings.Contains(mails[1].Text, "100.50") {
+		t.Errorf("digest text = %q", mails[1].Text)
+	}
+
+	// отправленное повторно не отправляется
+	if _, err := notifs.sendFailureWarnings(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := notifs.sendDigest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(getMails()) != 2 {
+		t.Errorf("mails count = %d after resend, want 2", len(getMails()))
+	}
+}
+
+func TestFormatNotificationReceipts(t *testing.T) {
+	createdAt := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	text := formatNotificationReceipts([]*NotificationEvent{
+		{Receipt: &receipts.Receipt{Domain: "ru-fns", CreatedAt: createdAt, Sum: -250, IsRefund: true, RefText: "t=20260310T1230&n=2"}},
+		{Receipt: &receipts.Receipt{Domain: "manual", CreatedAt: createdAt, Sum: 100, Currency: "₸", RefText: "manual:{}"}},
+	})
+	want := "2026-03-10 12:30  ru-fns  -250.00 ₽\n  t=20260310T1230&n=2\n" +
+		"2026-03-10 12:30  manual  100.00 ₸\n  manual:{}\n"
+	if text != want {
+		t.Errorf("formatNotificationReceipts() = %q, want %q", text, want)
+	}
+}
+
+func TestNextDailyTime(t *testing.T) {
+	now := time.Date(2026, 3, 12, 10, 30, 0, 0, time.UTC)
+	for hhmm, want := range map[string]time.Time{
+		"09:00": time.Date(2026, 3, 13, 9, 0, 0, 0, time.UTC),
+		"10:30": time.Date(2026, 3, 13, 10, 30, 0, 0, time.UTC),
+		"23:15": time.Date(2026, 3, 12, 23, 15, 0, 0, time.UTC),
+	} {
+		if got := nextDailyTime(now, hhmm); !got.Equal(want) {
+			t.Errorf("nextDailyTime(%s) = %s, want %s", hhmm, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Notifier отправляет уведомление пользователю (письмо, сообщение в мессенджер и т.д.)
+type Notifier interface {
+	Notify(ctx context.Context, subject, text string) error
+}
+
+// таймаут всего SMTP-диалога
+var smtpTimeout = time.Minute
+
+// SMTPNotifier отправляет уведомления письмами. Если сервер поддерживает STARTTLS, соединение шифруется.
+type SMTPNotifier struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPNotifier(cfg SMTPConfig) *SMTPNotifier {
+	return &SMTPNotifier{cfg: cfg}
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, subject, text string) error {
+	from, err := mail.ParseAddress(n.cfg.From)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	var to []*mail.Address
+	for _, addrStr := range n.cfg.To {
+		addr, err := mail.ParseAddress(addrStr)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		to = append(to, addr)
+	}
+	msg, err := buildMailMessage(from, to, subject, text, time.Now())
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	host, _, err := net.SplitHostPort(n.cfg.Addr)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.cfg.Addr)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	deadline := time.Now().Add(smtpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	stopCloser := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopCloser()
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return merry.Wrap(err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	if n.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return merry.Wrap(err)
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr.Address); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	wr, err := client.Data()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if _, err := wr.Write(msg); err != nil {
+		return merry.Wrap(err)
+	}
+	if err := wr.Close(); err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(client.Quit())
+}
+
+// buildMailMessage собирает текстовое письмо (UTF-8, quoted-printable)
+func buildMailMessage(from *mail.Address, to []*mail.Address, subject, text string, date time.Time) ([]byte, error) {
+	toStrs := make([]string, len(to))
+	for i, addr := range to {
+		toStrs[i] = addr.String()
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from.String() + "\r\n")
+	buf.WriteString("To: " + strings.Join(toStrs, ", ") + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	buf.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	qpWr := quotedprintable.NewWriter(&buf)
+	if _, err := qpWr.Write([]byte(text)); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if err := qpWr.Close(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
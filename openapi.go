@@ -0,0 +1,219 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	httputils "github.com/3bl3gamer/go-http-utils"
+)
+
+// apiRoute — метод АПИ: по этому описанию регистрируется обработчик и строится OpenAPI-документ,
+// так что документ не расходится с реальными маршрутами
+type apiRoute struct {
+	Method  string
+	Path    string //в формате httprouter (:id), относительно префикса АПИ
+	Summary string
+	Params  []apiParam
+	Body    interface{}    //значение типа тела запроса (нужен только тип), nil — без тела
+	Result  interface{}    //значение типа результата (поле result ответа)
+	Errors  map[int]string //коды ошибок (поле error) по HTTP-статусам, кроме 500
+	Chain   []interface{}  //мидлвари и обработчик для httputils.Wrapper.WrapChain
+}
+
+type apiParam struct {
+	Name        string
+	In          string //query или path
+	Type        string //string, integer или boolean
+	Enum        []string
+	Required    bool
+	Description string
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// buildOpenAPISpec строит OpenAPI 3.0 документ для маршрутов с префиксом basePath.
+// Схемы берутся из Go-типов по json-тегам, именованные структуры выносятся в components.
+func buildOpenAPISpec(title, version, basePath string, routes []apiRoute) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	errorSchema := openAPISchema(reflect.TypeOf(httputils.JsonError{}), schemas)
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		path := route.Path
+		for _, part := range strings.Split(path, "/") {
+			if strings.HasPrefix(part, ":") {
+				path = strings.Replace(path, part, "{"+part[1:]+"}", 1)
+			}
+		}
+
+		var params []interface{}
+		for _, p := range route.Params {
+			schema := map[string]interface{}{"type": p.Type}
+			if len(p.Enum) > 0 {
+				schema["enum"] = p.Enum
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.Required || p.In == "path",
+				"description": p.Description,
+				"schema":      schema,
+			})
+		}
+
+		resultSchema := map[string]interface{}{}
+		if route.Result != nil {
+			resultSchema = openAPISchema(reflect.TypeOf(route.Result), schemas)
+		}
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content": map[string]interface{}{"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type":     "object",
+						"required": []string{"ok", "result"},
+						"properties": map[string]interface{}{
+							"ok":     map[string]interface{}{"type": "boolean", "enum": []bool{true}},
+							"result": resultSchema,
+						},
+					},
+				}},
+			},
+		}
+		errors := map[int]string{500: "SERVER_ERROR"}
+		for status, codes := range route.Errors {
+			errors[status] = codes
+		}
+		for status, codes := range errors {
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": codes,
+				"content": map[string]interface{}{"application/json": map[string]interface{}{
+					"schema": errorSchema,
+				}},
+			}
+		}
+
+		op := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": openAPIOperationID(route.Method, route.Path),
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{"application/json": map[string]interface{}{
+					"schema": openAPISchema(reflect.TypeOf(route.Body), schemas),
+				}},
+			}
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]interface{}{"title": title, "version": version},
+		"servers":    []interface{}{map[string]interface{}{"url": basePath}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// openAPIOperationID: GET /receipts/:id -> getReceiptsId
+func openAPIOperationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(c rune) bool { return c == '/' || c == ':' || c == '_' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// openAPISchema — схема для Go-типа (как его кодирует encoding/json).
+// Именованные структуры добавляются в schemas и возвращаются ссылкой.
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := openAPISchema(t.Elem(), schemas)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		format := "int32"
+		if t.Size() == 8 {
+			format = "int64"
+		}
+		return map[string]interface{}{"type": "integer", "format": format}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"} //[]byte кодируется в base64
+		}
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return openAPIStructSchema(t, schemas)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]interface{}{} //заглушка на случай рекурсивных типов
+			schemas[t.Name()] = openAPIStructSchema(t, schemas)
+		}
+		return ref
+	default: //interface{} и прочее — что угодно
+		return map[string]interface{}{}
+	}
+}
+
+func openAPIStructSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		omitEmpty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			tagName, opts, _ := strings.Cut(tag, ",")
+			if tagName != "" {
+				name = tagName
+			}
+			omitEmpty = strings.Contains(","+opts+",", ",omitempty,")
+		}
+		props[name] = openAPISchema(field.Type, schemas)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"io"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// Данные чеков (HTML-страницы, JSON с base64 rawData) хранятся в БД сжатыми gzip.
+// Формат определяется по первым байтам: сжатые начинаются с сигнатуры gzip (1f 8b),
+// а JSON и HTML так начинаться не могут, поэтому несжатые данные (старые или те, что
+// сжатие не уменьшило) читаются как есть.
+var receiptDataGzipMagic = []byte{0x1f, 0x8b}
+
+func isReceiptDataCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, receiptDataGzipMagic)
+}
+
+// compressReceiptData сжимает данные для сохранения в БД. Если сжатые не меньше исходных
+// (мелкий JSON), возвращает исходные. nil остаётся nil (NULL — данных нет).
+func compressReceiptData(data []byte) ([]byte, error) {
+	if len(data) == 0 || isReceiptDataCompressed(data) {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if _, err := gz.Write(data); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressReceiptData — данные из БД в исходном виде (несжатые возвращает как есть)
+func decompressReceiptData(data []byte) ([]byte, error) {
+	if !isReceiptDataCompressed(data) {
+		return data, nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer gz.Close()
+	res, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return res, nil
+}
+
+func recompressReceiptData(db *sql.DB) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	count, err := recompressAllReceiptData(tx)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return count, merry.Wrap(tx.Commit())
+}
+
+// recompressBatchSize — сколько записей сжимается за раз (в памяти держатся данные только одной пачки)
+const recompressBatchSize = 100
+
+// recompressAllReceiptData сжимает ещё не сжатые данные чеков и их прошлых версий
+// (сохранённые до появления сжатия или старой версией). Возвращает, сколько записей сжато.
+func recompressAllReceiptData(tx *sql.Tx) (int64, error) {
+	var count int64
+	for _, table := range []string{"receipts", "receipt_data_versions"} {
+		lastID := int64(0)
+		for {
+			batchCount, batchLastID, err := recompressReceiptDataBatch(tx, table, lastID)
+			if err != nil {
+				return 0, merry.Wrap(err)
+			}
+			if batchLastID == 0 {
+				break
+			}
+			count += batchCount
+			lastID = batchLastID
+		}
+	}
+	return count, nil
+}
+
+// recompressReceiptDataBatch сжимает данные пачки записей table с id > afterID (по порядку id)
+// одним UPDATE. Возвращает, сколько записей сжато, и id последней записи пачки (0 — записей больше нет).
+func recompressReceiptDataBatch(tx *sql.Tx, table string, afterID int64) (int64, int64, error) {
+	rows, err := tx.Query(`
+		SELECT id, data FROM `+table+` WHERE data IS NOT NULL AND id > ? ORDER BY id LIMIT ?`,
+		afterID, recompressBatchSize)
+	if err != nil {
+		return 0, 0, merry.Wrap(err)
+	}
+	defer rows.Close()
+
+	var lastID int64
+	var cases []string
+	var caseArgs, ids []interface{}
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return 0, 0, merry.Wrap(err)
+		}
+		lastID = id
+		newData, err := compressReceiptData(data)
+		if err != nil {
+			return 0, 0, merry.Wrap(err)
+		}
+		if !bytes.Equal(newData, data) {
+			cases = append(cases, "WHEN ? THEN ?")
+			caseArgs = append(caseArgs, id, newData)
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, merry.Wrap(err)
+	}
+	rows.Close()
+
+	if len(ids) > 0 {
+		_, err := tx.Exec(`
+			UPDATE `+table+` SET data = CASE id `+strings.Join(cases, " ")+` END
+			WHERE id IN (`+strings.Repeat(",?", len(ids))[1:]+`)`,
+			append(caseArgs, ids...)...)
+		if err != nil {
+			return 0, 0, merry.Wrap(err)
+		}
+	}
+	return int64(len(ids)), lastID, nil
+}
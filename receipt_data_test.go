@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"receipt_qr_scanner/receipts"
+	"strings"
+	"testing"
+)
+
+func TestCompressReceiptData(t *testing.T) {
+	page := []byte("<html>" + strings.Repeat("<tr><td>Товар</td><td>100.00</td></tr>", 200) + "</html>")
+	compressed, err := compressReceiptData(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isReceiptDataCompressed(compressed) || len(compressed) >= len(page)/10 {
+		t.Errorf("compressed %d -> %d bytes, want gzip and much smaller", len(page), len(compressed))
+	}
+	if again, _ := compressReceiptData(compressed); !bytes.Equal(again, compressed) {
+		t.Errorf("compressed data was compressed twice")
+	}
+	if res, err := decompressReceiptData(compressed); err != nil || !bytes.Equal(res, page) {
+		t.Errorf("decompressed = %d bytes %v, want original", len(res), err)
+	}
+
+	// мелкое сжатие только увеличит, несжатое читается как есть
+	small := []byte(`{"a":1}`)
+	if res, _ := compressReceiptData(small); !bytes.Equal(res, small) {
+		t.Errorf("small data compressed to %v", res)
+	}
+	if res, _ := decompressReceiptData(small); !bytes.Equal(res, small) {
+		t.Errorf("uncompressed data decompressed to %v", res)
+	}
+	if res, _ := compressReceiptData(nil); res != nil {
+		t.Errorf("nil compressed to %v", res)
+	}
+}
+
+func TestRecompressReceiptData(t *testing.T) {
+	db := openTestDB(t)
+
+	ref, err := receipts.ReceiptRefFromText(allDomains, "t=20260301T1000&s=300.00&fn=9999078900012345&i=10&fp=1000000001&n=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := saveRecieptRef(db, ref, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := `{"rawData":"` + strings.Repeat("QUJD", 1000) + `"}`
+	if err := saveRecieptData(db, ref, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	storedLen := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(`SELECT length(data) FROM receipts WHERE id = ?`, id).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := storedLen(); n >= len(data) {
+		t.Errorf("stored %d bytes, want compressed (< %d)", n, len(data))
+	}
+
+	// как сохранила бы старая версия
+	if _, err := db.Exec(`UPDATE receipts SET data = ? WHERE id = ?`, []byte(data), id); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := loadReceipt(db, id); err != nil || rec.Data != data {
+		t.Errorf("uncompressed loaded = %v, want original data", err)
+	}
+	count, err := recompressReceiptData(db)
+	if err != nil || count != 1 {
+		t.Errorf("recompressed %d %v, want 1", count, err)
+	}
+	if n := storedLen(); n >= len(data) {
+		t.Errorf("stored %d bytes after recompression, want compressed", n)
+	}
+	if rec, err := loadReceipt(db, id); err != nil || rec.Data != data {
+		t.Errorf("recompressed loaded = %v, want original data", err)
+	}
+	if count, _ := recompressReceiptData(db); count != 0 {
+		t.Errorf("recompressed again %d, want 0", count)
+	}
+
+	// прошлая версия тоже хранится сжатой и читается исходной
+	if err := saveRecieptData(db, ref, []byte(`{"v":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := loadReceiptDataVersions(db, id)
+	if err != nil || len(versions) != 1 || versions[0].Data != data {
+		t.Errorf("versions = %d %v, want original data", len(versions), err)
+	}
+}
+
+func TestRecompressReceiptDataBatches(t *testing.T) {
+	db := openTestDB(t)
+
+	// больше одной пачки, часть сжимать не нужно (мелкие и уже сжатые)
+	page := []byte("<html>" + strings.Repeat("<tr><td>Товар</td></tr>", 100) + "</html>")
+	compressed, err := compressReceiptData(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < recompressBatchSize*2+10; i++ {
+		data := page
+		if i%10 == 0 {
+			data = compressed
+		} else if i%10 == 1 {
+			data = []byte(`{"a":1}`)
+		}
+		_, err := db.Exec(`INSERT INTO receipt_data_versions (receipt_id, data, fetched_at) VALUES (1, ?, CURRENT_TIMESTAMP)`, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := recompressReceiptData(db)
+	if err != nil || count != 168 {
+		t.Errorf("recompressed %d %v, want 168", count, err)
+	}
+	var uncompressedCount int
+	if err := db.QueryRow(`SELECT count(*) FROM receipt_data_versions WHERE length(data) = ?`, len(page)).Scan(&uncompressedCount); err != nil {
+		t.Fatal(err)
+	}
+	if uncompressedCount != 0 {
+		t.Errorf("%d records left uncompressed", uncompressedCount)
+	}
+}
+
+func TestReceiptExtractedData(t *testing.T) {
+	db := openTestDB(t)
+
+	page, err := os.ReadFile("kz_ofd/testdata/kz_ttc_ticket.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := receipts.ReceiptRefFromText(allDomains, "http://ofd1.kz/t/?i=123456789012&f=010101234567&s=3180.00&t=20240309T164731")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := saveRecieptRef(db, ref, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRecieptData(db, ref, page); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := loadReceipt(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Data != string(page) || !strings.Contains(rec.ExtractedData, `"sellerBin":"220540017611"`) {
+		t.Errorf("extracted data = %s", rec.ExtractedData)
+	}
+	for _, want := range []string{"aimedi group", "лакомства для собак", "220540017611"} {
+		if !strings.Contains(rec.SearchKey, want) {
+			t.Errorf("search key %q does not contain %q", rec.SearchKey, want)
+		}
+	}
+	if strings.Contains(rec.SearchKey, "<") || strings.Contains(rec.SearchKey, "ncalayer") {
+		t.Errorf("search key contains page markup: %q", rec.SearchKey)
+	}
+
+	// неразобранная страница идёт в поиск как есть
+	if err := saveRecieptData(db, ref, []byte("<html>Чек не найден</html>")); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err = loadReceipt(db, id); err != nil || rec.ExtractedData != "" || !strings.Contains(rec.SearchKey, "чек не найден") {
+		t.Errorf("unparsed page: extracted %q, search key %q, %v", rec.ExtractedData, rec.SearchKey, err)
+	}
+}
@@ -1,6 +1,11 @@
 package receipts
 
-import "github.com/ansel1/merry"
+import (
+	"context"
+	"net/http"
+
+	"github.com/ansel1/merry"
+)
 
 var ErrSessionNotFound = merry.New("session not found")
 var ErrUnexpectedHttpStatus = merry.New("unexpected HTTP status")
@@ -12,9 +17,36 @@ type FetchReceiptResult struct {
 	RedirectRefText       string // если не пустой, нужно пересохранить чек с новым ref_text (см. kz-wip-proxy)
 }
 
+// ClientConfig — настройки подключения клиента к провайдеру.
+// Позволяют направить клиента на локальный стаб-сервер (тесты) или на зеркало.
+type ClientConfig struct {
+	// Схема, хост и (опционально) порт АПИ провайдера, например "http://127.0.0.1:8080".
+	// Если пустой, используется стандартный адрес провайдера.
+	BaseURL string
+	// Если nil, используется utils.DefaultHTTPClient.
+	HTTPClient *http.Client
+	// Учётные данные провайдера (ключи — из Domain.CredentialNames), например токены ru-fns.
+	Credentials map[string]string
+}
+
+func (c ClientConfig) BaseURLOr(defaultBaseURL string) string {
+	if c.BaseURL == "" {
+		return defaultBaseURL
+	}
+	return c.BaseURL
+}
+
+func (c ClientConfig) HTTPClientOr(defaultClient *http.Client) *http.Client {
+	if c.HTTPClient == nil {
+		return defaultClient
+	}
+	return c.HTTPClient
+}
+
 type Client interface {
 	Init() error
-	FetchReceipt(ref ReceiptRef, onIsCorrect func() error) (FetchReceiptResult, error)
+	// ctx отменяется при остановке программы, запросы к провайдеру должны на это реагировать
+	FetchReceipt(ctx context.Context, ref ReceiptRef, onIsCorrect func() error) (FetchReceiptResult, error)
 }
 
 type ClientWithSession interface {
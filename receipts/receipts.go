@@ -1,7 +1,9 @@
 package receipts
 
 import (
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/ansel1/merry"
@@ -13,7 +15,19 @@ type Domain struct {
 	FlagSymbol      string
 	Provider        Provider
 	ParseReceiptRef func(refText string) (ReceiptRef, error)
-	NewClient       func() Client
+	// nil — скачивать нечего, все данные чека есть в ссылке (см. ReceiptRefWithData)
+	NewClient func(cfg ClientConfig) Client
+	// имена учётных данных, которые клиент берёт из ClientConfig.Credentials (для проверки конфига)
+	CredentialNames []string
+	// продавец и товары из скачанных данных чека (для связи возвратов с покупками),
+	// nil — домен так не умеет
+	ParseReceiptSummary func(data []byte) (ReceiptSummary, error)
+	// структурированные данные (JSON) из скачанных, если те сами не структурированы (HTML-страница):
+	// хранятся рядом с ними и идут в поиск вместо них; nil — не нужно
+	ExtractReceiptData func(data []byte) ([]byte, error)
+	// сумма из скачанных данных для доменов, у которых её нет в ссылке (ReceiptRef.Sum() == 0):
+	// записывается в чек при сохранении данных; nil — сумма берётся из ссылки
+	ParseReceiptSum func(data []byte) (float64, error)
 }
 
 type Provider struct {
@@ -31,23 +45,75 @@ type ReceiptRef interface {
 	Domain() Domain
 	RefText() string
 	UniqueKey() string
+	// FiscalKey — фискальный идентификатор чека: один и тот же для любого провайдера и формата ссылки
+	// одной страны (в отличие от UniqueKey). Пустой, если из ссылки его не узнать.
+	FiscalKey() string
 	CreatedAt() time.Time
 	Sum() float64
 	SearchKeyItems() []string
 }
 
+// RefundableReceiptRef — ссылка, по которой видно, покупка это или возврат
+type RefundableReceiptRef interface {
+	ReceiptRef
+	IsRefund() bool
+}
+
+// ReceiptRefWithData — ссылка, в которой уже есть все данные чека: скачивать нечего,
+// у домена нет клиента (NewClient == nil), данные сохраняются сразу вместе со ссылкой
+type ReceiptRefWithData interface {
+	ReceiptRef
+	ReceiptData() []byte
+}
+
+// ReceiptRefWithCurrency — ссылка со своей валютой (у домена она не одна, Domain.CurrencySymbol пустой)
+type ReceiptRefWithCurrency interface {
+	ReceiptRef
+	CurrencySymbol() string
+}
+
+// ReceiptSummary — то из данных чека, по чему возврат сопоставляется с исходной покупкой
+type ReceiptSummary struct {
+	SellerID  string //ИНН/БИН продавца, пустой — неизвестен
+	ItemNames []string
+}
+
+// JSONString — строковое или числовое значение из JSON в виде строки
+// (ИНН в данных чеков приходит то строкой, то числом, иногда с пробелами)
+type JSONString string
+
+func (s *JSONString) UnmarshalJSON(buf []byte) error {
+	var str string
+	if err := json.Unmarshal(buf, &str); err == nil {
+		*s = JSONString(strings.TrimSpace(str))
+		return nil
+	}
+	var num json.Number
+	if err := json.Unmarshal(buf, &num); err != nil {
+		return merry.Wrap(err)
+	}
+	*s = JSONString(num.String())
+	return nil
+}
+
 type Receipt struct {
-	ID          int64     `json:"id"`
-	Domain      string    `json:"domain"`
-	SavedAt     time.Time `json:"savedAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
-	CreatedAt   time.Time `json:"createdAt"`
-	RefText     string    `json:"refText"`
-	IsCorrect   bool      `json:"isCorrect"`
-	Data        string    `json:"data"`
-	SearchKey   string    `json:"searchKey"`
-	RetriesLeft int64     `json:"retriesLeft"`
-	NextRetryAt time.Time `json:"nextRetryAt"`
+	ID         int64     `json:"id"`
+	Domain     string    `json:"domain"`
+	SavedAt    time.Time `json:"savedAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+	RefText    string    `json:"refText"`
+	IsCorrect  bool      `json:"isCorrect"`
+	IsRefund   bool      `json:"isRefund"`
+	Sum        float64   `json:"sum"`                  //у возвратов отрицательная
+	RefundOfID int64     `json:"refundOfId,omitempty"` //ID исходной покупки возврата (если нашлась)
+	Currency   string    `json:"currency,omitempty"`   //своя валюта чека, пустая — валюта домена
+	Data       string    `json:"data"`
+	// JSON, разобранный из data, если data — HTML-страница (см. Domain.ExtractReceiptData)
+	ExtractedData string    `json:"extractedData,omitempty"`
+	SearchKey     string    `json:"searchKey"`
+	RetriesLeft   int64     `json:"retriesLeft"`
+	NextRetryAt   time.Time `json:"nextRetryAt"`
 }
 
 func ReceiptRefFromText(domains []Domain, refText string) (ReceiptRef, error) {
@@ -59,9 +125,34 @@ func ReceiptRefFromText(domains []Domain, refText string) (ReceiptRef, error) {
 		}
 		errs = append(errs, err)
 	}
+	if len(errs) == 0 {
+		return nil, merry.New("no domains to parse receipt ref")
+	}
 	return nil, errors.Join(errs...)
 }
 
+// IsRefund — является ли ref чеком возврата (false, если домен покупки и возвраты не различает)
+func IsRefund(ref ReceiptRef) bool {
+	r, ok := ref.(RefundableReceiptRef)
+	return ok && r.IsRefund()
+}
+
+// SignedSum — сумма чека: у покупок положительная, у возвратов отрицательная
+func SignedSum(ref ReceiptRef) float64 {
+	if IsRefund(ref) {
+		return -ref.Sum()
+	}
+	return ref.Sum()
+}
+
+// CurrencySymbol — валюта чека: своя у ссылки или общая для домена
+func CurrencySymbol(ref ReceiptRef) string {
+	if r, ok := ref.(ReceiptRefWithCurrency); ok {
+		return r.CurrencySymbol()
+	}
+	return ref.Domain().CurrencySymbol
+}
+
 // CastReceiptRefTo кастует iRef в T
 // (обычно — интерфейс receipts.ReceiptRef в конкретный например ru_fns.ReceiptRef),
 // дереференсит при необходимости.
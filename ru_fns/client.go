@@ -1,17 +1,41 @@
 package ru_fns
 
 import (
+	"context"
+	"os"
 	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/utils"
 	"time"
 
 	"github.com/ansel1/merry"
 	"github.com/rs/zerolog/log"
 )
 
+// пауза между повторными запросами чека, который ещё не дошёл до ФНС
+var notReadyRetryDelay = 2 * time.Second
+
 type Client struct {
+	cfg     receipts.ClientConfig
 	session *Session
 }
 
+// учётные данные берутся из конфига, а если их там нет — из переменных окружения (как раньше)
+func (c *Client) credential(name, envName string) string {
+	if val := c.cfg.Credentials[name]; val != "" {
+		return val
+	}
+	return os.Getenv(envName)
+}
+
+func (c *Client) api() fnsAPI {
+	return fnsAPI{
+		baseURL:       c.cfg.BaseURLOr(defaultBaseURL),
+		httpClient:    c.cfg.HTTPClientOr(utils.DefaultHTTPClient),
+		firebaseToken: c.credential("firebase_token", "RU_FNS_FIREBASE_TOKEN"),
+		deviceID:      c.credential("device_id", "RU_FNS_DEVICE_ID"),
+	}
+}
+
 func (c *Client) Init() error {
 	return nil
 }
@@ -22,7 +46,7 @@ func (c *Client) InitSession(args ...string) error {
 	}
 	refreshToken := args[0]
 	clientSecret := args[1]
-	session, err := initSession(refreshToken, clientSecret)
+	session, err := initSession(context.Background(), c.api(), refreshToken, clientSecret)
 	if err != nil {
 		return merry.Wrap(err)
 	}
@@ -38,10 +62,10 @@ func (c *Client) LoadSession() error {
 
 	// Checking session
 	updateSessionAndPrintProfile := func() error {
-		if err := updateSessionIfOld(session); err != nil {
+		if err := updateSessionIfOld(context.Background(), c.api(), session); err != nil {
 			return err
 		}
-		profile, err := fnsGetProfile(session.SessonID)
+		profile, err := c.api().fnsGetProfile(context.Background(), session.SessonID)
 		if err == nil {
 			log.Info().Str("phone", profile.Phone).Msg("ru-fns: profile")
 		}
@@ -63,7 +87,7 @@ func (c *Client) LoadSession() error {
 	return nil
 }
 
-func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
+func (c *Client) FetchReceipt(ctx context.Context, iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
 	res := receipts.FetchReceiptResult{ShouldDecreaseRetries: false, Data: nil}
 
 	if c.session == nil {
@@ -75,12 +99,12 @@ func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error
 		return res, err
 	}
 
-	if err := updateSessionIfOld(c.session); err != nil {
+	if err := updateSessionIfOld(ctx, c.api(), c.session); err != nil {
 		return res, merry.Wrap(err)
 	}
 
 	for iter := 0; ; iter++ {
-		data, err := fnsFetchReceipt(ref.RefText(), c.session.SessonID)
+		data, err := c.api().fnsFetchReceipt(ctx, ref.RefText(), c.session.SessonID)
 
 		// isCorrect flag
 		if merry.Is(err, ErrReceiptMaybeNotReadyYet) {
@@ -102,7 +126,9 @@ func (c *Client) FetchReceipt(iRef receipts.ReceiptRef, onIsCorrect func() error
 		// sleep + loop
 		if merry.Is(err, ErrWaitingForConnection) || merry.Is(err, ErrCashboxOffline) || merry.Is(err, ErrReceiptMaybeNotReadyYet) {
 			log.Info().Int("iter", iter+1).Msg("receipt seems not checked to FNS, waiting a bit more")
-			time.Sleep(2 * time.Second)
+			if err := utils.SleepCtx(ctx, notReadyRetryDelay); err != nil {
+				return res, err
+			}
 		}
 	}
 }
@@ -0,0 +1,59 @@
+package ru_fns
+
+import (
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
+	"testing"
+	"time"
+)
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("t=20230808T1011&s=123.45&fn=9999078900012345&i=1234&fp=1234567890&n=1")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	prevDelay := notReadyRetryDelay
+	notReadyRetryDelay = 0
+	t.Cleanup(func() { notReadyRetryDelay = prevDelay })
+
+	newClient := func(cfg receipts.ClientConfig) receipts.Client {
+		// свежая сессия, чтоб клиент не пытался её обновить
+		return &Client{cfg: cfg, session: &Session{SessonID: "test-session", UpdatedAt: time.Now()}}
+	}
+
+	addRoute := "POST /v2/ticket"
+	getRoute := "GET /v2/tickets/64d1e2f3a4b5c6d7e8f90123"
+	testutils.RunFetchCases(t, newClient, ref, []testutils.FetchCase{
+		{
+			Name: "success",
+			Routes: map[string]testutils.Fixture{
+				addRoute: testutils.JSONFixture(200, "testdata/ticket_add.json"),
+				getRoute: testutils.JSONFixture(200, "testdata/ticket.json"),
+			},
+		},
+		{
+			Name: "not ready",
+			Routes: map[string]testutils.Fixture{
+				addRoute: testutils.JSONFixture(200, "testdata/ticket_add_not_ready.json"),
+			},
+			WantErr:       ErrReceiptMaybeNotReadyYet,
+			WantIsCorrect: true,
+		},
+		{
+			Name: "too many requests",
+			Routes: map[string]testutils.Fixture{
+				addRoute: testutils.JSONFixture(429, "testdata/too_many_requests.json"),
+			},
+			WantErr: ErrToManyRequests,
+		},
+		{
+			Name: "no ticket in data",
+			Routes: map[string]testutils.Fixture{
+				addRoute: testutils.JSONFixture(200, "testdata/ticket_add.json"),
+				getRoute: testutils.JSONFixture(200, "testdata/ticket_without_data.json"),
+			},
+			WantErr: ErrNoReceiptData,
+		},
+	})
+}
@@ -3,11 +3,11 @@ package ru_fns
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"io"
 	"math/rand"
 	"net/http"
-	"os"
 	"receipt_qr_scanner/receipts"
 	"strconv"
 
@@ -57,20 +57,30 @@ type ProfileResponse struct {
 	Surname string `json:"surname"`
 }
 
-func newGetRequest(url string) (*http.Request, error) {
-	req, err := http.NewRequest("GET", "https://irkkt-mobile.nalog.ru:8888"+url, nil)
+const defaultBaseURL = "https://irkkt-mobile.nalog.ru:8888"
+
+// fnsAPI — адрес АПИ, HTTP-клиент и идентификаторы устройства, с которыми идут все запросы к ФНС
+type fnsAPI struct {
+	baseURL       string
+	httpClient    *http.Client
+	firebaseToken string
+	deviceID      string
+}
+
+func (a fnsAPI) newGetRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+url, nil)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
 	return req, nil
 }
 
-func newPostRequest(url string, body interface{}) (*http.Request, error) {
+func (a fnsAPI) newPostRequest(ctx context.Context, url string, body interface{}) (*http.Request, error) {
 	bodyBuf, err := json.Marshal(body)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	req, err := http.NewRequest("POST", "https://irkkt-mobile.nalog.ru:8888"+url, bytes.NewBuffer(bodyBuf))
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+url, bytes.NewBuffer(bodyBuf))
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
@@ -79,15 +89,15 @@ func newPostRequest(url string, body interface{}) (*http.Request, error) {
 	return req, nil
 }
 
-func addCommonHeaders(req *http.Request, useFirebaseTokenForDeviceID bool) {
+func (a fnsAPI) addCommonHeaders(req *http.Request, useFirebaseTokenForDeviceID bool) {
 	var deviceID string
 	if useFirebaseTokenForDeviceID {
-		deviceID = os.Getenv("RU_FNS_FIREBASE_TOKEN")
+		deviceID = a.firebaseToken
 		if deviceID == "" {
 			deviceID = "noFirebaseToken" //так было в приложении
 		}
 	} else {
-		deviceID = os.Getenv("RU_FNS_DEVICE_ID")
+		deviceID = a.deviceID
 		if deviceID == "" {
 			deviceID = strconv.FormatUint(rand.Uint64()|(1<<63), 16)
 		}
@@ -107,8 +117,8 @@ func addSessionIDHeader(req *http.Request, sessionID string) {
 	req.Header["sessionId"] = []string{sessionID}
 }
 
-func sendRequestAndRead(req *http.Request) ([]byte, error) {
-	resp, err := http.DefaultClient.Do(req)
+func (a fnsAPI) sendRequestAndRead(req *http.Request) ([]byte, error) {
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
@@ -141,8 +151,8 @@ func sendRequestAndRead(req *http.Request) ([]byte, error) {
 	return buf, nil
 }
 
-func sendRequestAndReadTo(req *http.Request, dest interface{}) error {
-	buf, err := sendRequestAndRead(req)
+func (a fnsAPI) sendRequestAndReadTo(req *http.Request, dest interface{}) error {
+	buf, err := a.sendRequestAndRead(req)
 	if err != nil {
 		return merry.Wrap(err)
 	}
@@ -152,7 +162,7 @@ func sendRequestAndReadTo(req *http.Request, dest interface{}) error {
 	return nil
 }
 
-func fnsRefreshSession(refreshToken, clientSecret string) (*RefreshSessionResponse, error) {
+func (a fnsAPI) fnsRefreshSession(ctx context.Context, refreshToken, clientSecret string) (*RefreshSessionResponse, error) {
 	// Accept-Encoding: gzip
 	// ClientVersion: 2.27.3
 	// Connection: Keep-Alive
@@ -163,20 +173,20 @@ func fnsRefreshSession(refreshToken, clientSecret string) (*RefreshSessionRespon
 	// Host: irkkt-mobile.nalog.ru:8888
 	// User-Agent: okhttp/5.0.0-alpha.2
 	params := map[string]string{"client_secret": clientSecret, "refresh_token": refreshToken}
-	req, err := newPostRequest("/v2/mobile/users/refresh", params)
+	req, err := a.newPostRequest(ctx, "/v2/mobile/users/refresh", params)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	addCommonHeaders(req, true)
+	a.addCommonHeaders(req, true)
 
 	sessUpdate := &RefreshSessionResponse{}
-	if err := sendRequestAndReadTo(req, sessUpdate); err != nil {
+	if err := a.sendRequestAndReadTo(req, sessUpdate); err != nil {
 		return nil, merry.Wrap(err)
 	}
 	return sessUpdate, nil
 }
 
-func fnsGetProfile(sessionID string) (*ProfileResponse, error) {
+func (a fnsAPI) fnsGetProfile(ctx context.Context, sessionID string) (*ProfileResponse, error) {
 	// Accept-Encoding: gzip
 	// ClientVersion: 2.27.3
 	// Connection: Keep-Alive
@@ -185,21 +195,21 @@ func fnsGetProfile(sessionID string) (*ProfileResponse, error) {
 	// Host: irkkt-mobile.nalog.ru:8888
 	// sessionId: 009988776655443322110099:11223344-1122-4xxx-axxx-112233445566
 	// User-Agent: okhttp/5.0.0-alpha.2
-	req, err := newGetRequest("/v2/mobile/user/profile")
+	req, err := a.newGetRequest(ctx, "/v2/mobile/user/profile")
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	addCommonHeaders(req, false)
+	a.addCommonHeaders(req, false)
 	addSessionIDHeader(req, sessionID)
 
 	profile := &ProfileResponse{}
-	if err := sendRequestAndReadTo(req, profile); err != nil {
+	if err := a.sendRequestAndReadTo(req, profile); err != nil {
 		return nil, merry.Wrap(err)
 	}
 	return profile, nil
 }
 
-func fnsAddReceipt(refQRText, sessionID string) (*ReceiptInfoResponse, error) {
+func (a fnsAPI) fnsAddReceipt(ctx context.Context, refQRText, sessionID string) (*ReceiptInfoResponse, error) {
 	// Accept-Encoding: gzip
 	// ClientVersion: 2.27.3
 	// Connection: Keep-Alive
@@ -211,15 +221,15 @@ func fnsAddReceipt(refQRText, sessionID string) (*ReceiptInfoResponse, error) {
 	// sessionId: 009988776655443322110099:11223344-1122-4xxx-axxx-112233445566
 	// User-Agent: okhttp/5.0.0-alpha.2
 	params := map[string]string{"qr": refQRText}
-	req, err := newPostRequest("/v2/ticket", params)
+	req, err := a.newPostRequest(ctx, "/v2/ticket", params)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	addCommonHeaders(req, false)
+	a.addCommonHeaders(req, false)
 	addSessionIDHeader(req, sessionID)
 
 	recResp := &ReceiptInfoResponse{}
-	if err := sendRequestAndReadTo(req, recResp); err != nil {
+	if err := a.sendRequestAndReadTo(req, recResp); err != nil {
 		return nil, merry.Wrap(err)
 	}
 	statusErr, ok := ErrByStatus[recResp.Status]
@@ -229,7 +239,7 @@ func fnsAddReceipt(refQRText, sessionID string) (*ReceiptInfoResponse, error) {
 	return recResp, nil
 }
 
-func fnsGetReceiptData(receiptID, sessionID string) ([]byte, error) {
+func (a fnsAPI) fnsGetReceiptData(ctx context.Context, receiptID, sessionID string) ([]byte, error) {
 	// Accept-Encoding: gzip
 	// ClientVersion: 2.27.3
 	// Connection: Keep-Alive
@@ -238,14 +248,14 @@ func fnsGetReceiptData(receiptID, sessionID string) ([]byte, error) {
 	// Host: irkkt-mobile.nalog.ru:8888
 	// sessionId: 009988776655443322110099:11223344-1122-4xxx-axxx-112233445566
 	// User-Agent: okhttp/5.0.0-alpha.2
-	req, err := newGetRequest("/v2/tickets/" + receiptID)
+	req, err := a.newGetRequest(ctx, "/v2/tickets/"+receiptID)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	addCommonHeaders(req, false)
+	a.addCommonHeaders(req, false)
 	addSessionIDHeader(req, sessionID)
 
-	buf, err := sendRequestAndRead(req)
+	buf, err := a.sendRequestAndRead(req)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
@@ -260,10 +270,10 @@ func fnsGetReceiptData(receiptID, sessionID string) ([]byte, error) {
 	return buf, nil
 }
 
-func fnsFetchReceipt(refQRText, sessionID string) ([]byte, error) {
-	rec, err := fnsAddReceipt(refQRText, sessionID)
+func (a fnsAPI) fnsFetchReceipt(ctx context.Context, refQRText, sessionID string) ([]byte, error) {
+	rec, err := a.fnsAddReceipt(ctx, refQRText, sessionID)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	return fnsGetReceiptData(rec.ID, sessionID)
+	return a.fnsGetReceiptData(ctx, rec.ID, sessionID)
 }
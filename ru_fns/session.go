@@ -1,6 +1,7 @@
 package ru_fns
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"os"
@@ -68,8 +69,8 @@ func readSession(session *Session) error {
 	return merry.Wrap(json.NewDecoder(file).Decode(session))
 }
 
-func updateSession(session *Session) error {
-	sessUpd, err := fnsRefreshSession(session.RefreshToken, session.ClientSecret)
+func updateSession(ctx context.Context, api fnsAPI, session *Session) error {
+	sessUpd, err := api.fnsRefreshSession(ctx, session.RefreshToken, session.ClientSecret)
 	if err != nil {
 		return merry.Wrap(err)
 	}
@@ -79,16 +80,16 @@ func updateSession(session *Session) error {
 	return merry.Wrap(writeSession(session))
 }
 
-func updateSessionIfOld(session *Session) error {
+func updateSessionIfOld(ctx context.Context, api fnsAPI, session *Session) error {
 	if time.Since(session.UpdatedAt) > 10*time.Minute {
-		return merry.Wrap(updateSession(session))
+		return merry.Wrap(updateSession(ctx, api, session))
 	}
 	return nil
 }
 
-func initSession(refreshToken, clientSecret string) (*Session, error) {
+func initSession(ctx context.Context, api fnsAPI, refreshToken, clientSecret string) (*Session, error) {
 	session := &Session{RefreshToken: refreshToken, ClientSecret: clientSecret}
-	if err := updateSession(session); err != nil {
+	if err := updateSession(ctx, api, session); err != nil {
 		return nil, merry.Wrap(err)
 	}
 	return session, nil
@@ -1,6 +1,7 @@
 package ru_fns
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"receipt_qr_scanner/receipts"
@@ -24,9 +25,11 @@ var Domain = receipts.Domain{
 	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
 		return NewReceiptRef(refText)
 	},
-	NewClient: func() receipts.Client {
-		return &Client{}
+	NewClient: func(cfg receipts.ClientConfig) receipts.Client {
+		return &Client{cfg: cfg}
 	},
+	CredentialNames:     []string{"firebase_token", "device_id"},
+	ParseReceiptSummary: parseReceiptSummary,
 }
 
 func NewReceiptRef(refText string) (ReceiptRef, error) {
@@ -81,6 +84,13 @@ func (r ReceiptRef) UniqueKey() string {
 	return r.Domain().Code + ":" + strings.Join(items, "&")
 }
 
+// FiscalKey — номер ФН, номер ФД и ФП (числами, так что форматы с нулями и без совпадают)
+func (r ReceiptRef) FiscalKey() string {
+	d := r.data
+	return "ru:" + strconv.FormatInt(d.FiscalDriveNumber, 10) + ":" +
+		strconv.FormatInt(d.FiscalDocumentNumber, 10) + ":" + strconv.FormatInt(d.FiscalDocumentSign, 10)
+}
+
 func (r ReceiptRef) CreatedAt() time.Time {
 	return r.data.CreatedAt
 }
@@ -89,6 +99,11 @@ func (r ReceiptRef) Sum() float64 {
 	return r.data.Sum
 }
 
+// IsRefund — возврат прихода или возврат расхода
+func (r ReceiptRef) IsRefund() bool {
+	return r.data.Kind == 2 || r.data.Kind == 4
+}
+
 func (r ReceiptRef) SearchKeyItems() []string {
 	return []string{
 		"_created_at:" + r.data.CreatedAt.Format("2006-01-02 15:04"),
@@ -108,6 +123,11 @@ func parseRefText(refText string) (*ReceiptRefData, error) {
 
 	// https://www.consultant.ru/document/cons_doc_LAW_214339/6111d2e938c6fe4df089424ce2fe9ef428678b1d/
 
+	// без ФН и ФП это не ссылка ФНС вовсе (а не ссылка с ошибкой в поле, см. ReceiptRefFieldErr)
+	if !values.Has("fn") && !values.Has("fp") {
+		return nil, merry.New("not a receipt ref: fn and fp are missing")
+	}
+
 	var data ReceiptRefData
 	// заводской номер фискального накопителя
 	data.FiscalDriveNumber, err = receipts.ReadInt64(values, "fn")
@@ -141,3 +161,36 @@ func parseRefText(refText string) (*ReceiptRefData, error) {
 	}
 	return &data, err
 }
+
+type receiptDataReceipt struct {
+	UserInn receipts.JSONString `json:"userInn"`
+	Items   []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+}
+
+// parseReceiptSummary: чек лежит в ticket.document.receipt (или в document.receipt в старом формате)
+func parseReceiptSummary(data []byte) (receipts.ReceiptSummary, error) {
+	var d struct {
+		Ticket *struct {
+			Document struct {
+				Receipt receiptDataReceipt `json:"receipt"`
+			} `json:"document"`
+		} `json:"ticket"`
+		Document struct {
+			Receipt receiptDataReceipt `json:"receipt"`
+		} `json:"document"`
+	}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return receipts.ReceiptSummary{}, merry.Wrap(err)
+	}
+	rec := d.Document.Receipt
+	if d.Ticket != nil {
+		rec = d.Ticket.Document.Receipt
+	}
+	summary := receipts.ReceiptSummary{SellerID: string(rec.UserInn)}
+	for _, item := range rec.Items {
+		summary.ItemNames = append(summary.ItemNames, item.Name)
+	}
+	return summary, nil
+}
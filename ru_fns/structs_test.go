@@ -0,0 +1,58 @@
+package ru_fns
+
+import (
+	"errors"
+	"os"
+	"receipt_qr_scanner/receipts"
+	"slices"
+	"testing"
+)
+
+func TestReceiptRefIsRefund(t *testing.T) {
+	for kind, want := range map[string]bool{"1": false, "2": true, "3": false, "4": true} {
+		ref, err := NewReceiptRef("t=20230808T1011&s=123.45&fn=9999078900012345&i=1234&fp=1234567890&n=" + kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.IsRefund() != want {
+			t.Errorf("n=%s: IsRefund() = %v, want %v", kind, ref.IsRefund(), want)
+		}
+	}
+}
+
+func TestNewReceiptRefErrors(t *testing.T) {
+	// чужой текст — не ошибка в поле (такие сохраняются в unrecognized_refs)
+	for _, refText := range []string{"hello", "https://app.kassa.wipon.kz/consumer?i=1&f=2&s=3.00&t=20260310T093045"} {
+		_, err := NewReceiptRef(refText)
+		if err == nil || errors.As(err, &receipts.ReceiptRefFieldErr{}) {
+			t.Errorf("NewReceiptRef(%q) error = %v, want not a field error", refText, err)
+		}
+	}
+	_, err := NewReceiptRef("t=20230808T1011&s=abc&fn=9999078900012345&i=1234&fp=1234567890&n=1")
+	if fErr := (receipts.ReceiptRefFieldErr{}); !errors.As(err, &fErr) || fErr.Name != "s" {
+		t.Errorf("NewReceiptRef(s=abc) error = %v, want wrong s", err)
+	}
+}
+
+func TestParseReceiptSummary(t *testing.T) {
+	data, err := os.ReadFile("testdata/ticket.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := parseReceiptSummary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.SellerID != "7701234567" || !slices.Equal(summary.ItemNames, []string{"Батон нарезной", "Кефир 1%"}) {
+		t.Errorf("parseReceiptSummary() = %#v", summary)
+	}
+
+	// старый формат, без ticket
+	summary, err = parseReceiptSummary([]byte(`{"document":{"receipt":{"userInn":7701234567,"items":[{"name":"Хлеб"}]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.SellerID != "7701234567" || !slices.Equal(summary.ItemNames, []string{"Хлеб"}) {
+		t.Errorf("parseReceiptSummary(v1) = %#v", summary)
+	}
+}
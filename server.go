@@ -9,10 +9,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"receipt_qr_scanner/receipts"
-	"receipt_qr_scanner/utils"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,9 +28,14 @@ import (
 type ctxKey string
 
 const CtxKeyEnv = ctxKey("env")
+const CtxKeyConfig = ctxKey("config")
+const CtxKeyHealth = ctxKey("health")
+const CtxKeyBreaker = ctxKey("breaker")
+const CtxKeyEvents = ctxKey("events")
 const CtxKeyDB = ctxKey("db")
 const CtxKeyTrigger = ctxKey("trigger")
 const CtxKeyUpdateRec = ctxKey("updateRec")
+const CtxKeyClients = ctxKey("clients")
 
 func HandleIndex(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (httputils.TemplateCtx, error) {
 	return map[string]interface{}{"FPath": "index.html", "Block": "index.html"}, nil
@@ -40,23 +46,37 @@ func HandleAPIReceipt(wr http.ResponseWriter, r *http.Request, ps httprouter.Par
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	text := string(buf)
+	return submitReceiptRefText(r.Context(), string(buf))
+}
+
+// ReceiptSubmitResult — ответ на добавление чека
+type ReceiptSubmitResult struct {
+	ID         int64     `json:"id"`
+	Exists     bool      `json:"exists"` //такой чек уже был добавлен раньше
+	DomainCode string    `json:"domainCode"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Sum        float64   `json:"sum"`
+}
+
+// submitReceiptRefText сохраняет новый чек по тексту из QR-кода (значения берутся из контекста запроса).
+// Возвращает httputils.JsonOk (с ReceiptSubmitResult) или *httputils.JsonError для ошибок в самом тексте.
+func submitReceiptRefText(ctx context.Context, text string) (interface{}, error) {
 	log.Debug().Str("text", text).Msg("receipt ref text")
 
+	db := ctx.Value(CtxKeyDB).(*sql.DB)
+
 	ref, err := receipts.ReceiptRefFromText(allDomains, text)
-	var fErr receipts.ReceiptRefFieldErr
-	if errors.As(err, &fErr) {
-		prefix := "WRONG_VALUE_"
-		if fErr.IsMissing {
-			prefix = "MISSING_VALUE_"
-		}
-		return &httputils.JsonError{
-			Code:        400,
-			Error:       prefix + strings.ToUpper(fErr.Name),
-			Description: fErr.ValueStr,
-		}, nil
+	if jsonErr := receiptRefFieldJsonError(err); jsonErr != nil {
+		// ссылку узнал один из доменов, но в ней ошибка — новый домен такую не распознает, не сохраняем
+		return jsonErr, nil
 	} else if err != nil {
 		log.Warn().Err(err).Msg("ref text parse")
+		if strings.TrimSpace(text) != "" {
+			// сохраняем, чтоб добавить чек, когда появится поддержка (см. promoteUnrecognizedRefs)
+			if err := saveUnrecognizedRef(db, text, err); err != nil {
+				log.Error().Stack().Err(err).Msg("unrecognized ref saving failed")
+			}
+		}
 		return &httputils.JsonError{
 			Code:        400,
 			Error:       "WRONG_REF",
@@ -64,39 +84,70 @@ func HandleAPIReceipt(wr http.ResponseWriter, r *http.Request, ps httprouter.Par
 		}, nil
 	}
 
-	refResponse := map[string]interface{}{
-		"exists":     false,
-		"domainCode": ref.Domain().Code,
-		"createdAt":  ref.CreatedAt(),
-		"sum":        ref.Sum(),
+	refResponse := ReceiptSubmitResult{
+		DomainCode: ref.Domain().Code,
+		CreatedAt:  ref.CreatedAt(),
+		Sum:        ref.Sum(),
 	}
 
-	db := r.Context().Value(CtxKeyDB).(*sql.DB)
-	recID, err := saveRecieptRef(db, ref)
+	cfg := ctx.Value(CtxKeyConfig).(*Config)
+	recID, err := saveRecieptRef(db, ref, cfg.ReceiptRetries(ref.Domain().Code))
 	if merry.Is(err, ErrReceiptRefAlreadyExists) {
-		refResponse["exists"] = true
+		refResponse.ID = recID
+		refResponse.Exists = true
 		return httputils.JsonOk{Ok: true, Result: refResponse}, nil
 	} else if err != nil {
 		return nil, merry.Wrap(err)
 	}
+	refResponse.ID = recID
 
-	updaterTriggerChan := r.Context().Value(CtxKeyTrigger).(chan struct{})
-	updaterTriggerChan <- struct{}{}
-	updatedReceiptIDsChan := r.Context().Value(CtxKeyUpdateRec).(chan int64)
+	updaterTriggerChan := ctx.Value(CtxKeyTrigger).(chan struct{})
+	select {
+	case updaterTriggerChan <- struct{}{}:
+	default: //апдейтер и так уже будет запущен (или остановлен)
+	}
+	updatedReceiptIDsChan := ctx.Value(CtxKeyUpdateRec).(chan int64)
 	updatedReceiptIDsChan <- recID
 
+	events := ctx.Value(CtxKeyEvents).(ReceiptEvents)
+	if err := events.Emit(EventReceiptAdded, recID); err != nil {
+		// чек уже сохранён, ошибка отправки события не должна выглядеть как ошибка сохранения
+		log.Error().Stack().Err(err).Int64("id", recID).Msg("receipt event enqueue failed")
+	}
+
 	return httputils.JsonOk{Ok: true, Result: refResponse}, nil
 }
 
-func HandleAPIDomainsMetadata(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
-	type DomainMetadata struct {
-		DomainCode         string `json:"domainCode"`
-		CurrencySymbol     string `json:"currencySymbol"`
-		FlagSymbol         string `json:"flagSymbol"`
-		ProviderName       string `json:"providerName"`
-		ProviderShortLabel string `json:"providerShortLabel"`
-		ProviderColor      string `json:"providerColor"`
+// receiptRefFieldJsonError — ответ 400 для ошибки в поле ссылки (nil, если ошибка не такая)
+func receiptRefFieldJsonError(err error) *httputils.JsonError {
+	var fErr receipts.ReceiptRefFieldErr
+	if !errors.As(err, &fErr) {
+		return nil
+	}
+	prefix := "WRONG_VALUE_"
+	if fErr.IsMissing {
+		prefix = "MISSING_VALUE_"
+	}
+	return &httputils.JsonError{
+		Code:        400,
+		Error:       prefix + strings.ToUpper(fErr.Name),
+		Description: fErr.ValueStr,
 	}
+}
+
+type DomainMetadata struct {
+	DomainCode         string `json:"domainCode"`
+	CurrencySymbol     string `json:"currencySymbol"`
+	FlagSymbol         string `json:"flagSymbol"`
+	ProviderName       string `json:"providerName"`
+	ProviderShortLabel string `json:"providerShortLabel"`
+	ProviderColor      string `json:"providerColor"`
+	// пауза при сломанном АПИ провайдера, см. DomainBreaker
+	CircuitBreaker BreakerState `json:"circuitBreaker"`
+}
+
+func HandleAPIDomainsMetadata(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	breaker := r.Context().Value(CtxKeyBreaker).(*DomainBreaker)
 
 	domains := make([]DomainMetadata, len(allDomains))
 	for i, d := range allDomains {
@@ -107,56 +158,103 @@ func HandleAPIDomainsMetadata(wr http.ResponseWriter, r *http.Request, ps httpro
 			ProviderName:       d.Provider.Name,
 			ProviderShortLabel: d.Provider.ShortLabel,
 			ProviderColor:      d.Provider.Color,
+			CircuitBreaker:     breaker.State(d.Code),
 		}
 	}
 	return domains, nil
 }
 
-type ReceiptsBroadcaster struct {
-	InReceiptsChan chan *receipts.Receipt
-	clients        map[chan *receipts.Receipt]struct{}
-	mutex          sync.RWMutex
+type HealthReport struct {
+	OK         bool              `json:"ok"`
+	Components []ComponentStatus `json:"components"`
 }
 
-func NewReceiptsBroadcaster() *ReceiptsBroadcaster {
-	b := &ReceiptsBroadcaster{
-		InReceiptsChan: make(chan *receipts.Receipt, 10),
-		clients:        make(map[chan *receipts.Receipt]struct{}),
-	}
-	go func() {
-		for rec := range b.InReceiptsChan {
-			b.broadcast(rec)
-		}
-	}()
-	return b
+func HandleAPIHealth(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
+	health := r.Context().Value(CtxKeyHealth).(*Health)
+	return HealthReport{OK: health.IsOK(), Components: health.Statuses()}, nil
 }
 
-func (b *ReceiptsBroadcaster) AddClient() chan *receipts.Receipt {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
-	client := make(chan *receipts.Receipt, 10)
-	b.clients[client] = struct{}{}
-	return client
+// HandleHealthz — процесс жив и отвечает на запросы
+func HandleHealthz(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err := wr.Write([]byte("ok\n"))
+	return merry.Wrap(err)
 }
 
-func (b *ReceiptsBroadcaster) RemoveClient(client chan *receipts.Receipt) {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
-	if _, ok := b.clients[client]; ok {
-		close(client)
-		delete(b.clients, client)
+// HandleReadyz — БД доступна и фоновые компоненты работают, иначе 503
+func HandleReadyz(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+	health := r.Context().Value(CtxKeyHealth).(*Health)
+
+	var problems []string
+	if err := db.PingContext(r.Context()); err != nil {
+		problems = append(problems, "db: "+err.Error())
 	}
+	for _, c := range health.Statuses() {
+		if !c.IsOK {
+			problems = append(problems, c.Name+": "+c.LastError)
+		}
+	}
+
+	wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(problems) > 0 {
+		wr.WriteHeader(http.StatusServiceUnavailable)
+		_, err := wr.Write([]byte(strings.Join(problems, "\n") + "\n"))
+		return merry.Wrap(err)
+	}
+	_, err := wr.Write([]byte("ok\n"))
+	return merry.Wrap(err)
 }
 
-func (b *ReceiptsBroadcaster) broadcast(rec *receipts.Receipt) {
-	b.mutex.RLock()
-	defer b.mutex.RUnlock()
-	for client := range b.clients {
-		client <- rec
+func (b *ReceiptsBroadcaster) HandleMetrics(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+	cfg := r.Context().Value(CtxKeyConfig).(*Config)
+	health := r.Context().Value(CtxKeyHealth).(*Health)
+	breaker := r.Context().Value(CtxKeyBreaker).(*DomainBreaker)
+
+	snap := MetricsSnapshot{
+		SSEClients:        b.ClientsCount(),
+		ComponentStatuses: health.Statuses(),
+		DomainsPaused:     map[string]bool{},
+	}
+
+	var err error
+	snap.ReceiptCounts, err = loadReceiptCounts(db)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	var enabledCodes []string
+	var pendingCount int64
+	for _, d := range cfg.EnabledDomains(allDomains) {
+		enabledCodes = append(enabledCodes, d.Code)
+		snap.DomainsPaused[d.Code] = breaker.State(d.Code).State != BreakerClosed
+	}
+	for _, c := range snap.ReceiptCounts {
+		if slices.Contains(enabledCodes, c.Domain) {
+			pendingCount += c.Pending
+		}
 	}
+	if pendingCount > 0 {
+		nextRetryAt, err := loadNextRetryTime(db, enabledCodes)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		nextRetryIn := time.Until(nextRetryAt)
+		snap.NextRetryIn = &nextRetryIn
+	}
+
+	wr.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	return merry.Wrap(metrics.WritePrometheus(wr, snap))
 }
 
-func writeSseJson(wr io.Writer, name string, obj interface{}) error {
+// writeSseJson пишет SSE-событие, id (если не пустой) клиент пришлёт в Last-Event-ID при переподключении
+func writeSseJson(wr io.Writer, id, name string, obj interface{}) error {
+	if id != "" {
+		if _, err := wr.Write([]byte("id: " + id + "\n")); err != nil {
+			return merry.Wrap(err)
+		}
+	}
 	if _, err := wr.Write([]byte("event: " + name + "\ndata: ")); err != nil {
 		return merry.Wrap(err)
 	}
@@ -188,10 +286,6 @@ func (w *gzipResponseWriter) Write(p []byte) (int, error) {
 	return w.gz.Write(p)
 }
 
-func (w *gzipResponseWriter) CloseNotify() <-chan bool {
-	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
-}
-
 func (w *gzipResponseWriter) Flush() {
 	w.gz.Flush() //TODO: error is ignored here, since it (looks like) should also be returned from Write()
 	w.ResponseWriter.(http.Flusher).Flush()
@@ -225,24 +319,106 @@ func ensureRecsNotNil(receipts []*receipts.Receipt) []*receipts.Receipt {
 	return receipts
 }
 
+// receiptsListQueryFromURL разбирает параметры страницы списка чеков:
+// sort_mode, order (asc/desc), search, cursor (X-Next-Cursor прошлой страницы) и limit
+func receiptsListQueryFromURL(query url.Values) (ReceiptsListQuery, *httputils.JsonError) {
+	q := ReceiptsListQuery{
+		SortMode: query.Get("sort_mode"),
+		Search:   query.Get("search"),
+		Limit:    receiptsPageDefaultSize,
+	}
+	if q.SortMode == "" {
+		q.SortMode = "id"
+	}
+	if _, ok := receiptsSortModes[q.SortMode]; !ok {
+		return q, &httputils.JsonError{Code: 400, Error: "WRONG_SORT_MODE"}
+	}
+
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Asc = true
+	default:
+		return q, &httputils.JsonError{Code: 400, Error: "WRONG_ORDER"}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return q, &httputils.JsonError{Code: 400, Error: "WRONG_LIMIT"}
+		}
+		q.Limit = min(limit, receiptsPageMaxSize)
+	}
+
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := DecodeReceiptsCursor(cursorStr)
+		if err != nil || cursor.SortMode != q.SortMode || cursor.Asc != q.Asc {
+			return q, &httputils.JsonError{Code: 400, Error: "WRONG_CURSOR"}
+		}
+		q.Cursor = cursor
+	}
+	return q, nil
+}
+
 func (b *ReceiptsBroadcaster) HandleAPIReceiptsList(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
 	db := r.Context().Value(CtxKeyDB).(*sql.DB)
 	query := r.URL.Query()
 
-	sortMode := query.Get("sort_mode")
-	if sortMode == "" {
-		sortMode = "id"
+	listQuery, jsonErr := receiptsListQueryFromURL(query)
+	if jsonErr != nil {
+		return *jsonErr, nil
 	}
-	if sortMode != "id" && sortMode != "created_at" {
+	sortMode := listQuery.SortMode
+	searchQuery := listQuery.Search
+	// before_id и before_time — старая постраничность (без курсора, только по убыванию)
+	isLegacyPage := query.Has("before_id") || query.Has("before_time")
+	if isLegacyPage && (listQuery.Cursor != nil || listQuery.Asc || (sortMode != "id" && sortMode != "created_at")) {
 		return httputils.JsonError{Code: 400, Error: "WRONG_SORT_MODE"}, nil
 	}
 
-	searchQuery := query.Get("search")
+	startSSE := query.Get("sse")
+	isSSE := startSSE != "" && startSSE != "0"
+	flusher, ok := wr.(http.Flusher)
+	if isSSE && !ok {
+		log.Debug().Msg("SSE: flushing not available, aborting")
+		isSSE = false
+	}
+
+	// подписка — до загрузки списка, чтоб не пропустить обновления между ними.
+	// Переподключившийся клиент (Last-Event-ID) получает только пропущенные события.
+	var sub *Subscriber
+	isResumed := false
+	if isSSE {
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = query.Get("last_event_id")
+		}
+		sub, isResumed = b.Subscribe(SubscriptionFilter{Receipts: true, Search: searchQuery}, lastEventID)
+		defer b.Unsubscribe(sub)
+	}
 
 	var err error
 	var receiptsList []*receipts.Receipt
-	switch sortMode {
-	case "id":
+	switch {
+	case !isLegacyPage:
+		if !isResumed {
+			var nextCursor *ReceiptsCursor
+			receiptsList, nextCursor, err = loadReceiptsPage(db, listQuery)
+			if err != nil {
+				return nil, merry.Wrap(err)
+			}
+			if nextCursor != nil {
+				wr.Header().Set("X-Next-Cursor", nextCursor.Encode())
+			}
+		}
+		if countStr := query.Get("count"); countStr != "" && countStr != "0" {
+			count, err := countReceipts(db, searchQuery)
+			if err != nil {
+				return nil, merry.Wrap(err)
+			}
+			wr.Header().Set("X-Total-Count", strconv.FormatInt(count, 10))
+		}
+	case sortMode == "id":
 		beforeIDStr := query.Get("before_id")
 		beforeID := int64(0)
 		if beforeIDStr != "" {
@@ -251,8 +427,10 @@ func (b *ReceiptsBroadcaster) HandleAPIReceiptsList(wr http.ResponseWriter, r *h
 				return httputils.JsonError{Code: 400, Error: "WRONG_NUMBER_FORMAT"}, nil
 			}
 		}
-		receiptsList, err = loadReceiptsSortedByID(db, beforeID, searchQuery)
-	case "created_at":
+		if !isResumed {
+			receiptsList, err = loadReceiptsSortedByID(db, beforeID, searchQuery)
+		}
+	case sortMode == "created_at":
 		beforeTimeStr := query.Get("before_time")
 		beforeTime := time.Time{}
 		if beforeTimeStr != "" {
@@ -261,85 +439,75 @@ func (b *ReceiptsBroadcaster) HandleAPIReceiptsList(wr http.ResponseWriter, r *h
 				return httputils.JsonError{Code: 400, Error: "WRONG_TIME_FORMAT"}, nil
 			}
 		}
-		receiptsList, err = loadReceiptsSortedByCreatedAt(db, beforeTime, searchQuery)
+		if !isResumed {
+			receiptsList, err = loadReceiptsSortedByCreatedAt(db, beforeTime, searchQuery)
+		}
 	}
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
 
-	startSSE := query.Get("sse")
-	if startSSE == "" || startSSE == "0" {
-		return ensureRecsNotNil(receiptsList), nil
-	}
-
-	flusher, ok := wr.(http.Flusher)
-	if !ok {
-		log.Debug().Msg("SSE: flushing not available, aborting")
+	if !isSSE {
 		return ensureRecsNotNil(receiptsList), nil
 	}
-	log.Debug().Msg("SSE: starting loop")
-
-	recsChan := b.AddClient()
-	defer b.RemoveClient(recsChan)
-
-	closeNotify := wr.(http.CloseNotifier).CloseNotify()
+	log.Debug().Bool("resumed", isResumed).Msg("SSE: starting loop")
 
 	wr.Header().Set("Content-Type", "text/event-stream")
 	wr.Header().Set("X-Accel-Buffering", "no") //disabling Nginx buffering
 
-	if receiptsList == nil {
-		receiptsList = []*receipts.Receipt{}
-	}
-	if err := writeSseJson(wr, "initial_receipts", receiptsList); err != nil {
-		return nil, merry.Wrap(err)
+	if !isResumed {
+		if err := writeSseJson(wr, b.EventID(sub.StartSeq()), "initial_receipts", ensureRecsNotNil(receiptsList)); err != nil {
+			return nil, merry.Wrap(err)
+		}
 	}
 	flusher.Flush()
-	// for _, rec := range receipts {
-	// 	if err := writeSseJson(wr, "receipt", rec); err != nil {
-	// 		return nil, merry.Wrap(err)
-	// 	}
-	// 	flusher.Flush()
-	// 	time.Sleep(time.Second)
-	// }
-
-sseLoop:
+
 	for {
 		select {
-		case rec := <-recsChan:
-			log.Debug().Msg("SSE: got receipt")
-			// errors.Is(err, syscall.EPIPE)
-			if err := writeSseJson(wr, "receipt", rec); err != nil {
-				return nil, merry.Wrap(err)
+		case <-sub.Notify():
+			events, isOpen := sub.Take()
+			for _, ev := range events {
+				var err error
+				switch ev.Kind {
+				case BroadcastReceipt:
+					err = writeSseJson(wr, b.EventID(ev.Seq), "receipt", ev.Receipt)
+				case BroadcastReceiptDeleted:
+					err = writeSseJson(wr, b.EventID(ev.Seq), "receipt_deleted", map[string]int64{"id": ev.ReceiptID})
+				}
+				// errors.Is(err, syscall.EPIPE)
+				if err != nil {
+					return nil, merry.Wrap(err)
+				}
 			}
 			flusher.Flush()
-		case <-closeNotify:
+			if !isOpen {
+				log.Debug().Msg("SSE: broadcaster closed or client is too slow, aborting loop")
+				return nil, nil
+			}
+		case <-r.Context().Done():
 			log.Debug().Msg("SSE: client closed connection, aborting loop")
-			break sseLoop
+			return nil, nil
 		}
 	}
-	return nil, nil
 }
 
-func StartHTTPServer(db *sql.DB, env utils.Env, address string, debugTLS bool, updaterTriggerChan chan struct{}, updatedReceiptIDsChan chan int64) error {
+// сколько ждать завершения текущих запросов при остановке сервера
+const shutdownTimeout = 10 * time.Second
+
+// StartHTTPServer работает, пока не отменён ctx, затем перестаёт принимать запросы,
+// отключает SSE- и WebSocket-клиентов и ждёт завершения текущих запросов и WebSocket-обработчиков
+// (после этого никто не пишет в updatedReceiptIDsChan).
+func StartHTTPServer(ctx context.Context, db *sql.DB, cfg *Config, health *Health, breaker *DomainBreaker, events ReceiptEvents, receiptsBroadcaster *ReceiptsBroadcaster, domain2client map[string]receipts.Client, updaterTriggerChan chan struct{}, updatedReceiptIDsChan chan int64) error {
 	ex, err := os.Executable()
 	if err != nil {
 		return merry.Wrap(err)
 	}
 	baseDir := filepath.Dir(ex)
+	env := cfg.Env
+	address := cfg.Addr
 
 	var bundleFPath, stylesFPath string
 
-	receiptsBroadcaster := NewReceiptsBroadcaster()
-	go func() {
-		for recID := range updatedReceiptIDsChan {
-			rec, err := loadReceipt(db, recID)
-			if err != nil {
-				log.Fatal().Stack().Err(err).Msg("")
-			}
-			receiptsBroadcaster.InReceiptsChan <- rec
-		}
-	}()
-
 	// Config
 	wrapper := &httputils.Wrapper{
 		ShowErrorDetails: env.IsDev(),
@@ -347,9 +515,14 @@ func StartHTTPServer(db *sql.DB, env utils.Env, address string, debugTLS bool, u
 			return func(wr http.ResponseWriter, r *http.Request, params httprouter.Params) error {
 				log.Debug().Str("method", r.Method).Str("path", r.URL.Path).Msg("request")
 				r = r.WithContext(context.WithValue(r.Context(), CtxKeyEnv, env))
+				r = r.WithContext(context.WithValue(r.Context(), CtxKeyConfig, cfg))
+				r = r.WithContext(context.WithValue(r.Context(), CtxKeyHealth, health))
+				r = r.WithContext(context.WithValue(r.Context(), CtxKeyBreaker, breaker))
+				r = r.WithContext(context.WithValue(r.Context(), CtxKeyEvents, events))
 				r = r.WithContext(context.WithValue(r.Context(), CtxKeyDB, db))
 				r = r.WithContext(context.WithValue(r.Context(), CtxKeyTrigger, updaterTriggerChan))
 				r = r.WithContext(context.WithValue(r.Context(), CtxKeyUpdateRec, updatedReceiptIDsChan))
+				r = r.WithContext(context.WithValue(r.Context(), CtxKeyClients, domain2client))
 				return merry.Wrap(handle(wr, r, params))
 			}
 		},
@@ -377,7 +550,19 @@ func StartHTTPServer(db *sql.DB, env utils.Env, address string, debugTLS bool, u
 	route("GET", "/", HandleIndex)
 	route("GET", "/api/domains_metadata", HandleAPIDomainsMetadata)
 	route("POST", "/api/receipt", HandleAPIReceipt)
+	route("GET", "/api/health", HandleAPIHealth)
+	route("GET", "/healthz", HandleHealthz)
+	route("GET", "/readyz", HandleReadyz)
+	route("GET", "/metrics", receiptsBroadcaster.HandleMetrics)
 	route("GET", "/api/receipts_list", withGzip, receiptsBroadcaster.HandleAPIReceiptsList)
+	route("GET", "/api/ws", receiptsBroadcaster.HandleAPIWS)
+
+	apiRoutes := apiV1Routes(receiptsBroadcaster)
+	for _, r := range apiRoutes {
+		route(r.Method, apiV1Prefix+r.Path, r.Chain...)
+	}
+	apiSpec := buildOpenAPISpec("receipt_qr_scanner", "1", apiV1Prefix, apiRoutes)
+	route("GET", apiV1Prefix+"/openapi.json", handleOpenAPISpec(apiSpec))
 
 	route("GET", "/api/explode", func(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) (interface{}, error) {
 		return nil, merry.New("test API error")
@@ -414,14 +599,50 @@ func StartHTTPServer(db *sql.DB, env utils.Env, address string, debugTLS bool, u
 			http.ServeFile(wr, r, distPath+r.URL.Path)
 		})
 	}
+	// для АПИ — JSON-ошибки вместо статики и текстовых ответов роутера
+	staticHandler := router.NotFound
+	router.NotFound = http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeAPIError(wr, 404, "NOT_FOUND")
+			return
+		}
+		staticHandler.ServeHTTP(wr, r)
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeAPIError(wr, 405, "METHOD_NOT_ALLOWED")
+			return
+		}
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
 	log.Info().Str("fpath", bundleFPath).Msg("bundle")
 	log.Info().Str("fpath", stylesFPath).Msg("styles")
 
 	// Server
+	server := &http.Server{Addr: address, Handler: router}
+	server.RegisterOnShutdown(receiptsBroadcaster.Close)
+
+	shutdownErrChan := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		log.Info().Msg("stopping server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if wsErr := receiptsBroadcaster.WaitWSHandlers(shutdownCtx); err == nil {
+			err = wsErr
+		}
+		shutdownErrChan <- err
+	}()
+
 	log.Info().Str("address", address).Msg("starting server")
-	if debugTLS {
-		return merry.Wrap(http.ListenAndServeTLS(address, "debug.pem", "debug.key", router))
+	if cfg.DebugTLS {
+		err = server.ListenAndServeTLS("debug.pem", "debug.key")
 	} else {
-		return merry.Wrap(http.ListenAndServe(address, router))
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return merry.Wrap(err)
 	}
+	return merry.Wrap(<-shutdownErrChan)
 }
@@ -0,0 +1,154 @@
+// Package testutils — общие хелперы для тестов клиентов провайдеров:
+// стаб-сервер с записанными ответами и прогон FetchReceipt по набору таких ответов.
+package testutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/utils"
+	"sync"
+	"testing"
+
+	"github.com/ansel1/merry"
+)
+
+// ErrAny — в FetchCase.WantErr означает «любая ошибка»
+// (для ошибок, созданных через merry.Errorf без отдельной переменной).
+var ErrAny = errors.New("any error")
+
+// Fixture — записанный ответ провайдера, тело берётся из файла (обычно из testdata/).
+type Fixture struct {
+	Status      int
+	ContentType string
+	File        string
+}
+
+func JSONFixture(status int, file string) Fixture {
+	return Fixture{Status: status, ContentType: "application/json", File: file}
+}
+
+func HTMLFixture(status int, file string) Fixture {
+	return Fixture{Status: status, ContentType: "text/html; charset=utf-8", File: file}
+}
+
+// FixtureServer отвечает записанными ответами провайдера.
+// Маршруты задаются в виде "GET /path" (без query), все запросы сохраняются для проверок.
+type FixtureServer struct {
+	*httptest.Server
+	mutex         sync.Mutex
+	requestedURLs []string
+}
+
+func NewFixtureServer(t testing.TB, routes map[string]Fixture) *FixtureServer {
+	t.Helper()
+
+	bodies := make(map[string][]byte, len(routes))
+	for route, fixture := range routes {
+		buf, err := os.ReadFile(fixture.File)
+		if err != nil {
+			t.Fatalf("fixture for '%s': %v", route, err)
+		}
+		bodies[route] = buf
+	}
+
+	s := &FixtureServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requestedURLs = append(s.requestedURLs, r.URL.String())
+		s.mutex.Unlock()
+
+		route := r.Method + " " + r.URL.Path
+		fixture, ok := routes[route]
+		if !ok {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			http.Error(wr, "no fixture for "+route, http.StatusNotImplemented)
+			return
+		}
+		if fixture.ContentType != "" {
+			wr.Header().Set("Content-Type", fixture.ContentType)
+		}
+		wr.WriteHeader(fixture.Status)
+		wr.Write(bodies[route])
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ClientConfig возвращает конфиг, направляющий клиента провайдера на этот сервер
+// (через общий HTTP-слой, но без повторов, чтоб ответы с 5xx не запрашивались по нескольку раз).
+func (s *FixtureServer) ClientConfig() receipts.ClientConfig {
+	httpCfg := utils.DefaultHTTPClientConfig
+	httpCfg.Retries = 0
+	return receipts.ClientConfig{BaseURL: s.URL, HTTPClient: utils.NewHTTPClient("test", httpCfg)}
+}
+
+func (s *FixtureServer) RequestedURLs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.requestedURLs...)
+}
+
+// FetchCase — ожидаемый результат FetchReceipt для набора записанных ответов.
+type FetchCase struct {
+	Name   string
+	Routes map[string]Fixture
+	// nil — ожидается успех, ErrAny — любая ошибка, иначе — проверка через merry.Is
+	WantErr                   error
+	WantShouldDecreaseRetries bool
+	WantRedirectRefText       string
+	// должен ли был клиент вызвать onIsCorrect (чек точно существует, но данных пока нет)
+	WantIsCorrect bool
+}
+
+// RunFetchCases для каждого случая поднимает FixtureServer, создаёт клиента через newClient
+// и проверяет результат FetchReceipt для ref.
+func RunFetchCases(t *testing.T, newClient func(cfg receipts.ClientConfig) receipts.Client, ref receipts.ReceiptRef, cases []FetchCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			srv := NewFixtureServer(t, tc.Routes)
+
+			client := newClient(srv.ClientConfig())
+			if err := client.Init(); err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+
+			isCorrect := false
+			res, err := client.FetchReceipt(context.Background(), ref, func() error {
+				isCorrect = true
+				return nil
+			})
+
+			switch {
+			case tc.WantErr == nil && err != nil:
+				t.Errorf("FetchReceipt() error = %v, want nil", err)
+			case tc.WantErr != nil && err == nil:
+				t.Errorf("FetchReceipt() error = nil, want %v", tc.WantErr)
+			case tc.WantErr != nil && tc.WantErr != ErrAny && !merry.Is(err, tc.WantErr):
+				t.Errorf("FetchReceipt() error = %v, want %v", err, tc.WantErr)
+			}
+
+			if res.ShouldDecreaseRetries != tc.WantShouldDecreaseRetries {
+				t.Errorf("ShouldDecreaseRetries = %v, want %v", res.ShouldDecreaseRetries, tc.WantShouldDecreaseRetries)
+			}
+			if res.RedirectRefText != tc.WantRedirectRefText {
+				t.Errorf("RedirectRefText = %q, want %q", res.RedirectRefText, tc.WantRedirectRefText)
+			}
+			if isCorrect != tc.WantIsCorrect {
+				t.Errorf("onIsCorrect called = %v, want %v", isCorrect, tc.WantIsCorrect)
+			}
+
+			wantData := tc.WantErr == nil && tc.WantRedirectRefText == ""
+			if wantData && len(res.Data) == 0 {
+				t.Errorf("Data is empty, want response body")
+			}
+			if !wantData && res.Data != nil {
+				t.Errorf("Data = %q, want nil", res.Data)
+			}
+		})
+	}
+}
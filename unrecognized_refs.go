@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"receipt_qr_scanner/receipts"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+// UnrecognizedRef — текст QR-кода, который не разобрал ни один домен.
+// Хранится, пока не появится поддержка (см. promoteUnrecognizedRefs).
+type UnrecognizedRef struct {
+	ID          int64     `json:"id"`
+	RefText     string    `json:"refText"`
+	ScannedAt   time.Time `json:"scannedAt"`   //когда отсканирован впервые
+	ParseErrors string    `json:"parseErrors"` //ошибки разбора от всех доменов, по одной на строку
+}
+
+// saveUnrecognizedRef запоминает нераспознанный текст (при повторном сканировании обновляет только ошибки)
+func saveUnrecognizedRef(db *sql.DB, refText string, parseErr error) error {
+	_, err := db.Exec(`
+		INSERT INTO unrecognized_refs (ref_text, parse_errors) VALUES (?, ?)
+		ON CONFLICT (ref_text) DO UPDATE SET parse_errors = excluded.parse_errors`,
+		refText, parseErr.Error())
+	return merry.Wrap(err)
+}
+
+func loadUnrecognizedRefs(db *sql.DB) ([]*UnrecognizedRef, error) {
+	rows, err := db.Query(`SELECT id, ref_text, scanned_at, parse_errors FROM unrecognized_refs ORDER BY id DESC`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+
+	refs := []*UnrecognizedRef{}
+	for rows.Next() {
+		ref := &UnrecognizedRef{}
+		if err := rows.Scan(&ref.ID, &ref.RefText, &ref.ScannedAt, &ref.ParseErrors); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, merry.Wrap(rows.Err())
+}
+
+// promoteUnrecognizedRefs заново разбирает нераспознанные тексты (запускается при старте:
+// с новой версией могли появиться домены). Разобравшиеся сохраняются как обычные чеки
+// (saved_at — время сканирования) и удаляются из unrecognized_refs. Возвращает ID новых чеков.
+func promoteUnrecognizedRefs(db *sql.DB, domains []receipts.Domain, retries func(domainCode string) int64) ([]int64, error) {
+	refs, err := loadUnrecognizedRefs(db)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	var newIDs []int64
+	for _, unrec := range refs {
+		ref, err := receipts.ReceiptRefFromText(domains, unrec.RefText)
+		if err != nil {
+			// ошибки могли поменяться вместе с доменами
+			if _, err := db.Exec(`UPDATE unrecognized_refs SET parse_errors = ? WHERE id = ?`, err.Error(), unrec.ID); err != nil {
+				return nil, merry.Wrap(err)
+			}
+			continue
+		}
+
+		recID, err := saveRecieptRef(db, ref, retries(ref.Domain().Code))
+		if merry.Is(err, ErrReceiptRefAlreadyExists) {
+			log.Info().Int64("id", recID).Str("ref", ref.String()).Msg("unrecognized ref is now recognized, receipt already exists")
+		} else if err != nil {
+			return nil, merry.Wrap(err)
+		} else {
+			_, err := db.Exec(`
+				UPDATE receipts SET saved_at = (SELECT scanned_at FROM unrecognized_refs WHERE id = ?)
+				WHERE id = ?`, unrec.ID, recID)
+			if err != nil {
+				return nil, merry.Wrap(err)
+			}
+			log.Info().Int64("id", recID).Str("ref", ref.String()).Msg("unrecognized ref is now recognized, receipt added")
+			newIDs = append(newIDs, recID)
+		}
+		if _, err := db.Exec(`DELETE FROM unrecognized_refs WHERE id = ?`, unrec.ID); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	return newIDs, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"receipt_qr_scanner/kz_ktc"
+	"receipt_qr_scanner/kz_wip"
+	"receipt_qr_scanner/receipts"
+	"testing"
+)
+
+func TestPromoteUnrecognizedRefs(t *testing.T) {
+	db := openTestDB(t)
+	retries := func(string) int64 { return 10 }
+
+	existingRef, _ := receipts.ReceiptRefFromText(allDomains, "https://app.kassa.wipon.kz/consumer?i=5612340010&f=600407890704&s=2750.00&t=20260310T093045")
+	existingID, err := saveRecieptRef(db, existingRef, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{
+		"https://app.kassa.wipon.kz/consumer?i=5612340011&f=600407890704&s=100.00&t=20260310T093045",
+		"https://app.kassa.wipon.kz/consumer?i=5612340010&f=600407890704&s=2750.00&t=20260310T093045",
+		"hello",
+		"hello",
+	} {
+		if err := saveUnrecognizedRef(db, text, errors.New("unexpected host")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refs, err := loadUnrecognizedRefs(db); err != nil || len(refs) != 3 || refs[0].ParseErrors != "unexpected host" {
+		t.Fatalf("loadUnrecognizedRefs() = %v, %v, want 3 refs", refs, err)
+	}
+	db.Exec(`UPDATE unrecognized_refs SET scanned_at = '2026-03-11 10:00:00'`)
+
+	// домена ещё нет — ничего не меняется, кроме ошибок
+	newIDs, err := promoteUnrecognizedRefs(db, []receipts.Domain{kz_ktc.Domain}, retries)
+	if err != nil || len(newIDs) != 0 {
+		t.Fatalf("promoteUnrecognizedRefs(no domains) = %v, %v", newIDs, err)
+	}
+
+	// домен появился
+	newIDs, err = promoteUnrecognizedRefs(db, []receipts.Domain{kz_wip.Domain}, retries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newIDs) != 1 || newIDs[0] == existingID {
+		t.Fatalf("promoted %v, want one new receipt", newIDs)
+	}
+	rec, err := loadReceipt(db, newIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Domain != "kz-wip" || rec.SavedAt.Format("2006-01-02 15:04:05") != "2026-03-11 10:00:00" {
+		t.Errorf("promoted receipt = %s saved at %s, want kz-wip saved at scan time", rec.Domain, rec.SavedAt)
+	}
+	refs, err := loadUnrecognizedRefs(db)
+	if err != nil || len(refs) != 1 || refs[0].RefText != "hello" {
+		t.Errorf("left unrecognized %v, %v, want only 'hello'", refs, err)
+	}
+}
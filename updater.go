@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"maps"
 	"receipt_qr_scanner/kz_wip_proxy"
 	"receipt_qr_scanner/receipts"
 	"slices"
@@ -12,8 +12,19 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func updateIter(db *sql.DB, domain2client map[string]receipts.Client, updatedReceiptIDsChan chan int64) error {
-	domainCodes := slices.Collect(maps.Keys(domain2client))
+var ErrClientPanic = merry.New("client panic")
+
+// пауза перед отменой текущего запроса чека при остановке программы
+var fetchGracePeriod = 10 * time.Second
+
+// updateIter скачивает чеки, которые пора скачать. Между чеками проверяет stopCtx,
+// запросы идут с fetchCtx (он отменяется позже, чтоб текущий запрос мог завершиться).
+// Изменённые чеки отправляются в updatedReceiptIDsChan, события по ним — в events.
+func updateIter(stopCtx, fetchCtx context.Context, db *sql.DB, cfg *Config, breaker *DomainBreaker, events ReceiptEvents, domain2client map[string]receipts.Client, updatedReceiptIDsChan chan int64) error {
+	domainCodes := activeDomainCodes(domain2client, breaker)
+	if len(domainCodes) == 0 {
+		return nil
+	}
 
 	pendingReceipts, err := loadPendingReceipts(db, domainCodes, 5)
 	if err != nil {
@@ -21,28 +32,72 @@ func updateIter(db *sql.DB, domain2client map[string]receipts.Client, updatedRec
 	}
 
 	for _, rec := range pendingReceipts {
+		if stopCtx.Err() != nil {
+			return nil
+		}
+
+		// ошибки отдельного чека не должны останавливать апдейтер
+		if rec.RefErr != nil {
+			log.Error().Err(rec.RefErr).Int64("id", rec.ID).Str("ref_text", rec.RefText).Msg("receipt ref parse failed, disabling its fetching")
+			if err := saveReceiptUnfetchable(db, rec.ID); err != nil {
+				return merry.Wrap(err)
+			}
+			updatedReceiptIDsChan <- rec.ID
+			if err := events.Emit(EventReceiptFailed, rec.ID); err != nil {
+				return merry.Wrap(err)
+			}
+			continue
+		}
+
+		domainCode := rec.Ref.Domain().Code
+		if !breaker.Allow(domainCode) {
+			continue //домен на паузе или уже проверяется другим чеком
+		}
+
 		log.Info().Str("ref", rec.Ref.String()).Msg("fetching receipt")
 
 		client, ok := domain2client[rec.Ref.Domain().Code]
 		if !ok {
-			return merry.Errorf("no client for domain '%s'", rec.Ref.Domain().Code)
+			log.Error().Str("ref", rec.Ref.String()).Msgf("no client for domain '%s', skipping", rec.Ref.Domain().Code)
+			continue
 		}
 
-		res, err := client.FetchReceipt(rec.Ref, func() error {
+		fetchStartedAt := time.Now()
+		res, err := fetchReceiptRecovered(fetchCtx, client, rec.Ref, func() error {
 			var err error
 			if !rec.IsCorrect {
-				err = saveReceiptCorrectness(db, rec.Ref)
+				if err = saveReceiptCorrectness(db, rec.Ref); err == nil {
+					err = events.Emit(EventReceiptCorrect, rec.ID)
+				}
 				updatedReceiptIDsChan <- rec.ID
 			}
 			return merry.Wrap(err)
 		})
 
+		if err != nil && fetchCtx.Err() != nil {
+			// прервано остановкой, это не ошибка чека
+			log.Info().Str("ref", rec.Ref.String()).Msg("receipt fetch canceled")
+			breaker.RecordResult(domainCode, "canceled", err)
+			return nil
+		}
+		resultLabel := fetchResultLabel(res, err)
+		metrics.ObserveFetch(domainCode, resultLabel, time.Since(fetchStartedAt))
+		isDomainBroken := breaker.RecordResult(domainCode, resultLabel, err)
 		if err != nil {
 			log.Warn().Err(err).Str("ref", rec.Ref.String()).Msg("receipt error")
-			if err := saveReceiptFailure(db, rec.Ref, res.ShouldDecreaseRetries); err != nil {
+			// если сломан весь домен, чек не виноват — попытки не тратим
+			decreaseRetries := res.ShouldDecreaseRetries && !isDomainBroken
+			retriesLeft, err := saveReceiptFailure(db, rec.Ref, decreaseRetries)
+			if err != nil {
 				return merry.Wrap(err)
 			}
 			updatedReceiptIDsChan <- rec.ID
+			if rec.HasData {
+				continue //не удалось скачать повторно, но прошлые данные на месте — чек не сломан
+			}
+			if err := emitIfFailed(events, rec.ID, decreaseRetries, retriesLeft); err != nil {
+				return merry.Wrap(err)
+			}
 			continue
 		}
 
@@ -50,21 +105,27 @@ func updateIter(db *sql.DB, domain2client map[string]receipts.Client, updatedRec
 		if res.RedirectRefText != "" {
 			log.Info().Str("ref", rec.Ref.String()).Str("redirect", res.RedirectRefText).Msg("receipt redirect")
 
+			isFailed := false
 			newRef, parseErr := receipts.ReceiptRefFromText(allDomains, res.RedirectRefText)
 			if parseErr != nil {
 				log.Warn().Err(parseErr).Str("ref", rec.Ref.String()).Str("redirect", res.RedirectRefText).Msg("redirect ref parse failed")
-				if err := saveReceiptFailure(db, rec.Ref, true); err != nil {
+				retriesLeft, err := saveReceiptFailure(db, rec.Ref, true)
+				if err != nil {
 					return merry.Wrap(err)
 				}
+				isFailed = retriesLeft == 0
 			} else if newRef.Domain().Code == kz_wip_proxy.Domain.Code {
 				log.Warn().Err(parseErr).Str("ref", rec.Ref.String()).Str("redirect", res.RedirectRefText).Msg("redirect ref domain cycle")
-				if err := saveReceiptFailure(db, rec.Ref, true); err != nil {
+				retriesLeft, err := saveReceiptFailure(db, rec.Ref, true)
+				if err != nil {
 					return merry.Wrap(err)
 				}
+				isFailed = retriesLeft == 0
 			} else {
-				if err := replaceReceiptRef(db, rec.Ref, newRef); err != nil {
+				if err := replaceReceiptRef(db, rec.Ref, newRef, cfg.ReceiptRetries(newRef.Domain().Code)); err != nil {
 					if merry.Is(err, ErrReceiptRedirectDuplicate) {
 						log.Info().Str("ref", rec.Ref.String()).Msg("redirect target already exists, marked as failed")
+						isFailed = true
 					} else {
 						return merry.Wrap(err)
 					}
@@ -72,28 +133,100 @@ func updateIter(db *sql.DB, domain2client map[string]receipts.Client, updatedRec
 			}
 
 			updatedReceiptIDsChan <- rec.ID
+			if isFailed {
+				if err := events.Emit(EventReceiptFailed, rec.ID); err != nil {
+					return merry.Wrap(err)
+				}
+			}
 			continue
 		}
 
 		log.Info().Str("ref", rec.Ref.String()).Msg("got receipt data")
-		if err := saveRecieptData(db, rec.Ref, res.Data); err != nil {
+		event := EventReceiptFetched
+		if err := saveRecieptData(db, rec.Ref, res.Data); merry.Is(err, ErrReceiptDataInvalid) {
+			log.Warn().Err(err).Str("ref", rec.Ref.String()).Msg("receipt data save failed")
+			retriesLeft, err := saveReceiptFailure(db, rec.Ref, true)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+			event = ""
+			if retriesLeft == 0 && !rec.HasData {
+				event = EventReceiptFailed
+			}
+		} else if err != nil {
 			return merry.Wrap(err)
 		}
 		updatedReceiptIDsChan <- rec.ID
+		if event != "" {
+			if err := events.Emit(event, rec.ID); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+	}
+	return nil
+}
+
+// emitIfFailed отправляет EventReceiptFailed, если на чек потрачена последняя попытка
+func emitIfFailed(events ReceiptEvents, receiptID int64, decreasedRetries bool, retriesLeft int64) error {
+	if decreasedRetries && retriesLeft == 0 {
+		return merry.Wrap(events.Emit(EventReceiptFailed, receiptID))
 	}
 	return nil
 }
 
-func StartUpdater(db *sql.DB, domain2client map[string]receipts.Client, triggerChan chan struct{}, updatedReceiptIDsChan chan int64) error {
+// activeDomainCodes — домены с клиентами, кроме поставленных на паузу
+func activeDomainCodes(domain2client map[string]receipts.Client, breaker *DomainBreaker) []string {
+	var codes []string
+	for code := range domain2client {
+		if !breaker.IsPaused(code) {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// fetchReceiptRecovered превращает панику клиента в ошибку чека (с уменьшением попыток),
+// чтоб один странный ответ провайдера не ронял апдейтер раз за разом
+func fetchReceiptRecovered(ctx context.Context, client receipts.Client, ref receipts.ReceiptRef, onIsCorrect func() error) (res receipts.FetchReceiptResult, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			res = receipts.FetchReceiptResult{ShouldDecreaseRetries: true}
+			err = ErrClientPanic.Here().Appendf("%s: %v", ref.Domain().Code, p)
+		}
+	}()
+	return client.FetchReceipt(ctx, ref, onIsCorrect)
+}
+
+// StartUpdater работает, пока не отменён ctx. Текущий запрос чека после отмены
+// получает fetchGracePeriod на завершение, потом тоже отменяется.
+func StartUpdater(ctx context.Context, db *sql.DB, cfg *Config, health *Health, breaker *DomainBreaker, events ReceiptEvents, domain2client map[string]receipts.Client, triggerChan chan struct{}, updatedReceiptIDsChan chan int64) error {
+	fetchCtx, cancelFetch := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelFetch()
+	stopGraceTimer := context.AfterFunc(ctx, func() {
+		time.AfterFunc(fetchGracePeriod, cancelFetch)
+	})
+	defer stopGraceTimer()
+
 	timer := time.NewTimer(200 * 365 * 24 * time.Hour) //timedelta can store ~292 years
-	domainCodes := slices.Collect(maps.Keys(domain2client))
 	for {
-		if err := updateIter(db, domain2client, updatedReceiptIDsChan); err != nil {
+		if err := updateIter(ctx, fetchCtx, db, cfg, breaker, events, domain2client, updatedReceiptIDsChan); err != nil {
 			return merry.Wrap(err)
 		}
-		nextRetryAt, err := loadNextRetryTime(db, domainCodes)
-		if err != nil {
-			return merry.Wrap(err)
+		health.SetOK(updaterComponent)
+		if ctx.Err() != nil {
+			log.Info().Msg("updater stopped")
+			return nil
+		}
+		nextRetryAt := time.Now().Add(200 * 365 * 24 * time.Hour)
+		if domainCodes := activeDomainCodes(domain2client, breaker); len(domainCodes) > 0 {
+			var err error
+			if nextRetryAt, err = loadNextRetryTime(db, domainCodes); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		if nextProbeAt, ok := breaker.NextProbeAt(); ok && nextProbeAt.Before(nextRetryAt) {
+			nextRetryAt = nextProbeAt
 		}
 		delay := time.Until(nextRetryAt)
 		if delay < time.Second {
@@ -110,6 +243,9 @@ func StartUpdater(db *sql.DB, domain2client map[string]receipts.Client, triggerC
 		case <-triggerChan:
 			log.Debug().Int("chan_len", len(triggerChan)).Msg("updater triggered")
 		case <-timer.C:
+		case <-ctx.Done():
+			log.Info().Msg("updater stopped")
+			return nil
 		}
 		//emptying triggerChan
 		for len(triggerChan) > 0 {
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"receipt_qr_scanner/kg_gns"
+	"receipt_qr_scanner/kz_bee"
+	"receipt_qr_scanner/kz_ktc"
+	"receipt_qr_scanner/kz_wfd"
+	"receipt_qr_scanner/kz_wip"
+	"receipt_qr_scanner/kz_wip_proxy"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := setupDB("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("setupDB() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type testReceiptRow struct {
+	Domain      string
+	RefText     string
+	RetriesLeft int64
+	IsCorrect   bool
+	HasData     bool
+}
+
+func loadTestReceiptRow(t *testing.T, db *sql.DB, id int64) testReceiptRow {
+	t.Helper()
+	var row testReceiptRow
+	err := db.QueryRow(`
+		SELECT domain, ref_text, retries_left, COALESCE(is_correct, 0), data IS NOT NULL
+		FROM receipts WHERE id = ?`, id).
+		Scan(&row.Domain, &row.RefText, &row.RetriesLeft, &row.IsCorrect, &row.HasData)
+	if err != nil {
+		t.Fatalf("loading receipt #%d: %v", id, err)
+	}
+	return row
+}
+
+func TestUpdateIter(t *testing.T) {
+	db := openTestDB(t)
+
+	proxyUUID1 := "8ab7d2bb-1234-4a8c-4321-02185db12a23"
+	proxyUUID2 := "019c387e-9e9a-717c-a70e-d6c7f6e0ff67"
+	innerRefText := "https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045"
+
+	servers := map[string]*testutils.FixtureServer{
+		kz_ktc.Domain.Code: testutils.NewFixtureServer(t, map[string]testutils.Fixture{
+			"GET /api/consumer-proxy/api/tickets/get-by-url": testutils.JSONFixture(200, "kz_ktc/testdata/ticket.json"),
+		}),
+		kz_wfd.Domain.Code: testutils.NewFixtureServer(t, map[string]testutils.Fixture{
+			"GET /api/tickets": testutils.JSONFixture(200, "kz_wfd/testdata/ticket_not_found.json"),
+		}),
+		kz_bee.Domain.Code: testutils.NewFixtureServer(t, map[string]testutils.Fixture{
+			"GET /t/": testutils.HTMLFixture(200, "kz_bee/testdata/ticket.html"),
+		}),
+		kg_gns.Domain.Code: testutils.NewFixtureServer(t, map[string]testutils.Fixture{
+			"GET /tax-web-control/client/api/v1/ticket": testutils.HTMLFixture(500, "kg_gns/testdata/not_found.html"),
+		}),
+		kz_wip_proxy.Domain.Code: testutils.NewFixtureServer(t, map[string]testutils.Fixture{
+			"GET /links/check/" + proxyUUID1: testutils.HTMLFixture(200, "kz_wip_proxy/testdata/check.html"),
+			"GET /links/check/" + proxyUUID2: testutils.HTMLFixture(200, "kz_wip_proxy/testdata/check.html"),
+		}),
+	}
+
+	domain2client := map[string]receipts.Client{}
+	for _, domain := range allDomains {
+		if srv, ok := servers[domain.Code]; ok {
+			client := domain.NewClient(srv.ClientConfig())
+			if err := client.Init(); err != nil {
+				t.Fatalf("%s: Init() error = %v", domain.Code, err)
+			}
+			domain2client[domain.Code] = client
+		}
+	}
+
+	saveRef := func(refText string) int64 {
+		t.Helper()
+		ref, err := receipts.ReceiptRefFromText(allDomains, refText)
+		if err != nil {
+			t.Fatalf("ReceiptRefFromText(%s) error = %v", refText, err)
+		}
+		id, err := saveRecieptRef(db, ref, defaultReceiptRetries)
+		if err != nil {
+			t.Fatalf("saveRecieptRef(%s) error = %v", refText, err)
+		}
+		return id
+	}
+	ktcID := saveRef("http://consumer.oofd.kz?i=0123456789&f=010101012345&s=1600.12&t=20240309T123456")
+	wfdID := saveRef("https://consumer.wofd.kz?i=123456789012&f=010101234567&s=12345.00&t=20260101T120000")
+	beeID := saveRef("https://ofd.beeline.kz/t/?i=123456789012&f=012345678901&s=3300.00&t=20241108T123456")
+	gnsID := saveRef("https://tax.salyk.kg/tax-web-control/client/api/v1/ticket?date=20230729T210806&type=3&operation_type=1&fn_number=000123&fd_number=123&fm=123&tin=123&regNumber=0001233&sum=12300")
+	proxyID1 := saveRef("https://app.kassa.wipon.kz/links/check/" + proxyUUID1)
+	proxyID2 := saveRef("https://app.kassa.wipon.kz/links/check/" + proxyUUID2)
+
+	updatedReceiptIDsChan := make(chan int64, 100)
+	for range 2 { //двух итераций (по 5 чеков) хватит на все чеки
+		if err := updateIter(context.Background(), context.Background(), db, &Config{}, NewDomainBreaker(&Config{}), nil, domain2client, updatedReceiptIDsChan); err != nil {
+			t.Fatalf("updateIter() error = %v", err)
+		}
+	}
+
+	if len(updatedReceiptIDsChan) != 6 {
+		t.Errorf("updated receipts count = %d, want 6", len(updatedReceiptIDsChan))
+	}
+
+	// успешные
+	for _, id := range []int64{ktcID, beeID} {
+		row := loadTestReceiptRow(t, db, id)
+		if !row.IsCorrect || !row.HasData || row.RetriesLeft != 10 {
+			t.Errorf("receipt #%d (%s) = %+v, want correct with data", id, row.Domain, row)
+		}
+	}
+
+	// чека нет (found=0) — попытки уменьшаются
+	if row := loadTestReceiptRow(t, db, wfdID); row.HasData || row.RetriesLeft != 9 {
+		t.Errorf("kz-wfd receipt = %+v, want no data and 9 retries left", row)
+	}
+	// ошибка сервера — попытки не уменьшаются
+	if row := loadTestReceiptRow(t, db, gnsID); row.HasData || row.RetriesLeft != 10 {
+		t.Errorf("kg-gns receipt = %+v, want no data and 10 retries left", row)
+	}
+
+	// прокси-чеки: один заменён на реальный kz-wip, второй (дубликат) помечен неудачным
+	row1 := loadTestReceiptRow(t, db, proxyID1)
+	row2 := loadTestReceiptRow(t, db, proxyID2)
+	if row1.Domain != kz_wip.Domain.Code {
+		row1, row2 = row2, row1
+	}
+	if row1.Domain != kz_wip.Domain.Code || row1.RefText != innerRefText || row1.HasData || row1.RetriesLeft != 10 {
+		t.Errorf("redirected receipt = %+v, want pending %s receipt %s", row1, kz_wip.Domain.Code, innerRefText)
+	}
+	if row2.Domain != kz_wip_proxy.Domain.Code || row2.RetriesLeft != 0 {
+		t.Errorf("duplicate proxy receipt = %+v, want %s with 0 retries left", row2, kz_wip_proxy.Domain.Code)
+	}
+
+	counts, err := loadReceiptCounts(db)
+	if err != nil {
+		t.Fatalf("loadReceiptCounts() error = %v", err)
+	}
+	wantCounts := map[string]ReceiptCounts{
+		kg_gns.Domain.Code:       {Domain: kg_gns.Domain.Code, Pending: 1},
+		kz_wip.Domain.Code:       {Domain: kz_wip.Domain.Code, Pending: 1},
+		kz_wip_proxy.Domain.Code: {Domain: kz_wip_proxy.Domain.Code, Failed: 1},
+	}
+	for _, c := range counts {
+		if want, ok := wantCounts[c.Domain]; ok && c != want {
+			t.Errorf("receipt counts = %+v, want %+v", c, want)
+		}
+	}
+}
+
+func TestUpdateIterCanceled(t *testing.T) {
+	db := openTestDB(t)
+
+	fetchCtx, cancelFetch := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		cancelFetch() //программа останавливается, пока идёт запрос
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	client := kz_wfd.Domain.NewClient(receipts.ClientConfig{BaseURL: srv.URL})
+	domain2client := map[string]receipts.Client{kz_wfd.Domain.Code: client}
+
+	ref, err := receipts.ReceiptRefFromText(allDomains, "https://consumer.wofd.kz?i=123456789012&f=010101234567&s=12345.00&t=20260101T120000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := saveRecieptRef(db, ref, defaultReceiptRetries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updatedReceiptIDsChan := make(chan int64, 10)
+	if err := updateIter(context.Background(), fetchCtx, db, &Config{}, NewDomainBreaker(&Config{}), nil, domain2client, updatedReceiptIDsChan); err != nil {
+		t.Fatalf("updateIter() error = %v", err)
+	}
+
+	// прерванный запрос не считается неудачной попыткой
+	if row := loadTestReceiptRow(t, db, id); row.RetriesLeft != defaultReceiptRetries {
+		t.Errorf("receipt = %+v, want %d retries left", row, defaultReceiptRetries)
+	}
+	if len(updatedReceiptIDsChan) != 0 {
+		t.Errorf("updated receipts count = %d, want 0", len(updatedReceiptIDsChan))
+	}
+}
+
+func TestUpdateIterBrokenRef(t *testing.T) {
+	db := openTestDB(t)
+
+	res, err := db.Exec(`INSERT INTO receipts (domain, unique_key, ref_text, created_at, search_key) VALUES (?,?,?,?,?)`,
+		kz_wfd.Domain.Code, "broken", "not a receipt ref", "2026-01-01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+
+	cfg := &Config{Webhooks: []WebhookConfig{{Name: "test", URL: "http://127.0.0.1:1/", Secret: "s"}}}
+	webhooks, err := NewWebhooks(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain2client := map[string]receipts.Client{kz_wfd.Domain.Code: kz_wfd.Domain.NewClient(receipts.ClientConfig{})}
+	updatedReceiptIDsChan := make(chan int64, 10)
+	if err := updateIter(context.Background(), context.Background(), db, cfg, NewDomainBreaker(cfg), ReceiptEvents{webhooks}, domain2client, updatedReceiptIDsChan); err != nil {
+		t.Fatalf("updateIter() error = %v", err)
+	}
+
+	if row := loadTestReceiptRow(t, db, id); row.RetriesLeft != 0 {
+		t.Errorf("receipt = %+v, want 0 retries left", row)
+	}
+	if len(updatedReceiptIDsChan) != 1 {
+		t.Errorf("updated receipts count = %d, want 1", len(updatedReceiptIDsChan))
+	}
+	deliveries, err := loadDueWebhookDeliveries(db, []string{"test"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event != EventReceiptFailed || deliveries[0].ReceiptID != id {
+		t.Errorf("webhook deliveries = %+v, want one %s for #%d", deliveries, EventReceiptFailed, id)
+	}
+}
+
+func TestUpdateIterBreaker(t *testing.T) {
+	db := openTestDB(t)
+
+	srv := testutils.NewFixtureServer(t, map[string]testutils.Fixture{
+		"GET /api/tickets": testutils.HTMLFixture(200, "kz_wfd/testdata/maintenance.html"),
+	})
+	domain2client := map[string]receipts.Client{kz_wfd.Domain.Code: kz_wfd.Domain.NewClient(srv.ClientConfig())}
+
+	var ids []int64
+	for i := range defaultBreakerThreshold + 1 {
+		ref, err := receipts.ReceiptRefFromText(allDomains, fmt.Sprintf("https://consumer.wofd.kz?i=12345678901%d&f=010101234567&s=12345.00&t=20260101T120000", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := saveRecieptRef(db, ref, defaultReceiptRetries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	cfg := &Config{}
+	breaker := NewDomainBreaker(cfg)
+	updatedReceiptIDsChan := make(chan int64, 100)
+	for range 2 {
+		if err := updateIter(context.Background(), context.Background(), db, cfg, breaker, nil, domain2client, updatedReceiptIDsChan); err != nil {
+			t.Fatalf("updateIter() error = %v", err)
+		}
+	}
+
+	if st := breaker.State(kz_wfd.Domain.Code); st.State != BreakerOpen || st.ConsecutiveFailures != defaultBreakerThreshold {
+		t.Errorf("breaker state = %+v, want open", st)
+	}
+	if n := len(srv.RequestedURLs()); n != defaultBreakerThreshold {
+		t.Errorf("requests count = %d, want %d", n, defaultBreakerThreshold)
+	}
+
+	// попытки тратятся, пока домен не признан сломанным
+	retries := make([]int64, len(ids))
+	for i, id := range ids {
+		retries[i] = loadTestReceiptRow(t, db, id).RetriesLeft
+	}
+	wantRetries := []int64{9, 9, 9, 9, 10, 10}
+	if fmt.Sprint(retries) != fmt.Sprint(wantRetries) {
+		t.Errorf("retries left = %v, want %v", retries, wantRetries)
+	}
+}
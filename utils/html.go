@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Поиск по HTML-страницам чеков (у провайдеров, которые отдают только страницу)
+
+func HTMLHasClass(class string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		for _, attr := range n.Attr {
+			if attr.Key == "class" {
+				for _, c := range strings.Fields(attr.Val) {
+					if c == class {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+}
+
+// FindHTMLNode — первый (в глубину) элемент внутри node, подходящий под match
+func FindHTMLNode(node *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			return c
+		}
+		if res := FindHTMLNode(c, match); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+// FindAllHTMLNodes — все элементы внутри node, подходящие под match (вложенные в подошедшие не ищутся)
+func FindAllHTMLNodes(node *html.Node, match func(*html.Node) bool) []*html.Node {
+	var res []*html.Node
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			res = append(res, c)
+		} else {
+			res = append(res, FindAllHTMLNodes(c, match)...)
+		}
+	}
+	return res
+}
+
+func HTMLChildElements(node *html.Node, tag string) []*html.Node {
+	var res []*html.Node
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == tag {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// HTMLNodeText — текст элемента со схлопнутыми пробелами и переносами
+func HTMLNodeText(node *html.Node) string {
+	return HTMLNodeTextSkipping(node, func(*html.Node) bool { return false })
+}
+
+func HTMLNodeTextSkipping(node *html.Node, skip func(*html.Node) bool) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				sb.WriteString(c.Data)
+				sb.WriteByte(' ')
+			} else if c.Type == html.ElementNode && !skip(c) {
+				walk(c)
+			}
+		}
+	}
+	walk(node)
+	return CollapseSpaces(sb.String())
+}
+
+func CollapseSpaces(str string) string {
+	return strings.Join(strings.Fields(str), " ")
+}
@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrResponseTooLarge = merry.New("response body too large")
+
+// HTTPClientConfig — настройки HTTP-клиента для запросов к одному провайдеру
+type HTTPClientConfig struct {
+	// Таймаут одной попытки запроса, включая чтение тела
+	Timeout time.Duration
+	// Сколько раз повторять GET-запрос при временных ошибках (сетевые ошибки, 502/503/504)
+	Retries    int
+	RetryDelay time.Duration
+	// Максимальный размер тела ответа в байтах (0 и меньше — без ограничения)
+	MaxBodySize int64
+	// Выставляется, только если запрос ещё не содержит свой User-Agent (как ru-fns)
+	UserAgent string
+	// Прокси для запросов (http://, https://, socks5://, socks5h://, можно с user:password@).
+	// nil — прокси из переменных окружения (HTTP_PROXY, HTTPS_PROXY, NO_PROXY), см. ParseProxyURL.
+	Proxy *url.URL
+}
+
+var DefaultHTTPClientConfig = HTTPClientConfig{
+	Timeout:     30 * time.Second,
+	Retries:     2,
+	RetryDelay:  2 * time.Second,
+	MaxBodySize: 5 * 1024 * 1024,
+	UserAgent:   "receipt_qr_scanner (+https://github.com/3bl3gamer/receipt_qr_scanner)",
+}
+
+// общий транспорт, чтоб соединения переиспользовались между клиентами
+var sharedTransport = newBaseTransport()
+
+// транспорты для прокси (по одному на адрес прокси, чтоб клиенты с одинаковым прокси тоже делили соединения)
+var proxyTransports = map[string]*http.Transport{}
+var proxyTransportsMutex sync.Mutex
+
+// DirectProxyURL в HTTPClientConfig.Proxy отключает прокси (в т.ч. из переменных окружения)
+var DirectProxyURL = &url.URL{Scheme: "direct"}
+
+// DefaultHTTPClient используется клиентами провайдеров, если в receipts.ClientConfig не задан свой
+var DefaultHTTPClient = NewHTTPClient("default", DefaultHTTPClientConfig)
+
+func newBaseTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	// ResponseHeaderTimeout не задаётся: транспорт общий для доменов с разными таймаутами,
+	// ожидание заголовков ограничено таймаутом попытки (HTTPClientConfig.Timeout, см. roundTripOnce)
+	return transport
+}
+
+func transportForProxy(proxyURL *url.URL) *http.Transport {
+	if proxyURL == nil {
+		return sharedTransport
+	}
+
+	proxyTransportsMutex.Lock()
+	defer proxyTransportsMutex.Unlock()
+
+	key := proxyURL.String()
+	if transport, ok := proxyTransports[key]; ok {
+		return transport
+	}
+	transport := newBaseTransport()
+	if proxyURL.Scheme == DirectProxyURL.Scheme {
+		transport.Proxy = nil
+	} else {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	proxyTransports[key] = transport
+	return transport
+}
+
+// ParseProxyURL разбирает адрес прокси: http://, https:// (HTTP CONNECT), socks5://, socks5h://
+// (SOCKS5, h — с резолвом имён на стороне прокси) или "direct" (без прокси).
+func ParseProxyURL(proxyStr string) (*url.URL, error) {
+	if proxyStr == "direct" {
+		return DirectProxyURL, nil
+	}
+	u, err := url.Parse(proxyStr)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, merry.Errorf("unsupported proxy scheme '%s' in %s, must be http, https, socks5, socks5h or 'direct'", u.Scheme, u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, merry.Errorf("proxy host is missing in %s", u.Redacted())
+	}
+	return u, nil
+}
+
+// NewHTTPClient создаёт клиента поверх общего транспорта (или общего для cfg.Proxy);
+// name (обычно код домена) пишется в логи запросов.
+func NewHTTPClient(name string, cfg HTTPClientConfig) *http.Client {
+	return &http.Client{
+		Transport: &HTTPTransport{Name: name, Config: cfg, Base: transportForProxy(cfg.Proxy)},
+	}
+}
+
+// HTTPClientWithRootCAs возвращает копию клиента, транспорт которого доверяет сертификатам из rootCAs
+// (нужно провайдерам с неполной цепочкой сертификатов, см. kz_ktc).
+func HTTPClientWithRootCAs(client *http.Client, rootCAs *x509.CertPool) (*http.Client, error) {
+	withRootCAs := func(transport *http.Transport) *http.Transport {
+		transport = transport.Clone()
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = rootCAs
+		return transport
+	}
+
+	newClient := *client
+	switch t := client.Transport.(type) {
+	case nil:
+		newClient.Transport = withRootCAs(http.DefaultTransport.(*http.Transport))
+	case *http.Transport:
+		newClient.Transport = withRootCAs(t)
+	case *HTTPTransport:
+		newTransport := *t
+		newTransport.Base = withRootCAs(t.Base)
+		newClient.Transport = &newTransport
+	default:
+		return nil, merry.Errorf("can not set root CAs for transport %T", client.Transport)
+	}
+	return &newClient, nil
+}
+
+// HTTPTransport добавляет к базовому транспорту User-Agent, повторы при временных ошибках,
+// ограничение размера ответа и логирование запросов.
+type HTTPTransport struct {
+	Name   string
+	Config HTTPClientConfig
+	Base   *http.Transport
+}
+
+func (t *HTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Config.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.Config.UserAgent)
+	}
+
+	retries := 0
+	if req.Method == "GET" || req.Method == "HEAD" {
+		retries = t.Config.Retries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTripOnce(req, attempt)
+
+		if attempt < retries && isTransientHTTPError(resp, err) {
+			if resp != nil {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) //чтоб соединение можно было переиспользовать
+				resp.Body.Close()
+			}
+			select {
+			case <-time.After(t.Config.RetryDelay * time.Duration(attempt+1)):
+				continue
+			case <-req.Context().Done():
+				return nil, merry.Wrap(req.Context().Err())
+			}
+		}
+		return resp, err
+	}
+}
+
+func (t *HTTPTransport) roundTripOnce(req *http.Request, attempt int) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.Config.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), t.Config.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, merry.Wrap(err)
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	stt := time.Now()
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+
+	logEvent := log.Debug()
+	if err != nil {
+		logEvent = log.Warn().Err(err)
+	} else {
+		logEvent = logEvent.Int("code", resp.StatusCode)
+	}
+	if t.Config.Proxy != nil {
+		logEvent = logEvent.Str("proxy", t.Config.Proxy.Redacted())
+	}
+	logEvent.Str("client", t.Name).Str("method", req.Method).
+		Str("host", req.URL.Host).Str("path", req.URL.Path).
+		Int("attempt", attempt+1).Dur("duration", time.Since(stt)).
+		Msg("HTTP request")
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// таймаут продолжает действовать, пока читается тело
+	left := t.Config.MaxBodySize
+	if left <= 0 {
+		left = -1
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, left: left, cancel: cancel}
+	return resp, nil
+}
+
+func isTransientHTTPError(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// limitedBody возвращает ErrResponseTooLarge, если тело ответа длиннее лимита (left < 0 — без лимита),
+// и отменяет контекст запроса при закрытии.
+type limitedBody struct {
+	io.ReadCloser
+	left   int64
+	cancel context.CancelFunc
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left == 0 {
+		// проверяем, не закончилось ли тело ровно на лимите
+		var buf [1]byte
+		if n, err := b.ReadCloser.Read(buf[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, ErrResponseTooLarge.Here()
+	}
+	if b.left > 0 && int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.left > 0 {
+		b.left -= int64(n)
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -1,11 +1,14 @@
 package utils
 
 import (
+	"context"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/ansel1/merry"
 )
@@ -30,6 +33,14 @@ func (e Env) Type() string {
 	return "string"
 }
 
+func (e *Env) UnmarshalText(text []byte) error {
+	return e.Set(string(text))
+}
+
+func (e Env) MarshalText() ([]byte, error) {
+	return []byte(e.Val), nil
+}
+
 func (e Env) IsDev() bool {
 	return e.Val == "dev"
 }
@@ -114,12 +125,89 @@ func (o OptionValues[T]) JoinStrings(sep string) string {
 	return strings.Join(optionStrs, sep)
 }
 
-func MakeConfigDir() (string, error) {
-	dir, err := os.UserConfigDir()
+// OptionMap validates and stores key=value pairs, keys are from a set of allowed options
+type OptionMap[T any] struct {
+	Options   []T
+	ToStr     func(T) string
+	Separator string
+	Values    map[string]string
+}
+
+func (o *OptionMap[T]) Set(value string) error {
+	values := map[string]string{}
+	for _, part := range strings.Split(value, o.Separator) {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return merry.Errorf("'%s' must be in key=value format", part)
+		}
+		if !slices.ContainsFunc(o.Options, func(option T) bool { return o.ToStr(option) == key }) {
+			return merry.Errorf("'%s' must be one of: %s", key, o.JoinStrings(", "))
+		}
+		values[key] = val
+	}
+	o.Values = values
+	return nil
+}
+
+func (o *OptionMap[T]) String() string {
+	strs := make([]string, 0, len(o.Values))
+	for key, val := range o.Values {
+		strs = append(strs, key+"="+val)
+	}
+	slices.Sort(strs)
+	return strings.Join(strs, o.Separator)
+}
+
+func (o OptionMap[T]) JoinStrings(sep string) string {
+	optionStrs := make([]string, len(o.Options))
+	for i, option := range o.Options {
+		optionStrs[i] = o.ToStr(option)
+	}
+	return strings.Join(optionStrs, sep)
+}
+
+// Duration — time.Duration, который в JSON записывается строкой вида "30s" или "1m30s"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	dur, err := time.ParseDuration(string(text))
 	if err != nil {
-		return "", merry.Wrap(err)
+		return merry.Wrap(err)
+	}
+	d.Duration = dur
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// SleepCtx — time.Sleep, прерываемый отменой ctx (тогда возвращает ошибку контекста)
+func SleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return merry.Wrap(ctx.Err())
+	}
+}
+
+// ConfigDir — папка конфига, если пустая, используется <UserConfigDir>/receipt_qr_scanner
+var ConfigDir string
+
+func MakeConfigDir() (string, error) {
+	dir := ConfigDir
+	if dir == "" {
+		userDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", merry.Wrap(err)
+		}
+		dir = userDir + "/receipt_qr_scanner"
 	}
-	dir = dir + "/receipt_qr_scanner"
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return "", merry.Wrap(err)
 	}
@@ -142,3 +230,19 @@ func GetHTTPBody(client *http.Client, req *http.Request) (*http.Response, []byte
 
 	return resp, buf, nil
 }
+
+// ReplaceURLBase заменяет схему и хост (с портом) в rawURL на взятые из baseURL.
+// Нужно для провайдеров, у которых ссылка из QR-кода сама является адресом АПИ (kg-gns, kz-wip-proxy).
+func ReplaceURLBase(rawURL, baseURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	return u.String(), nil
+}
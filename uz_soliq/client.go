@@ -0,0 +1,72 @@
+package uz_soliq
+
+import (
+	"context"
+	"net/http"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/utils"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+type Client struct {
+	cfg receipts.ClientConfig
+}
+
+func (c *Client) Init() error {
+	return nil
+}
+
+func (c *Client) FetchReceipt(ctx context.Context, iRef receipts.ReceiptRef, onIsCorrect func() error) (receipts.FetchReceiptResult, error) {
+	res := receipts.FetchReceiptResult{ShouldDecreaseRetries: false, Data: nil}
+
+	ref, err := receipts.CastReceiptRefTo[ReceiptRef](iRef, Domain.Code)
+	if err != nil {
+		return res, err
+	}
+
+	// ссылка из QR-кода указывает на страницу чека
+	pageURL := ref.RefText()
+	if c.cfg.BaseURL != "" {
+		if pageURL, err = utils.ReplaceURLBase(pageURL, c.cfg.BaseURL); err != nil {
+			return res, merry.Wrap(err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return res, merry.Wrap(err)
+	}
+
+	resp, buf, err := utils.GetHTTPBody(c.cfg.HTTPClientOr(utils.DefaultHTTPClient), req)
+	if err != nil {
+		return res, err
+	}
+
+	log.Debug().
+		Int("code", resp.StatusCode).Str("status", resp.Status).
+		Str("url", pageURL).Int("html_length", len(buf)).
+		Msgf("%s: response", Domain.Code)
+
+	if resp.Status != "200 OK" {
+		res.ShouldDecreaseRetries = true
+		return res, receipts.ErrUnexpectedHttpStatus.Here().Append(resp.Status).Append(string(buf))
+	}
+
+	// на странице чека в его таблице есть фискальный признак и итог; страница "не найден"
+	// может повторять параметры ссылки, но таблицы чека на ней нет.
+	// Попытки при этом не уменьшаются: разметка страницы не сверена с настоящими ответами,
+	// и если она отличается, чеки не должны окончательно становиться неудачными
+	page, err := ParseCheckPage(buf)
+	if err != nil {
+		return res, receipts.ErrResponseDataMalformed.Here().Append(err.Error())
+	}
+	if page.FiscalSign != ref.data.FiscalSign {
+		return res, receipts.ErrResponseDataMalformed.Here().
+			Appendf("fiscal sign on the page %q differs from the ref one", page.FiscalSign)
+	}
+
+	res.Data = buf
+	return res, nil
+}
@@ -0,0 +1,39 @@
+package uz_soliq
+
+import (
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/testutils"
+	"testing"
+)
+
+func TestFetchReceipt(t *testing.T) {
+	ref, err := NewReceiptRef("https://ofd.soliq.uz/check?t=UZ191211501001&r=12345&c=20240115143000&s=123456789012")
+	if err != nil {
+		t.Fatalf("NewReceiptRef() error = %v", err)
+	}
+
+	route := "GET /check"
+	testutils.RunFetchCases(t, Domain.NewClient, ref, []testutils.FetchCase{
+		{
+			Name:   "success",
+			Routes: map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/check.html")},
+		},
+		{
+			// разметка не сверена с настоящими страницами, поэтому неразобранная страница попытки не тратит
+			Name:    "receipt not found",
+			Routes:  map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/not_found.html")},
+			WantErr: receipts.ErrResponseDataMalformed,
+		},
+		{
+			Name:                      "404",
+			Routes:                    map[string]testutils.Fixture{route: testutils.HTMLFixture(404, "testdata/not_found.html")},
+			WantErr:                   receipts.ErrUnexpectedHttpStatus,
+			WantShouldDecreaseRetries: true,
+		},
+		{
+			Name:    "maintenance",
+			Routes:  map[string]testutils.Fixture{route: testutils.HTMLFixture(200, "testdata/maintenance.html")},
+			WantErr: receipts.ErrResponseDataMalformed,
+		},
+	})
+}
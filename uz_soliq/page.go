@@ -0,0 +1,135 @@
+package uz_soliq
+
+import (
+	"bytes"
+	"encoding/json"
+	"receipt_qr_scanner/utils"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"golang.org/x/net/html"
+)
+
+// CheckPage — данные со страницы чека ofd.soliq.uz (структурированных данных сервис не отдаёт),
+// сохраняются рядом со страницей и идут в поиск вместо неё
+type CheckPage struct {
+	Seller        string      `json:"seller,omitempty"`
+	SellerTIN     string      `json:"sellerTin,omitempty"` //СТИР (ИНН)
+	Address       string      `json:"address,omitempty"`
+	TerminalID    string      `json:"terminalId,omitempty"` //ФМ
+	ReceiptNumber string      `json:"receiptNumber,omitempty"`
+	FiscalSign    string      `json:"fiscalSign,omitempty"`
+	Items         []CheckItem `json:"items"`
+	Total         float64     `json:"total"`
+}
+
+type CheckItem struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Sum      float64 `json:"sum"`
+}
+
+// строки "Подпись: | значение" в таблицах чека (подписи на узбекском или русском, сравниваются по началу);
+// порядок важен: берётся первое совпадение
+var checkPageFields = []struct {
+	labels []string
+	field  func(*CheckPage) *string
+}{
+	{[]string{"stir", "стир", "инн"}, func(p *CheckPage) *string { return &p.SellerTIN }},
+	{[]string{"manzil", "адрес"}, func(p *CheckPage) *string { return &p.Address }},
+	{[]string{"fm raqami", "фм"}, func(p *CheckPage) *string { return &p.TerminalID }},
+	{[]string{"chek raqami", "номер чека", "чек №"}, func(p *CheckPage) *string { return &p.ReceiptNumber }},
+	{[]string{"fiskal belgi", "фискальный признак", "фп"}, func(p *CheckPage) *string { return &p.FiscalSign }},
+}
+
+var checkPageTotalLabels = []string{"jami to", "итого"}
+
+// ExtractCheckPage — Domain.ExtractReceiptData, результат — JSON CheckPage
+func ExtractCheckPage(buf []byte) ([]byte, error) {
+	page, err := ParseCheckPage(buf)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	res, err := json.Marshal(page)
+	return res, merry.Wrap(err)
+}
+
+// ParseCheckPage разбирает страницу чека: заголовок (h3) — продавец, товары — строки .products-row
+// (название, количество, сумма), остальное — строки таблиц "подпись: значение"
+func ParseCheckPage(buf []byte) (CheckPage, error) {
+	page := CheckPage{Items: []CheckItem{}}
+
+	doc, err := html.Parse(bytes.NewReader(buf))
+	if err != nil {
+		return page, merry.Wrap(err)
+	}
+
+	if node := utils.FindHTMLNode(doc, func(n *html.Node) bool { return n.Data == "h3" }); node != nil {
+		page.Seller = utils.HTMLNodeText(node)
+	}
+
+	for i, row := range utils.FindAllHTMLNodes(doc, utils.HTMLHasClass("products-row")) {
+		cells := utils.HTMLChildElements(row, "td")
+		if len(cells) < 3 {
+			return page, merry.Errorf("item %d: expected 3 cells, got %d", i+1, len(cells))
+		}
+		item := CheckItem{Name: utils.HTMLNodeText(cells[0])}
+		if item.Quantity, err = parseCheckAmount(utils.HTMLNodeText(cells[1])); err != nil {
+			return page, merry.Prependf(err, "item %d quantity", i+1)
+		}
+		if item.Sum, err = parseCheckAmount(utils.HTMLNodeText(cells[2])); err != nil {
+			return page, merry.Prependf(err, "item %d sum", i+1)
+		}
+		page.Items = append(page.Items, item)
+	}
+
+	totalFound := false
+	for _, row := range utils.FindAllHTMLNodes(doc, func(n *html.Node) bool { return n.Data == "tr" }) {
+		cells := utils.HTMLChildElements(row, "td")
+		if len(cells) < 2 {
+			continue
+		}
+		label := strings.ToLower(strings.TrimSuffix(utils.HTMLNodeText(cells[0]), ":"))
+		value := utils.HTMLNodeText(cells[1])
+		if hasAnyPrefix(label, checkPageTotalLabels) {
+			if page.Total, err = parseCheckAmount(value); err != nil {
+				return page, merry.Prepend(err, "total")
+			}
+			totalFound = true
+			continue
+		}
+		for _, f := range checkPageFields {
+			if hasAnyPrefix(label, f.labels) {
+				if field := f.field(&page); *field == "" {
+					*field = value
+				}
+				break
+			}
+		}
+	}
+
+	// фискальный признак и итог есть только на странице найденного чека
+	// (на странице "чек не найден" параметры ссылки могут выводиться, но не в таблице чека)
+	if page.FiscalSign == "" || !totalFound {
+		return page, merry.New("receipt not found on the page")
+	}
+	return page, nil
+}
+
+func hasAnyPrefix(str string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(str, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// "29 000,00" -> 29000
+func parseCheckAmount(str string) (float64, error) {
+	str = strings.Join(strings.Fields(str), "")
+	str = strings.ReplaceAll(str, ",", ".")
+	value, err := strconv.ParseFloat(str, 64)
+	return value, merry.Wrap(err)
+}
@@ -0,0 +1,52 @@
+package uz_soliq
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseCheckPage(t *testing.T) {
+	buf, err := os.ReadFile("testdata/check.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ParseCheckPage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CheckPage{
+		Seller:        `"TEST SAVDO" MChJ`,
+		SellerTIN:     "301234567",
+		Address:       "Toshkent sh., Amir Temur ko'chasi, 1",
+		TerminalID:    "UZ191211501001",
+		ReceiptNumber: "12345",
+		FiscalSign:    "123456789012",
+		Items: []CheckItem{
+			{Name: "Non", Quantity: 1, Sum: 5000},
+			{Name: "Sut 1L", Quantity: 2, Sum: 24000},
+		},
+		Total: 29000,
+	}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("ParseCheckPage():\n  got  %+v\n  want %+v", page, want)
+	}
+
+	// страница "не найден" повторяет параметры ссылки, но чеком не считается
+	for _, fname := range []string{"testdata/not_found.html", "testdata/maintenance.html"} {
+		buf, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseCheckPage(buf); err == nil {
+			t.Errorf("ParseCheckPage(%s) expected error", fname)
+		}
+	}
+
+	// подписи по-русски, строка товара без суммы
+	_, err = ParseCheckPage([]byte(`<table><tr class="products-row"><td>Хлеб</td><td>1</td></tr>
+		<tr><td>Итого:</td><td>5 000,00</td></tr><tr><td>ФП:</td><td>1</td></tr></table>`))
+	if err == nil {
+		t.Errorf("item without sum: expected error")
+	}
+}
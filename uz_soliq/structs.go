@@ -0,0 +1,139 @@
+package uz_soliq
+
+import (
+	"fmt"
+	"net/url"
+	"receipt_qr_scanner/receipts"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+var Domain = receipts.Domain{
+	Code:           "uz-soliq",
+	CurrencySymbol: "сўм",
+	FlagSymbol:     "🇺🇿",
+	Provider: receipts.Provider{
+		Name:       "ГНК Узбекистана (soliq.uz)",
+		ShortLabel: "S",
+		Color:      "#1B5E9E",
+	},
+	ParseReceiptRef: func(refText string) (receipts.ReceiptRef, error) {
+		return NewReceiptRef(refText)
+	},
+	NewClient: func(cfg receipts.ClientConfig) receipts.Client {
+		return &Client{cfg: cfg}
+	},
+	ExtractReceiptData: ExtractCheckPage,
+	ParseReceiptSum: func(data []byte) (float64, error) {
+		page, err := ParseCheckPage(data)
+		return page.Total, merry.Wrap(err)
+	},
+}
+
+func NewReceiptRef(refText string) (ReceiptRef, error) {
+	data, err := parseRefText(refText)
+	if err != nil {
+		return ReceiptRef{}, merry.Wrap(err)
+	}
+	ref := ReceiptRef{text: refText, data: *data}
+	return ref, nil
+}
+
+type ReceiptRef struct {
+	text string
+	data ReceiptRefData
+}
+
+// Суммы в ссылке нет, она есть только на странице чека
+type ReceiptRefData struct {
+	TerminalID    string //ID фискального модуля (виртуальной кассы), например UZ191211501001
+	ReceiptNumber string //порядковый номер чека в фискальном модуле
+	FiscalSign    string //фискальный признак
+	CreatedAt     time.Time
+}
+
+func (r ReceiptRef) String() string {
+	return fmt.Sprintf("Ref{%s:%s}", r.Domain().Code, r.text)
+}
+
+func (r ReceiptRef) Domain() receipts.Domain {
+	return Domain
+}
+
+func (r ReceiptRef) RefText() string {
+	return r.text
+}
+
+func (r ReceiptRef) UniqueKey() string {
+	query := r.text
+	i := strings.LastIndex(r.text, "?")
+	if i != -1 {
+		query = query[i+1:]
+	}
+	items := strings.Split(query, "&")
+	sort.Strings(items)
+	return r.Domain().Code + ":" + strings.Join(items, "&")
+}
+
+// FiscalKey — фискальный модуль, номер чека и фискальный признак
+func (r ReceiptRef) FiscalKey() string {
+	d := r.data
+	return "uz:" + d.TerminalID + ":" + d.ReceiptNumber + ":" + d.FiscalSign
+}
+
+func (r ReceiptRef) CreatedAt() time.Time {
+	return r.data.CreatedAt
+}
+
+// Sum — в ссылке суммы нет, она берётся со страницы чека после скачивания (см. Domain.ParseReceiptSum)
+func (r ReceiptRef) Sum() float64 {
+	return 0
+}
+
+func (r ReceiptRef) SearchKeyItems() []string {
+	return []string{
+		"_created_at:" + r.data.CreatedAt.Format("2006-01-02 15:04"),
+		"_terminal_id:" + r.data.TerminalID,
+		"_receipt_number:" + r.data.ReceiptNumber,
+		"_fiscal_sign:" + r.data.FiscalSign,
+	}
+}
+
+func parseRefText(refText string) (*ReceiptRefData, error) {
+	// https://ofd.soliq.uz/check?t=UZ191211501001&r=12345&c=20240115143000&s=123456789012
+	u, err := url.Parse(refText)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	if u.Host != "ofd.soliq.uz" {
+		return nil, merry.Errorf("unexpected host: %s", u.Host)
+	}
+
+	query := u.Query()
+	var data ReceiptRefData
+	data.TerminalID, err = receipts.ReadString(query, "t")
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	data.ReceiptNumber, err = receipts.ReadString(query, "r")
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	data.FiscalSign, err = receipts.ReadString(query, "s")
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	createdAtStr, err := receipts.ReadString(query, "c")
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	data.CreatedAt, err = time.Parse("20060102150405", createdAtStr)
+	if err != nil {
+		return nil, receipts.ReceiptRefFieldErr{Name: "c", ValueStr: createdAtStr}
+	}
+	return &data, nil
+}
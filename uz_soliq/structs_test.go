@@ -0,0 +1,65 @@
+package uz_soliq
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReceiptRef(t *testing.T) {
+	tests := []struct {
+		qrText        string
+		wantDate      string
+		wantUniqKey   string
+		wantFiscalKey string
+		wantSearchKey string
+	}{
+		{
+			qrText:        "https://ofd.soliq.uz/check?t=UZ191211501001&r=12345&c=20240115143000&s=123456789012",
+			wantDate:      "2024-01-15 14:30:00",
+			wantUniqKey:   "uz-soliq:c=20240115143000&r=12345&s=123456789012&t=UZ191211501001",
+			wantFiscalKey: "uz:UZ191211501001:12345:123456789012",
+			wantSearchKey: "_created_at:2024-01-15 14:30   _terminal_id:UZ191211501001   _receipt_number:12345   _fiscal_sign:123456789012",
+		},
+		{
+			qrText:        "http://ofd.soliq.uz/check?c=20231231235959&s=000987654321&t=EP000000000123&r=7",
+			wantDate:      "2023-12-31 23:59:59",
+			wantUniqKey:   "uz-soliq:c=20231231235959&r=7&s=000987654321&t=EP000000000123",
+			wantFiscalKey: "uz:EP000000000123:7:000987654321",
+			wantSearchKey: "_created_at:2023-12-31 23:59   _terminal_id:EP000000000123   _receipt_number:7   _fiscal_sign:000987654321",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qrText, func(t *testing.T) {
+			ref, err := NewReceiptRef(tt.qrText)
+			if err != nil {
+				t.Fatalf("NewReceiptRef() error = %v", err)
+			}
+			wantTime, _ := time.Parse("2006-01-02 15:04:05", tt.wantDate)
+			if !ref.CreatedAt().Equal(wantTime) {
+				t.Errorf("CreatedAt() = %v, want %v", ref.CreatedAt(), wantTime)
+			}
+			if ref.UniqueKey() != tt.wantUniqKey {
+				t.Errorf("UniqueKey() = %v, want %v", ref.UniqueKey(), tt.wantUniqKey)
+			}
+			if ref.FiscalKey() != tt.wantFiscalKey {
+				t.Errorf("FiscalKey() = %v, want %v", ref.FiscalKey(), tt.wantFiscalKey)
+			}
+			if !slices.Equal(ref.SearchKeyItems(), strings.Split(tt.wantSearchKey, "   ")) {
+				t.Errorf("SearchKeyItems() = %v, want %v", ref.SearchKeyItems(), strings.Split(tt.wantSearchKey, "   "))
+			}
+		})
+	}
+
+	for _, qrText := range []string{
+		"https://ofd.soliq.uz/check?t=UZ191211501001&r=12345&c=20240115143000",
+		"https://ofd.soliq.uz/check?t=UZ191211501001&r=12345&c=2024-01-15&s=123456789012",
+		"https://soliq.example.com/check?t=UZ191211501001&r=12345&c=20240115143000&s=123456789012",
+	} {
+		if _, err := NewReceiptRef(qrText); err == nil {
+			t.Errorf("NewReceiptRef(%s) error = nil, want error", qrText)
+		}
+	}
+}
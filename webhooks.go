@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"receipt_qr_scanner/receipts"
+	"receipt_qr_scanner/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/rs/zerolog/log"
+)
+
+const webhooksComponent = "webhooks"
+
+const defaultWebhookTimeout = 30 * time.Second
+
+// паузы между повторами неудачной доставки: от минимальной, удваиваясь до максимальной.
+// Доставка повторяется, пока не удастся (или пока вебхук не уберут из конфига).
+var webhookRetryMinDelay = 30 * time.Second
+var webhookRetryMaxDelay = 6 * time.Hour
+
+// сколько доставок отправлять за одну итерацию
+const webhookDeliveriesBatch = 20
+
+// WebhookPayload — тело POST-запроса вебхука
+type WebhookPayload struct {
+	Event     string            `json:"event"`
+	EmittedAt time.Time         `json:"emittedAt"`
+	Receipt   *receipts.Receipt `json:"receipt"`
+}
+
+// Webhooks ставит события чеков в очередь (таблица webhook_deliveries) и доставляет их
+// POST-запросами на адреса из конфига. Тело подписывается HMAC-SHA256 секретом вебхука,
+// подпись в заголовке X-Receipt-Signature: sha256=<hex>.
+type Webhooks struct {
+	db       *sql.DB
+	cfg      *Config
+	clients  map[string]*http.Client
+	wakeChan chan struct{}
+}
+
+func NewWebhooks(db *sql.DB, cfg *Config) (*Webhooks, error) {
+	w := &Webhooks{db: db, cfg: cfg, clients: map[string]*http.Client{}, wakeChan: make(chan struct{}, 1)}
+	for _, hook := range cfg.Webhooks {
+		httpCfg := utils.DefaultHTTPClientConfig
+		httpCfg.Timeout = defaultWebhookTimeout
+		if hook.Timeout.Duration > 0 {
+			httpCfg.Timeout = hook.Timeout.Duration
+		}
+		if hook.Proxy != "" {
+			proxyURL, err := utils.ParseProxyURL(hook.Proxy)
+			if err != nil {
+				return nil, merry.Wrap(err)
+			}
+			httpCfg.Proxy = proxyURL
+		}
+		w.clients[hook.Name] = utils.NewHTTPClient("webhook:"+hook.Name, httpCfg)
+	}
+	return w, nil
+}
+
+func (w *Webhooks) IsEnabled() bool {
+	return w != nil && len(w.cfg.Webhooks) > 0
+}
+
+// HandleReceiptEvent ставит событие чека в очередь всех подписанных на него вебхуков
+func (w *Webhooks) HandleReceiptEvent(event string, receiptID int64) error {
+	if !w.IsEnabled() {
+		return nil
+	}
+	var hookNames []string
+	for _, hook := range w.cfg.Webhooks {
+		if hook.HandlesEvent(event) {
+			hookNames = append(hookNames, hook.Name)
+		}
+	}
+	if len(hookNames) == 0 {
+		return nil
+	}
+
+	rec, err := loadReceipt(w.db, receiptID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	payload, err := json.Marshal(WebhookPayload{Event: event, EmittedAt: time.Now().UTC(), Receipt: rec})
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	for _, name := range hookNames {
+		if err := saveWebhookDelivery(w.db, name, event, receiptID, payload); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	select {
+	case w.wakeChan <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run доставляет события из очереди, пока не отменён ctx
+func (w *Webhooks) Run(ctx context.Context, health *Health) error {
+	timer := time.NewTimer(time.Hour)
+	for {
+		if err := w.deliverDue(ctx); err != nil {
+			return merry.Wrap(err)
+		}
+		health.SetOK(webhooksComponent)
+
+		nextAttemptAt, err := loadNextWebhookAttemptTime(w.db, w.hookNames())
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		delay := time.Until(nextAttemptAt)
+		if delay < time.Second {
+			delay = time.Second
+		}
+
+		if !timer.Stop() && len(timer.C) > 0 {
+			<-timer.C
+		}
+		timer.Reset(delay)
+
+		select {
+		case <-w.wakeChan:
+		case <-timer.C:
+		case <-ctx.Done():
+			log.Info().Msg("webhooks stopped")
+			return nil
+		}
+	}
+}
+
+func (w *Webhooks) hookNames() []string {
+	names := make([]string, len(w.cfg.Webhooks))
+	for i, hook := range w.cfg.Webhooks {
+		names[i] = hook.Name
+	}
+	return names
+}
+
+// deliverDue отправляет доставки, время которых пришло. Ошибки доставки (недоступный адрес,
+// не-2xx ответ) сохраняются в очередь для повтора, наружу возвращаются только ошибки БД.
+func (w *Webhooks) deliverDue(ctx context.Context) error {
+	for ctx.Err() == nil {
+		deliveries, err := loadDueWebhookDeliveries(w.db, w.hookNames(), webhookDeliveriesBatch)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		for _, d := range deliveries {
+			if ctx.Err() != nil {
+				return nil
+			}
+			hook := w.cfg.Webhook(d.Webhook)
+			sendErr := w.send(ctx, hook, d)
+			if sendErr != nil && ctx.Err() != nil {
+				return nil //прервано остановкой, попытку не считаем
+			}
+			if sendErr == nil {
+				log.Debug().Str("webhook", d.Webhook).Str("event", d.Event).Int64("receipt_id", d.ReceiptID).Msg("webhook delivered")
+				err = saveWebhookDelivered(w.db, d.ID)
+			} else {
+				delay := webhookRetryDelay(d.Attempts)
+				log.Warn().Err(sendErr).Str("webhook", d.Webhook).Int64("delivery_id", d.ID).
+					Int64("attempts", d.Attempts+1).Str("retry_in", delay.String()).Msg("webhook delivery failed")
+				err = saveWebhookDeliveryFailure(w.db, d.ID, sendErr, delay)
+			}
+			if err != nil {
+				return merry.Wrap(err)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Webhooks) send(ctx context.Context, hook WebhookConfig, d *WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Receipt-Event", d.Event)
+	req.Header.Set("X-Receipt-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Receipt-Signature", signWebhookPayload(hook.Secret, d.Payload))
+
+	resp, err := w.clients[hook.Name].Do(req)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return merry.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signWebhookPayload — значение заголовка X-Receipt-Signature
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookRetryDelay(prevAttempts int64) time.Duration {
+	delay := webhookRetryMinDelay
+	for i := int64(0); i < prevAttempts && delay < webhookRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMaxDelay)
+}
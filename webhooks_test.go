@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"receipt_qr_scanner/receipts"
+	"sync"
+	"testing"
+)
+
+func TestWebhooksDelivery(t *testing.T) {
+	db := openTestDB(t)
+
+	type request struct {
+		Event     string
+		Signature string
+		Body      []byte
+	}
+	var requests []request
+	var mutex sync.Mutex
+	failNext := true
+	srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, request{r.Header.Get("X-Receipt-Event"), r.Header.Get("X-Receipt-Signature"), body})
+		if failNext {
+			failNext = false
+			http.Error(wr, "try later", 503)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &Config{Webhooks: []WebhookConfig{
+		{Name: "all", URL: srv.URL + "/all", Secret: "secret"},
+		{Name: "fetched", URL: srv.URL + "/fetched", Secret: "secret", Events: []string{EventReceiptFetched}},
+	}}
+	webhooks, err := NewWebhooks(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := receipts.ReceiptRefFromText(allDomains, "https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recID, err := saveRecieptRef(db, ref, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := webhooks.HandleReceiptEvent(EventReceiptAdded, recID); err != nil {
+		t.Fatalf("HandleReceiptEvent() error = %v", err)
+	}
+
+	// первая доставка неудачна и откладывается
+	if err := webhooks.deliverDue(context.Background()); err != nil {
+		t.Fatalf("deliverDue() error = %v", err)
+	}
+	var attempts int64
+	var lastError string
+	var isDelivered bool
+	err = db.QueryRow(`SELECT attempts, COALESCE(last_error, ''), delivered_at IS NOT NULL FROM webhook_deliveries`).
+		Scan(&attempts, &lastError, &isDelivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 || isDelivered || lastError == "" {
+		t.Errorf("delivery attempts=%d delivered=%v last_error=%q, want 1 failed attempt", attempts, isDelivered, lastError)
+	}
+
+	// повтор после паузы
+	if _, err := db.Exec(`UPDATE webhook_deliveries SET next_attempt_at = datetime(CURRENT_TIMESTAMP, '-1 seconds')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := webhooks.deliverDue(context.Background()); err != nil {
+		t.Fatalf("deliverDue() error = %v", err)
+	}
+	err = db.QueryRow(`SELECT attempts, COALESCE(last_error, ''), delivered_at IS NOT NULL FROM webhook_deliveries`).
+		Scan(&attempts, &lastError, &isDelivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || !isDelivered || lastError != "" {
+		t.Errorf("delivery attempts=%d delivered=%v last_error=%q, want delivered on 2nd attempt", attempts, isDelivered, lastError)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("requests count = %d, want 2", len(requests))
+	}
+	req := requests[1]
+	if req.Event != EventReceiptAdded {
+		t.Errorf("event header = %q, want %q", req.Event, EventReceiptAdded)
+	}
+	if want := signWebhookPayload("secret", req.Body); req.Signature != want {
+		t.Errorf("signature = %q, want %q", req.Signature, want)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(req.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != EventReceiptAdded || payload.Receipt == nil || payload.Receipt.ID != recID || payload.Receipt.RefText != ref.RefText() {
+		t.Errorf("payload = %+v, want %s for receipt #%d", payload, EventReceiptAdded, recID)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	for attempts, want := range map[int64]string{0: "30s", 1: "1m0s", 3: "4m0s", 20: "6h0m0s"} {
+		if got := webhookRetryDelay(attempts).String(); got != want {
+			t.Errorf("webhookRetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"receipt_qr_scanner/receipts"
+	"strconv"
+	"time"
+
+	httputils "github.com/3bl3gamer/go-http-utils"
+	"github.com/ansel1/merry"
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+)
+
+const wsWriteTimeout = 10 * time.Second
+const wsPingInterval = 30 * time.Second
+const wsPongTimeout = 2 * wsPingInterval
+const wsMaxMessageSize = 64 * 1024
+
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// wsClientMessage — сообщение от клиента:
+//
+//	{"type": "subscribe", "search": "...", "sortMode": "...", "order": "...", "limit": 25, "lastEventId": "..."} —
+//	получать чеки (только подходящие под поиск), в ответ initial_receipts с первой страницей и nextCursor
+//	или, если передан eventId последнего полученного события и его ещё помнит сервер, resumed и пропущенные события;
+//	{"type": "load_page", "requestId": "...", "search": "...", "sortMode": "...", "order": "...", "cursor": "...", "limit": 25} —
+//	следующая страница (cursor — nextCursor прошлой), в ответ receipts_page с тем же requestId, подписка не меняется;
+//	{"type": "submit", "requestId": "...", "refText": "..."} — добавить чек, в ответ submit_result с тем же requestId.
+//
+// sortMode, order, cursor и limit — как sort_mode, order, cursor и limit у /api/receipts_list.
+type wsClientMessage struct {
+	Type        string `json:"type"`
+	RequestID   string `json:"requestId"`
+	RefText     string `json:"refText"`
+	Search      string `json:"search"`
+	SortMode    string `json:"sortMode"`
+	Order       string `json:"order"`
+	Cursor      string `json:"cursor"`
+	Limit       int    `json:"limit"`
+	LastEventID string `json:"lastEventId"`
+}
+
+// listQuery — параметры страницы списка чеков (проверяются так же, как у /api/receipts_list)
+func (m wsClientMessage) listQuery() (ReceiptsListQuery, *httputils.JsonError) {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"search": m.Search, "sort_mode": m.SortMode, "order": m.Order, "cursor": m.Cursor,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if m.Limit != 0 {
+		query.Set("limit", strconv.Itoa(m.Limit))
+	}
+	return receiptsListQueryFromURL(query)
+}
+
+// wsServerMessage — сообщение клиенту: receipt, receipt_deleted, updater_status (см. BroadcastEvent),
+// initial_receipts, resumed, receipts_page, submit_result или error
+type wsServerMessage struct {
+	Type          string              `json:"type"`
+	EventID       string              `json:"eventId,omitempty"` //см. ReceiptsBroadcaster.EventID
+	RequestID     string              `json:"requestId,omitempty"`
+	Receipt       *receipts.Receipt   `json:"receipt,omitempty"`
+	Receipts      []*receipts.Receipt `json:"receipts,omitempty"`
+	NextCursor    string              `json:"nextCursor,omitempty"` //для initial_receipts и receipts_page
+	ReceiptID     int64               `json:"receiptId,omitempty"`
+	UpdaterStatus *ComponentStatus    `json:"updaterStatus,omitempty"`
+	// для submit_result — то же, что отвечает POST /api/receipt
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// HandleAPIWS — WebSocket-канал: клиент добавляет чеки и подписывается на обновления,
+// сервер присылает обновления чеков и состояние апдейтера.
+func (b *ReceiptsBroadcaster) HandleAPIWS(wr http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	db := r.Context().Value(CtxKeyDB).(*sql.DB)
+	health := r.Context().Value(CtxKeyHealth).(*Health)
+
+	// до Upgrade, пока сервер ещё сам ждёт этот запрос
+	b.wsHandlers.Add(1)
+	defer b.wsHandlers.Done()
+
+	conn, err := wsUpgrader.Upgrade(wr, r, nil)
+	if err != nil {
+		log.Debug().Err(err).Msg("WS: upgrade failed")
+		return nil //Upgrade уже ответил клиенту
+	}
+	defer conn.Close()
+
+	// до подписки на чеки приходит только состояние апдейтера
+	sub, _ := b.Subscribe(SubscriptionFilter{}, "")
+	defer func() { b.Unsubscribe(sub) }()
+
+	done := make(chan struct{})
+	defer close(done)
+	messagesChan := make(chan wsClientMessage)
+	go readWSMessages(conn, messagesChan, done)
+
+	write := func(msg wsServerMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return merry.Wrap(conn.WriteJSON(msg))
+	}
+
+	updaterStatus := health.Status(updaterComponent)
+	if err := write(wsServerMessage{Type: BroadcastUpdaterStatus, UpdaterStatus: &updaterStatus}); err != nil {
+		return nil
+	}
+
+	pingTicker := time.NewTicker(wsPingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		var msgs []wsServerMessage
+		select {
+		case clientMsg, ok := <-messagesChan:
+			if !ok {
+				log.Debug().Msg("WS: client closed connection")
+				return nil
+			}
+			switch clientMsg.Type {
+			case "subscribe":
+				listQuery, jsonErr := clientMsg.listQuery()
+				if jsonErr != nil {
+					msgs = append(msgs, wsServerMessage{Type: "error", RequestID: clientMsg.RequestID, Error: jsonErr.Error})
+					break
+				}
+				// новая подписка — до загрузки списка, чтоб не пропустить обновления между ними
+				b.Unsubscribe(sub)
+				filter := SubscriptionFilter{Receipts: true, Search: clientMsg.Search}
+				var isResumed bool
+				sub, isResumed = b.Subscribe(filter, clientMsg.LastEventID)
+				if isResumed {
+					msgs = append(msgs, wsServerMessage{Type: "resumed"})
+					break
+				}
+				recs, nextCursor, err := loadReceiptsPage(db, listQuery)
+				if err != nil {
+					// соединение уже перехвачено, обычный ответ с ошибкой отправить нельзя
+					log.Error().Stack().Err(err).Msg("WS: receipts loading failed")
+					b.Unsubscribe(sub)
+					sub, _ = b.Subscribe(SubscriptionFilter{}, "")
+					msgs = append(msgs, wsServerMessage{Type: "error", Error: "SERVER_ERROR"})
+					break
+				}
+				msg := wsServerMessage{Type: "initial_receipts", EventID: b.EventID(sub.StartSeq()), Receipts: ensureRecsNotNil(recs)}
+				if nextCursor != nil {
+					msg.NextCursor = nextCursor.Encode()
+				}
+				msgs = append(msgs, msg)
+			case "load_page":
+				listQuery, jsonErr := clientMsg.listQuery()
+				if jsonErr != nil {
+					msgs = append(msgs, wsServerMessage{Type: "error", RequestID: clientMsg.RequestID, Error: jsonErr.Error})
+					break
+				}
+				recs, nextCursor, err := loadReceiptsPage(db, listQuery)
+				if err != nil {
+					log.Error().Stack().Err(err).Msg("WS: receipts loading failed")
+					msgs = append(msgs, wsServerMessage{Type: "error", RequestID: clientMsg.RequestID, Error: "SERVER_ERROR"})
+					break
+				}
+				msg := wsServerMessage{Type: "receipts_page", RequestID: clientMsg.RequestID, Receipts: ensureRecsNotNil(recs)}
+				if nextCursor != nil {
+					msg.NextCursor = nextCursor.Encode()
+				}
+				msgs = append(msgs, msg)
+			case "submit":
+				res, err := submitReceiptRefText(r.Context(), clientMsg.RefText)
+				if err != nil {
+					log.Error().Stack().Err(err).Msg("WS: receipt submit failed")
+					res = &httputils.JsonError{Code: 500, Error: "SERVER_ERROR"}
+				}
+				msgs = append(msgs, wsServerMessage{Type: "submit_result", RequestID: clientMsg.RequestID, Result: res})
+			default:
+				msgs = append(msgs, wsServerMessage{Type: "error", RequestID: clientMsg.RequestID, Error: "WRONG_MESSAGE_TYPE"})
+			}
+		case <-sub.Notify():
+			events, isOpen := sub.Take()
+			for _, ev := range events {
+				msg := wsServerMessage{Type: ev.Kind, EventID: b.EventID(ev.Seq)}
+				switch ev.Kind {
+				case BroadcastReceipt:
+					msg.Receipt = ev.Receipt
+				case BroadcastReceiptDeleted:
+					msg.ReceiptID = ev.ReceiptID
+				case BroadcastUpdaterStatus:
+					msg.UpdaterStatus = ev.UpdaterStatus
+				}
+				msgs = append(msgs, msg)
+			}
+			if !isOpen {
+				for _, msg := range msgs {
+					if err := write(msg); err != nil {
+						return nil
+					}
+				}
+				log.Debug().Msg("WS: broadcaster closed or client is too slow, closing connection")
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+				return nil
+			}
+		case <-pingTicker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return nil
+			}
+		}
+
+		for _, msg := range msgs {
+			if err := write(msg); err != nil {
+				log.Debug().Err(err).Msg("WS: write failed, closing connection")
+				return nil
+			}
+		}
+	}
+}
+
+// readWSMessages читает сообщения клиента, пока соединение не закроется (тогда закрывает messagesChan).
+// Неразобранные сообщения передаются с пустым типом (в ответ уйдёт ошибка).
+func readWSMessages(conn *websocket.Conn, messagesChan chan wsClientMessage, done chan struct{}) {
+	defer close(messagesChan)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		return nil
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			msg = wsClientMessage{}
+		}
+		select {
+		case messagesChan <- msg:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"receipt_qr_scanner/receipts"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestHandleAPIWS(t *testing.T) {
+	db := openTestDB(t)
+	b := NewReceiptsBroadcaster()
+	health := NewHealth(updaterComponent)
+	updatedReceiptIDsChan := make(chan int64, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CtxKeyDB, db)
+		ctx = context.WithValue(ctx, CtxKeyConfig, &Config{})
+		ctx = context.WithValue(ctx, CtxKeyHealth, health)
+		ctx = context.WithValue(ctx, CtxKeyEvents, ReceiptEvents(nil))
+		ctx = context.WithValue(ctx, CtxKeyTrigger, make(chan struct{}, 10))
+		ctx = context.WithValue(ctx, CtxKeyUpdateRec, updatedReceiptIDsChan)
+		if err := b.HandleAPIWS(wr, r.WithContext(ctx), httprouter.Params{}); err != nil {
+			t.Errorf("HandleAPIWS() error = %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	read := func() wsServerMessage {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg wsServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("reading message: %v", err)
+		}
+		return msg
+	}
+
+	if msg := read(); msg.Type != BroadcastUpdaterStatus || msg.UpdaterStatus == nil || !msg.UpdaterStatus.IsOK {
+		t.Errorf("first message = %+v, want updater status", msg)
+	}
+
+	// добавление чека
+	refText := "https://app.kassa.wipon.kz/consumer?i=5612340017&f=600407890704&s=2750.00&t=20260310T093045"
+	if err := conn.WriteJSON(wsClientMessage{Type: "submit", RequestID: "r1", RefText: refText}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != "submit_result" || msg.RequestID != "r1" {
+		t.Errorf("submit reply = %+v", msg)
+	} else if res, _ := msg.Result.(map[string]interface{}); res["ok"] != true {
+		t.Errorf("submit result = %+v, want ok", msg.Result)
+	}
+	if err := conn.WriteJSON(wsClientMessage{Type: "submit", RequestID: "r2", RefText: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != "submit_result" || msg.RequestID != "r2" {
+		t.Errorf("submit reply = %+v", msg)
+	} else if res, _ := msg.Result.(map[string]interface{}); res["ok"] != false || res["code"] != 400.0 {
+		t.Errorf("submit result = %+v, want 400 error", msg.Result)
+	}
+	recID := <-updatedReceiptIDsChan
+
+	// подписка с поиском
+	if err := conn.WriteJSON(wsClientMessage{Type: "subscribe", Search: "5612340017"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != "initial_receipts" || len(msg.Receipts) != 1 || msg.Receipts[0].ID != recID {
+		t.Errorf("subscribe reply = %+v, want initial_receipts with #%d", msg, recID)
+	}
+
+	rec, err := loadReceipt(db, recID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.broadcast(BroadcastEvent{Kind: BroadcastReceipt, Receipt: &receipts.Receipt{ID: 999, SearchKey: "something else"}})
+	b.broadcast(BroadcastEvent{Kind: BroadcastReceipt, Receipt: rec})
+	receiptMsg := read()
+	if receiptMsg.Type != BroadcastReceipt || receiptMsg.Receipt == nil || receiptMsg.Receipt.ID != recID || receiptMsg.EventID == "" {
+		t.Errorf("receipt message = %+v, want receipt #%d", receiptMsg, recID)
+	}
+	b.broadcast(BroadcastEvent{Kind: BroadcastReceiptDeleted, ReceiptID: recID})
+	if msg := read(); msg.Type != BroadcastReceiptDeleted || msg.ReceiptID != recID {
+		t.Errorf("deletion message = %+v, want #%d", msg, recID)
+	}
+
+	// переподписка с eventId последнего полученного события: список не присылается, только пропущенное
+	if err := conn.WriteJSON(wsClientMessage{Type: "subscribe", Search: "5612340017", LastEventID: receiptMsg.EventID}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != "resumed" {
+		t.Errorf("resubscribe reply = %+v, want resumed", msg)
+	}
+	if msg := read(); msg.Type != BroadcastReceiptDeleted || msg.ReceiptID != recID {
+		t.Errorf("missed message = %+v, want deletion of #%d", msg, recID)
+	}
+
+	// постраничная загрузка по курсору, как у /api/receipts_list
+	ref2, err := receipts.ReceiptRefFromText(allDomains, "t=20260301T1000&s=300.00&fn=9999078900012345&i=10&fp=1000000001&n=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recID2, err := saveRecieptRef(db, ref2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(wsClientMessage{Type: "subscribe", SortMode: "id", Order: "asc", Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	firstPage := read()
+	if firstPage.Type != "initial_receipts" || len(firstPage.Receipts) != 1 || firstPage.Receipts[0].ID != recID || firstPage.NextCursor == "" {
+		t.Fatalf("subscribe reply = %+v, want #%d and next cursor", firstPage, recID)
+	}
+	if err := conn.WriteJSON(wsClientMessage{Type: "load_page", RequestID: "p2", SortMode: "id", Order: "asc", Limit: 1, Cursor: firstPage.NextCursor}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != "receipts_page" || msg.RequestID != "p2" || len(msg.Receipts) != 1 || msg.Receipts[0].ID != recID2 || msg.NextCursor != "" {
+		t.Errorf("load_page reply = %+v, want #%d without next cursor", msg, recID2)
+	}
+	if err := conn.WriteJSON(wsClientMessage{Type: "load_page", RequestID: "p3", SortMode: "id", Cursor: firstPage.NextCursor}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != "error" || msg.RequestID != "p3" || msg.Error != "WRONG_CURSOR" {
+		t.Errorf("load_page with cursor of other order = %+v, want WRONG_CURSOR", msg)
+	}
+
+	// пока соединение открыто, обработчик работает и может добавлять чеки
+	shortCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.WaitWSHandlers(shortCtx); err == nil {
+		t.Errorf("WaitWSHandlers() with open connection = nil, want timeout")
+	}
+
+	// после закрытия рассыльщика сервер закрывает соединение
+	b.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("read after Close() error = %v, want close going away", err)
+	}
+	waitCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.WaitWSHandlers(waitCtx); err != nil {
+		t.Errorf("WaitWSHandlers() after Close() = %v", err)
+	}
+}
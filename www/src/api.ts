@@ -10,7 +10,14 @@ export type Receipt = {
 	createdAt: string
 	refText: string
 	isCorrect: boolean
+	isRefund: boolean
+	sum: number
+	refundOfId?: number
+	/** Своя валюта чека (у введённых вручную), иначе — валюта домена */
+	currency?: string
 	data: string
+	/** Данные, извлечённые сервером из HTML-страницы чека (kz-bee, kz-ttc), JSON */
+	extractedData?: string
 	searchKey: string
 	retriesLeft: number
 	nextRetryAt: string
@@ -61,23 +68,32 @@ export function fetchReceipts(
 		.then(r => r.json())
 }
 
+/**
+ * lastEventId — ID последнего полученного события: если сервер его ещё помнит,
+ * initial_receipts не придёт, придут только пропущенные обновления
+ */
 export function makeReceiptsEventSource(
 	sortMode: ReceiptsSortMode,
 	searchQuery: string,
+	lastEventId: string,
 	handlers: {
 		onInitialReceipts: (receipts: Receipt[]) => unknown
 		onReceipt: (receipt: Receipt) => unknown
+		onEventId: (eventId: string) => unknown
 		onError: () => unknown
 	},
 ): EventSource {
-	const path = makeReceiptsListPath(true, sortMode, searchQuery)
+	let path = makeReceiptsListPath(true, sortMode, searchQuery)
+	if (lastEventId) path += '&last_event_id=' + encodeURIComponent(lastEventId)
 	const eventSource = new EventSource(path)
 
 	eventSource.addEventListener('initial_receipts', (event: MessageEvent) => {
 		handlers.onInitialReceipts(JSON.parse(event.data) as Receipt[])
+		handlers.onEventId(event.lastEventId)
 	})
 	eventSource.addEventListener('receipt', (event: MessageEvent) => {
 		handlers.onReceipt(JSON.parse(event.data) as Receipt)
+		handlers.onEventId(event.lastEventId)
 	})
 	eventSource.addEventListener('error', () => {
 		handlers.onError()
@@ -230,7 +230,9 @@ function ReceiptListItem({
 		onClick(receipt)
 	}, [onClick, receipt])
 
-	const currencySuffix = data ? ' ' + (domainsMetadata.get(receipt.domain)?.currencySymbol ?? '?') : ''
+	const currencySuffix = data
+		? ' ' + (receipt.currency || domainsMetadata.get(receipt.domain)?.currencySymbol || '?')
+		: ''
 
 	const hasParseErrors = data && data.parseErrors.length > 0
 
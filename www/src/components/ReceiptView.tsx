@@ -124,12 +124,20 @@ const extraLabels: Record<keyof UnionToIntersection<FullReceiptData['extra']>, [
 	kz_orgId: ['БИН', 'Бизнес-идентификационный номер организации'],
 	kz_kkmInkNumber: ['ИНК', 'Идентификационный номер кассы'],
 	kz_receiptNumber: ['Чек №', 'Порядковый номер чека'],
+
+	uz_terminalId: ['ФМ', 'Фискальный модуль (виртуальная касса)'],
+	uz_receiptNumber: ['Чек №', 'Номер чека в фискальном модуле'],
+	uz_fiscalSign: ['ФП', 'Фискальный признак'],
+	uz_sellerTin: ['СТИР', 'Идентификационный номер налогоплательщика'],
+
+	manual_currency: ['Валюта', null],
 }
 
 const orgIdNumberPropNames = [
 	'ru_orgInn', //
 	'kg_orgInn',
 	'kz_orgId',
+	'uz_sellerTin',
 ] satisfies (keyof typeof extraLabels)[]
 const contactsPropNames = [
 	'ru_buyerPhoneNumber',
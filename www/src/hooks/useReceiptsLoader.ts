@@ -30,13 +30,15 @@ export function useReceiptsLoader(
 
 		let eventSource: EventSource | null = null
 		let hasReceivedInitialChunk = false
+		let lastEventId = ''
 		start()
 
 		function start() {
 			stop()
-			eventSource = makeReceiptsEventSource(sortMode, searchQuery, {
+			eventSource = makeReceiptsEventSource(sortMode, searchQuery, lastEventId, {
 				onInitialReceipts,
 				onReceipt,
+				onEventId,
 				onError,
 			})
 		}
@@ -65,6 +67,9 @@ export function useReceiptsLoader(
 				}
 			})
 		}
+		function onEventId(eventId: string) {
+			lastEventId = eventId
+		}
 		function onError() {
 			stop()
 			setTimeout(() => {
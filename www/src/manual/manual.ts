@@ -0,0 +1,44 @@
+import { Receipt } from '../api'
+import { ReceiptData } from '../receipts'
+import { isRecord, optArr, optNum, OptStr, optStr } from '../utils'
+
+type ManualExtraData = {
+	/** Символ валюты, у введённых вручную чеков он свой у каждого */
+	manual_currency: OptStr
+}
+export function getManualReceiptDataFrom(rec: Receipt): ReceiptData<ManualExtraData> {
+	const data: Record<string, unknown> = JSON.parse(rec.data)
+	return {
+		common: {
+			title: optStr(data.seller),
+
+			items: optArr(data.items, []).map(item => {
+				const x = isRecord(item) ? item : { name: item }
+				return {
+					name: optStr(x.name),
+					quantity: optNum(x.quantity),
+					price: optNum(x.price),
+					sum: optNum(x.sum),
+				}
+			}),
+			itemsCount: optArr(data.items)?.length,
+			totalSum: optNum(data.sum),
+
+			orgName: undefined,
+			placeName: optStr(data.seller),
+			placeAddress: undefined,
+
+			cashierName: undefined,
+			cashierCode: undefined,
+			shiftNumber: undefined,
+
+			taxOrgUrl: undefined,
+			checkOrgUrl: undefined,
+		},
+		extra: {
+			manual_currency: optStr(data.currency),
+		},
+		parseErrors: [],
+		raw: data,
+	}
+}
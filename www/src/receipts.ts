@@ -6,7 +6,9 @@ import { getKzKtcReceiptDataFrom } from './kz-ktc/kz-ktc'
 import { getKzTtcReceiptDataFrom } from './kz-ttc/kz-ttc'
 import { getKzWfdReceiptDataFrom } from './kz-wfd/kz-wfd'
 import { getKzWipReceiptDataFrom } from './kz-wip/kz-wip'
+import { getManualReceiptDataFrom } from './manual/manual'
 import { getRuFnsReceiptDataFrom } from './ru-fns/ru-fns'
+import { getUzSoliqReceiptDataFrom } from './uz-soliq/uz-soliq'
 import { OptNum, OptStr } from './utils'
 
 export type ReceiptData<T extends Record<string, unknown>> = {
@@ -58,6 +60,8 @@ const domainParsersMap = {
 	'kz-bee': getKzBeeReceiptDataFrom,
 	'kz-wfd': getKzWfdReceiptDataFrom,
 	'kz-wip': getKzWipReceiptDataFrom,
+	'uz-soliq': getUzSoliqReceiptDataFrom,
+	manual: getManualReceiptDataFrom,
 }
 
 export function getReceiptDataFrom(rec: Receipt): FullReceiptData | null {
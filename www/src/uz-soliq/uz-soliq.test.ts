@@ -0,0 +1,46 @@
+import test from 'node:assert/strict'
+import { it, suite as describe } from 'node:test'
+
+import { Receipt } from '../api'
+import { getUzSoliqReceiptDataFrom } from './uz-soliq'
+
+describe('getUzSoliqReceiptDataFrom', () => {
+	const rec = {
+		domain: 'uz-soliq',
+		refText: 'https://ofd.soliq.uz/check?t=UZ191211501001&r=12345&c=20240115143000&s=123456789012',
+		data: '<html>...</html>',
+	} as Receipt
+
+	it('should use data extracted by the server', () => {
+		const result = getUzSoliqReceiptDataFrom({
+			...rec,
+			extractedData: JSON.stringify({
+				seller: '"TEST SAVDO" MChJ',
+				sellerTin: '301234567',
+				terminalId: 'UZ191211501001',
+				receiptNumber: '12345',
+				fiscalSign: '123456789012',
+				items: [{ name: 'Non', quantity: 1, sum: 5000 }],
+				total: 5000,
+			}),
+		})
+
+		test.equal(result.common.title, '"TEST SAVDO" MChJ')
+		test.deepStrictEqual(result.common.items, [{ name: 'Non', quantity: 1, price: undefined, sum: 5000 }])
+		test.equal(result.common.totalSum, 5000)
+		test.deepStrictEqual(result.extra, {
+			uz_terminalId: 'UZ191211501001',
+			uz_receiptNumber: '12345',
+			uz_fiscalSign: '123456789012',
+			uz_sellerTin: '301234567',
+		})
+		test.deepStrictEqual(result.parseErrors, [])
+	})
+
+	it('should report a page the server could not parse', () => {
+		const result = getUzSoliqReceiptDataFrom(rec)
+
+		test.deepStrictEqual(result.common.items, [])
+		test.deepStrictEqual(result.parseErrors, ['страница чека не разобрана'])
+	})
+})
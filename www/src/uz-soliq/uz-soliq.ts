@@ -0,0 +1,67 @@
+import { Receipt } from '../api'
+import { ReceiptData } from '../receipts'
+import { isRecord, optArr, optNum, OptStr, optStr } from '../utils'
+
+type UzSoliqExtraData = {
+	/** ФМ, фискальный модуль (виртуальная касса) */
+	uz_terminalId: OptStr
+	/** Номер чека в фискальном модуле */
+	uz_receiptNumber: OptStr
+	/** Фискальный признак */
+	uz_fiscalSign: OptStr
+	/** СТИР (ИНН) продавца */
+	uz_sellerTin: OptStr
+}
+
+/**
+ * Сервис отдаёт только HTML-страницу, её разбирает сервер:
+ * продавец, товары и итог приходят в `extractedData` (JSON).
+ */
+export function getUzSoliqReceiptDataFrom(rec: Receipt): ReceiptData<UzSoliqExtraData> {
+	const parseErrors: string[] = []
+	let data: Record<string, unknown> = {}
+	if (rec.extractedData) {
+		const parsed: unknown = JSON.parse(rec.extractedData)
+		if (isRecord(parsed)) data = parsed
+	} else {
+		parseErrors.push('страница чека не разобрана')
+	}
+	const items = optArr(data.items, [])
+
+	return {
+		common: {
+			title: optStr(data.seller),
+
+			items: items.map(item => {
+				const x = isRecord(item) ? item : {}
+				return {
+					name: optStr(x.name),
+					quantity: optNum(x.quantity),
+					price: undefined,
+					sum: optNum(x.sum),
+				}
+			}),
+			itemsCount: items.length,
+			totalSum: optNum(data.total),
+
+			orgName: undefined,
+			placeName: optStr(data.seller),
+			placeAddress: optStr(data.address),
+
+			cashierName: undefined,
+			cashierCode: undefined,
+			shiftNumber: undefined,
+
+			taxOrgUrl: undefined,
+			checkOrgUrl: 'ofd.soliq.uz',
+		},
+		extra: {
+			uz_terminalId: optStr(data.terminalId),
+			uz_receiptNumber: optStr(data.receiptNumber),
+			uz_fiscalSign: optStr(data.fiscalSign),
+			uz_sellerTin: optStr(data.sellerTin),
+		},
+		parseErrors,
+		raw: rec.data,
+	}
+}